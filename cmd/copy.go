@@ -16,10 +16,19 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
+	"fmt"
+	"time"
+
 	"github.com/daangn/dynamoutil/pkg/config"
 	"github.com/daangn/dynamoutil/pkg/db"
+	"github.com/daangn/dynamoutil/pkg/prompt"
+	"github.com/daangn/dynamoutil/pkg/util"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
+
+	. "github.com/logrusorgru/aurora"
 )
 
 const defaultService = "default"
@@ -30,7 +39,10 @@ var copyCmd = &cobra.Command{
 	Short: "Copy items from the origin table, and import on the target table",
 	Long: `This command is working based on DynamoDB's BatchGetItems and BatchWriteItems.
 	This requires read and write capacity of DynamoDB. If you turn on the flag 'on demand'
-	on DynamoDB, please check before executing this command to prevent from billing costs by AWS.`,
+	on DynamoDB, please check before executing this command to prevent from billing costs by AWS.
+	Set 'maxReadCapacity' and 'maxWriteCapacity' in the config to cap the consumed capacity units per second.
+	With '--sync', the key and the hash of every target item are held in memory, so the target should fit in memory.
+	Without '--delete' or '--yes', sync scans both tables twice, to print the plan and ask before deleting target-only items.`,
 	Args: cobra.RangeArgs(0, 1),
 	PreRun: func(cmd *cobra.Command, args []string) {
 		config.MustReadCfgFile()
@@ -43,7 +55,20 @@ var copyCmd = &cobra.Command{
 
 		for _, cfg := range config.MustBind().Copy {
 			if cfg.Service == service {
-				if err := db.Copy(cfg); err != nil {
+				cfg.Scan.Resume, _ = cmd.Flags().GetBool("resume")
+				cfg.CreateTarget, _ = cmd.Flags().GetBool("create-target")
+				if sync, _ := cmd.Flags().GetBool("sync"); sync {
+					cfg.Sync = true
+				}
+				if verify, _ := cmd.Flags().GetBool("verify"); verify {
+					cfg.Verify.Enabled = true
+				}
+				cfg.Delete, _ = cmd.Flags().GetBool("delete")
+				cfg.DryRun, _ = cmd.Flags().GetBool("dry-run")
+				if cfg.DryRun && !cfg.Sync {
+					log.Fatal().Msg("--dry-run is only for sync")
+				}
+				if err := runCopy(cfg); err != nil {
 					log.Fatal().Msgf("failed to sync: %s", err)
 				}
 				return
@@ -55,4 +80,194 @@ var copyCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(copyCmd)
+	copyCmd.Flags().Bool("resume", false, "Continue from the checkpoint of an interrupted copy")
+	copyCmd.Flags().Bool("create-target", false, "Create the target table if it does not exist")
+	copyCmd.Flags().Bool("verify", false, "Read copied items from the target after the copy, and compare their counts and hashes")
+	copyCmd.Flags().Bool("sync", false, "Mirror the origin: skip unchanged items, and delete target-only items after a confirmation")
+	copyCmd.Flags().Bool("delete", false, "Delete target-only items with sync without asking")
+	copyCmd.Flags().Bool("dry-run", false, "Print the number of items to put and delete with sync without writing")
+}
+
+func runCopy(cfg *config.DynamoDBCopyConfig) error {
+	fmt.Println(
+		Bold(Green("Origin")),
+		BrightBlue("region: ").String()+cfg.Origin.Region+" ",
+		BrightBlue("table: ").String()+cfg.Origin.TableName+" ",
+		BrightBlue("endpoint: ").String()+cfg.Origin.Endpoint,
+	)
+	fmt.Println(
+		Bold(Green("Target")),
+		BrightBlue("region: ").String()+cfg.Target.Region+" ",
+		BrightBlue("table: ").String()+cfg.Target.TableName+" ",
+		BrightBlue("endpoint: ").String()+cfg.Target.Endpoint,
+	)
+
+	question := fmt.Sprintf("\nAre you sure about copying all items from %s? [Y/n] ", BrightBlue(cfg.Origin.TableName))
+	if cfg.Sync {
+		question = fmt.Sprintf("\nAre you sure about syncing %s to %s? [Y/n] ", BrightBlue(cfg.Origin.TableName), BrightBlue(cfg.Target.TableName))
+	}
+	ok, err := prompt.Confirm(question)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		fmt.Println(Green("Goodbye👋"))
+		return nil
+	}
+	fmt.Print("\n")
+	if cfg.Scan.Resume {
+		fmt.Println("Resuming from the checkpoint.")
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	if cfg.Sync {
+		return runSync(ctx, cfg)
+	}
+
+	result, err := copyWithTarget(ctx, cfg)
+	if err != nil || result == nil {
+		return err
+	}
+
+	fmt.Printf("Copied %d items of %s table.\nExecution Time: %.2f seconds\nAvg: %.2f ops/s\n",
+		Green(result.Written),
+		BrightBlue(cfg.Origin.TableName),
+		Green(result.Duration.Seconds()),
+		Green(float64(result.Written)/result.Duration.Seconds()),
+	)
+	printRetries(&result.Result)
+	return printVerify(result.Verify)
+}
+
+// runSync prints the plan of a dry run, and asks to delete target-only items before syncing.
+// Planning scans both tables, so it is skipped if nothing is asked.
+func runSync(ctx context.Context, cfg *config.DynamoDBCopyConfig) error {
+	dryRun := cfg.DryRun
+	if dryRun || (!cfg.Delete && !prompt.AssumeYes) {
+		cfg.DryRun = true
+		plan, err := copyWithTarget(ctx, cfg)
+		if err != nil || plan == nil {
+			return err
+		}
+
+		fmt.Printf("Sync plan: put %d items, delete %d target-only items, and skip %d unchanged items.\n",
+			Green(plan.Puts),
+			Red(plan.Deletes),
+			Blue(plan.Unchanged),
+		)
+		if dryRun {
+			return nil
+		}
+
+		if plan.Deletes > 0 {
+			del, err := prompt.Ask(fmt.Sprintf("\nDo you want to delete %d items which are only in %s? [Y/n] ", Red(plan.Deletes), BrightBlue(cfg.Target.TableName)))
+			if err != nil {
+				return errors.Wrap(err, "can't delete target-only items without --delete")
+			}
+			cfg.Delete = del
+		}
+		if plan.Puts == 0 && (plan.Deletes == 0 || !cfg.Delete) {
+			fmt.Println(Green("Nothing to sync."))
+			return nil
+		}
+		fmt.Println()
+	}
+
+	cfg.DryRun = false
+	result, err := copyWithTarget(ctx, cfg)
+	if err != nil || result == nil {
+		return err
+	}
+
+	fmt.Printf("Synced %s table: put %d items, deleted %d items, and skipped %d unchanged items.\nExecution Time: %.2f seconds\n",
+		BrightBlue(cfg.Origin.TableName),
+		Green(result.Written),
+		Red(result.Deleted),
+		Blue(result.Unchanged),
+		Green(result.Duration.Seconds()),
+	)
+	// --yes doesn't delete items, so they are deleted only with --delete or an answer
+	if result.Deletes > 0 && !cfg.Delete {
+		fmt.Printf("%d target-only items are kept. Use --delete to delete them.\n", result.Deletes)
+	}
+	printRetries(&result.Result)
+	return printVerify(result.Verify)
+}
+
+// printVerify prints the result of the verification, and returns an error if it failed.
+func printVerify(result *db.VerifyResult) error {
+	if result == nil {
+		return nil
+	}
+
+	fmt.Printf("\nVerified %d copied items in %.2f seconds.\nOrigin copied %d items, and target has %d items.\n",
+		Green(result.Checked),
+		Green(result.Duration.Seconds()),
+		Green(result.OriginItems),
+		Green(result.TargetItems),
+	)
+	if !result.WholeTable {
+		fmt.Println("Counts are not compared, since a part of the origin is copied.")
+	}
+	for _, d := range result.Mismatches {
+		key := typedJSON(util.TypedDynamo(d.Key))
+		switch d.Kind {
+		case db.DiffMissing:
+			fmt.Println(Red("- missing " + key))
+		case db.DiffChanged:
+			fmt.Println(Yellow("~ changed " + key))
+		}
+	}
+	if shown := int64(len(result.Mismatches)); shown < result.MismatchedItems {
+		fmt.Printf("... and %d more items\n", result.MismatchedItems-shown)
+	}
+
+	if result.MismatchedItems > 0 {
+		return errors.Errorf("%d copied items don't match the target", result.MismatchedItems)
+	}
+	if !result.OK() {
+		return errors.Errorf("target has %d items, but %d items are copied", result.TargetItems, result.OriginItems)
+	}
+	fmt.Println(Green("Target matches the copied items."))
+	return nil
+}
+
+// copyWithTarget copies with progress, and asks to create the target table if it doesn't exist.
+// It returns nil without an error if the table is not created.
+func copyWithTarget(ctx context.Context, cfg *config.DynamoDBCopyConfig) (*db.CopyResult, error) {
+	result, err := copyWithProgress(ctx, cfg)
+	if !errors.Is(err, db.ErrTargetNotFound) {
+		return result, err
+	}
+
+	// --yes doesn't create tables, so the table is created only with --create-target or an answer
+	if prompt.AssumeYes {
+		return nil, errors.Wrap(err, "use --create-target to create it")
+	}
+
+	create, err := prompt.Ask(fmt.Sprintf("\nTable does not exist on <%s>.\nDo you want to create %s table at target endpoint?[Y/n] ",
+		BrightBlue(fmt.Sprintf("%s %s %s", cfg.Target.Region, cfg.Target.TableName, cfg.Target.Endpoint)),
+		BrightBlue(cfg.Target.TableName),
+	))
+	if err != nil {
+		return nil, errors.Wrap(err, "can't create the target table without --create-target")
+	}
+	if !create {
+		fmt.Println("Goodbye~ 👋")
+		return nil, nil
+	}
+
+	fmt.Println()
+	cfg.CreateTarget = true
+	return copyWithProgress(ctx, cfg)
+}
+
+func copyWithProgress(ctx context.Context, cfg *config.DynamoDBCopyConfig) (*db.CopyResult, error) {
+	p := newProgress("failed to copy an item", func(elapsed time.Duration, read, written, failed int64) string {
+		return fmt.Sprintf("\tTime spent: %.1f. Read %d items, Writes %d items. %.2f items/s", elapsed.Seconds(), Blue(read), Blue(written), Blue(float64(written)/elapsed.Seconds()))
+	})
+	defer p.Stop()
+	return db.Copy(ctx, cfg, &db.Options{Progress: p})
 }
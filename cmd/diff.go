@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/config"
+	"github.com/daangn/dynamoutil/pkg/db"
+	"github.com/daangn/dynamoutil/pkg/util"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	. "github.com/logrusorgru/aurora"
+)
+
+// Exit codes of diff, which are the same as diff(1)
+const (
+	diffExitDiffer = 1
+	diffExitError  = 2
+)
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Compare items of the origin table with the target table or a dump file",
+	Long: `This command scans the origin and the target tables, or reads a dump file instead of the target,
+	and matches items by the primary key of the origin. It reports missing, extra and changed items.
+	This requires read capacity of DynamoDB, and holds items of the origin in memory.
+	The exit code is 0 if they are the same, 1 if they differ, and 2 if the command fails.`,
+	Args: cobra.RangeArgs(0, 1),
+	PreRun: func(cmd *cobra.Command, args []string) {
+		// JSON is the only output on stdout
+		if output, _ := cmd.Flags().GetString("output"); output == "json" {
+			config.MustReadCfgFileTo(os.Stderr)
+			return
+		}
+		config.MustReadCfgFile()
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		service := defaultService
+		if len(args) == 1 {
+			service = args[0]
+		}
+		output, _ := cmd.Flags().GetString("output")
+		if output != "text" && output != "json" {
+			log.Error().Msgf("'%s' is not a valid output. (text or json)", output)
+			os.Exit(diffExitError)
+		}
+
+		for _, cfg := range config.MustBind().Diff {
+			if cfg.Service == service {
+				equal, err := runDiff(cfg, output)
+				if err != nil {
+					log.Error().Msgf("failed to diff: %s", err)
+				}
+				if code := diffExitCode(equal, err); code != 0 {
+					os.Exit(code)
+				}
+				return
+			}
+		}
+		log.Error().Msgf("'%s' is not a valid service", service)
+		os.Exit(diffExitError)
+	},
+}
+
+// diffExitCode returns 0 if items are the same, diffExitDiffer if they differ, and diffExitError if the command fails.
+func diffExitCode(equal bool, err error) int {
+	switch {
+	case err != nil:
+		return diffExitError
+	case !equal:
+		return diffExitDiffer
+	}
+	return 0
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+	diffCmd.Flags().StringP("output", "o", "text", "Output format of differences. (text or json)")
+}
+
+// runDiff prints differences, and returns true if there are none.
+func runDiff(cfg *config.DynamoDBDiffConfig, output string) (bool, error) {
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	if output == "json" {
+		result, err := db.Diff(ctx, cfg, nil)
+		if err != nil {
+			return false, err
+		}
+		return result.Equal(), printDiffJSON(result)
+	}
+
+	fmt.Println(
+		Bold(Green("Origin")),
+		BrightBlue("region: ").String()+cfg.Origin.Region+" ",
+		BrightBlue("table: ").String()+cfg.Origin.TableName+" ",
+		BrightBlue("endpoint: ").String()+cfg.Origin.Endpoint,
+	)
+	if cfg.File != "" {
+		fmt.Println(Bold(Green("Target")), BrightBlue("file: ").String()+cfg.File)
+	} else if cfg.Target != nil {
+		fmt.Println(
+			Bold(Green("Target")),
+			BrightBlue("region: ").String()+cfg.Target.Region+" ",
+			BrightBlue("table: ").String()+cfg.Target.TableName+" ",
+			BrightBlue("endpoint: ").String()+cfg.Target.Endpoint,
+		)
+	}
+	fmt.Print("\n")
+
+	p := newProgress("rejected an item", func(elapsed time.Duration, read, written, failed int64) string {
+		return fmt.Sprintf("\tTime spent: %.1f. Read %d items. %.2f items/s", elapsed.Seconds(), Blue(read), Blue(float64(read)/elapsed.Seconds()))
+	})
+	result, err := db.Diff(ctx, cfg, &db.Options{Progress: p})
+	p.Stop()
+	if err != nil {
+		return false, err
+	}
+
+	for _, d := range result.Diffs {
+		key := typedJSON(util.TypedDynamo(d.Key))
+		switch d.Kind {
+		case db.DiffMissing:
+			fmt.Println(Red("- missing " + key))
+		case db.DiffExtra:
+			fmt.Println(Green("+ extra   " + key))
+		case db.DiffChanged:
+			fmt.Println(Yellow("~ changed " + key))
+			for _, a := range d.Attributes {
+				fmt.Printf("    %s: %s -> %s\n", BrightBlue(a.Name), Red(typedValue(a.Origin)), Green(typedValue(a.Target)))
+			}
+		}
+	}
+	if shown := int64(len(result.Diffs)); shown < result.MissingItems+result.ExtraItems+result.ChangedItems {
+		fmt.Printf("... and %d more items\n", result.MissingItems+result.ExtraItems+result.ChangedItems-shown)
+	}
+
+	fmt.Printf("\nOrigin has %d items, and target has %d items.\nMissing %d, Extra %d, Changed %d items.\nExecution Time: %.2f seconds\n",
+		Green(result.OriginItems),
+		Green(result.TargetItems),
+		Red(result.MissingItems),
+		Green(result.ExtraItems),
+		Yellow(result.ChangedItems),
+		Green(result.Duration.Seconds()),
+	)
+	if result.Failed > 0 {
+		fmt.Printf("Rejected %d lines of the file.\n", Red(result.Failed))
+	}
+	if result.Equal() {
+		fmt.Println(Green("Tables are the same."))
+	}
+	return result.Equal(), nil
+}
+
+func printDiffJSON(result *db.DiffResult) error {
+	diffs := result.Diffs
+	if diffs == nil {
+		diffs = []db.ItemDiff{}
+	}
+	b, err := json.MarshalIndent(struct {
+		Equal        bool          `json:"equal"`
+		OriginItems  int64         `json:"originItems"`
+		TargetItems  int64         `json:"targetItems"`
+		MissingItems int64         `json:"missingItems"`
+		ExtraItems   int64         `json:"extraItems"`
+		ChangedItems int64         `json:"changedItems"`
+		Rejected     int64         `json:"rejected"`
+		Diffs        []db.ItemDiff `json:"diffs"`
+	}{
+		Equal:        result.Equal(),
+		OriginItems:  result.OriginItems,
+		TargetItems:  result.TargetItems,
+		MissingItems: result.MissingItems,
+		ExtraItems:   result.ExtraItems,
+		ChangedItems: result.ChangedItems,
+		Rejected:     result.Failed,
+		Diffs:        diffs,
+	}, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to write JSON")
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+func typedValue(av *dynamodb.AttributeValue) string {
+	if av == nil {
+		return "(missing)"
+	}
+	return typedJSON(util.TypedDynamoValue(av))
+}
+
+func typedJSON(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDiffExitCode(t *testing.T) {
+	tests := []struct {
+		name  string
+		equal bool
+		err   error
+		want  int
+	}{
+		{"same", true, nil, 0},
+		{"different", false, nil, 1},
+		{"failed", false, errors.New("failed"), 2},
+		// runDiff returns the result with an error of printing it
+		{"failed to print", true, errors.New("failed"), 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffExitCode(tt.equal, tt.err); got != tt.want {
+				t.Errorf("diffExitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
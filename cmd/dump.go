@@ -1,10 +1,16 @@
 package cmd
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/daangn/dynamoutil/pkg/config"
 	"github.com/daangn/dynamoutil/pkg/db"
+	"github.com/daangn/dynamoutil/pkg/prompt"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
+
+	. "github.com/logrusorgru/aurora"
 )
 
 // dumpCmd represents the dump command
@@ -26,7 +32,8 @@ var dumpCmd = &cobra.Command{
 
 		for _, cfg := range config.MustBind().Dump {
 			if cfg.Service == service {
-				if err := db.Dump(cfg); err != nil {
+				cfg.Scan.Resume, _ = cmd.Flags().GetBool("resume")
+				if err := runDump(cfg); err != nil {
 					log.Fatal().Msgf("failed to sync: %s", err)
 				}
 				return
@@ -38,4 +45,47 @@ var dumpCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(dumpCmd)
+	dumpCmd.Flags().Bool("resume", false, "Continue from the checkpoint of an interrupted dump")
+}
+
+func runDump(cfg *config.DynamoDBDumpConfig) error {
+	fmt.Println(
+		Bold(Green("service: ").String()+cfg.Service+" "),
+		BrightBlue("region: ").String()+cfg.DynamoDB.Region+" ",
+		BrightBlue("table: ").String()+cfg.DynamoDB.TableName+" ",
+		BrightBlue("endpoint: ").String()+cfg.DynamoDB.Endpoint+" ",
+		BrightBlue("output: ").String()+string(cfg.Output)+" ",
+	)
+
+	ok, err := prompt.Confirm(fmt.Sprintf("\nAre you sure about dumping all items from %s? [Y/n] ", BrightBlue(cfg.DynamoDB.TableName)))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		fmt.Println(Green("Goodbye👋"))
+		return nil
+	}
+	fmt.Print("\n")
+	if cfg.Scan.Resume {
+		fmt.Println("Resuming from the checkpoint.")
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	p := newProgress("failed to write an item", func(elapsed time.Duration, read, written, failed int64) string {
+		return fmt.Sprintf("    Writes %d items. %.2f items/s", Blue(written), Blue(float64(written)/elapsed.Seconds()))
+	})
+	result, err := db.Dump(ctx, cfg, &db.Options{Progress: p})
+	p.Stop()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Dumped %d items of %s table.\nExecution Time: %.2f seconds\n",
+		Green(result.Written),
+		BrightBlue(cfg.DynamoDB.TableName),
+		Green(result.Duration.Seconds()),
+	)
+	return nil
 }
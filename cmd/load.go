@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/daangn/dynamoutil/pkg/config"
+	"github.com/daangn/dynamoutil/pkg/db"
+	"github.com/daangn/dynamoutil/pkg/prompt"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	. "github.com/logrusorgru/aurora"
+)
+
+// loadCmd represents the load command
+var loadCmd = &cobra.Command{
+	Use:     "load",
+	Aliases: []string{"restore"},
+	Short:   "Load items from a dump file into the table",
+	Long: `This command reads a file written by the 'dump' command, and imports its items
+	with DynamoDB's BatchWriteItems. This requires write capacity of DynamoDB. If you turn on the flag 'on demand'
+	on DynamoDB, please check before executing this command to prevent from billing costs by AWS.`,
+	Args: cobra.RangeArgs(0, 1),
+	PreRun: func(cmd *cobra.Command, args []string) {
+		config.MustReadCfgFile()
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		service := defaultService
+		if len(args) == 1 {
+			service = args[0]
+		}
+
+		for _, cfg := range config.MustBind().Load {
+			if cfg.Service == service {
+				if err := runLoad(cfg); err != nil {
+					log.Fatal().Msgf("failed to load: %s", err)
+				}
+				return
+			}
+		}
+		log.Error().Msgf("'%s' is not a valid service", service)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(loadCmd)
+}
+
+func runLoad(cfg *config.DynamoDBLoadConfig) error {
+	if cfg.FileName == "" {
+		cfg.FileName = cfg.DynamoDB.TableName
+	}
+	if cfg.Input == "" {
+		cfg.Input = config.DefaultOutput
+	}
+
+	fmt.Println(
+		Bold(Green("service: ").String()+cfg.Service+" "),
+		BrightBlue("region: ").String()+cfg.DynamoDB.Region+" ",
+		BrightBlue("table: ").String()+cfg.DynamoDB.TableName+" ",
+		BrightBlue("endpoint: ").String()+cfg.DynamoDB.Endpoint+" ",
+		BrightBlue("file: ").String()+cfg.FileName+" ",
+		BrightBlue("input: ").String()+string(cfg.Input)+" ",
+	)
+
+	ok, err := prompt.Confirm(fmt.Sprintf("\nAre you sure about loading all items from %s into %s? [Y/n] ", BrightBlue(cfg.FileName), BrightBlue(cfg.DynamoDB.TableName)))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		fmt.Println(Green("Goodbye👋"))
+		return nil
+	}
+	fmt.Print("\n")
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	p := newProgress("rejected an item", func(elapsed time.Duration, read, written, failed int64) string {
+		return fmt.Sprintf("\tTime spent: %.1f. Read %d items, Writes %d items, Rejected %d lines. %.2f items/s", elapsed.Seconds(), Blue(read), Blue(written), Red(failed), Blue(float64(written)/elapsed.Seconds()))
+	})
+	result, err := db.Load(ctx, cfg, &db.Options{Progress: p})
+	p.Stop()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Loaded %d items into %s table.\nRejected %d lines.\nExecution Time: %.2f seconds\nAvg: %.2f ops/s\n",
+		Green(result.Written),
+		BrightBlue(cfg.DynamoDB.TableName),
+		Red(result.Failed),
+		Green(result.Duration.Seconds()),
+		Green(float64(result.Written)/result.Duration.Seconds()),
+	)
+	printRetries(result)
+	return nil
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/daangn/dynamoutil/pkg/db"
+	"github.com/rs/zerolog/log"
+
+	. "github.com/logrusorgru/aurora"
+)
+
+// progressPrinter prints the progress of a command every 100ms.
+// It implements db.Progress.
+type progressPrinter struct {
+	read    int64
+	written int64
+	failed  int64
+	started int32
+
+	now   time.Time
+	line  func(elapsed time.Duration, read, written, failed int64) string
+	stop  chan struct{}
+	done  chan struct{}
+	label string
+}
+
+// newProgress starts printing the line. Nothing is printed until items are processed.
+// label is the message to log failed items with.
+func newProgress(label string, line func(elapsed time.Duration, read, written, failed int64) string) *progressPrinter {
+	p := &progressPrinter{
+		now:   time.Now(),
+		line:  line,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+		label: label,
+	}
+	go func() {
+		defer close(p.done)
+
+		ticker := time.NewTicker(time.Millisecond * 100)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.print()
+			case <-p.stop:
+				p.print()
+				return
+			}
+		}
+	}()
+	return p
+}
+
+func (p *progressPrinter) Read(n int) {
+	atomic.StoreInt32(&p.started, 1)
+	atomic.AddInt64(&p.read, int64(n))
+}
+
+func (p *progressPrinter) Written(n int) {
+	atomic.StoreInt32(&p.started, 1)
+	atomic.AddInt64(&p.written, int64(n))
+}
+
+func (p *progressPrinter) Failed(err error) {
+	atomic.AddInt64(&p.failed, 1)
+	log.Err(err).Msg(p.label)
+}
+
+func (p *progressPrinter) print() {
+	if atomic.LoadInt32(&p.started) == 0 {
+		return
+	}
+	fmt.Print("\r" + p.line(time.Since(p.now), atomic.LoadInt64(&p.read), atomic.LoadInt64(&p.written), atomic.LoadInt64(&p.failed)))
+}
+
+// Stop prints the last progress.
+func (p *progressPrinter) Stop() {
+	close(p.stop)
+	<-p.done
+	if atomic.LoadInt32(&p.started) == 1 {
+		fmt.Print("\n\n")
+	}
+}
+
+// commandContext returns a context canceled by SIGINT or SIGTERM.
+// Checkpoints are kept, so a canceled command can be resumed.
+func commandContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-ch:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(ch)
+	}()
+	return ctx, cancel
+}
+
+// printRetries prints the number of backed off writes of the result, if any.
+func printRetries(result *db.Result) {
+	if result.Retries == 0 {
+		return
+	}
+	fmt.Printf("Retried %d writes, %d of them throttled.\n", Yellow(result.Retries), Yellow(result.Throttles))
+}
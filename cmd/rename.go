@@ -1,10 +1,16 @@
 package cmd
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/daangn/dynamoutil/pkg/config"
 	"github.com/daangn/dynamoutil/pkg/db"
+	"github.com/daangn/dynamoutil/pkg/prompt"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
+
+	. "github.com/logrusorgru/aurora"
 )
 
 // renameCmd represents the rename command
@@ -26,7 +32,14 @@ var renameCmd = &cobra.Command{
 
 		for _, cfg := range config.MustBind().Rename {
 			if cfg.Service == service {
-				if err := db.Rename(cfg); err != nil {
+				cfg.Scan.Resume, _ = cmd.Flags().GetBool("resume")
+				if journal, _ := cmd.Flags().GetString("rollback"); journal != "" {
+					if err := runRollback(cfg, journal); err != nil {
+						log.Fatal().Msgf("failed to roll back renames: %s", err)
+					}
+					return
+				}
+				if err := runRename(cfg); err != nil {
 					log.Fatal().Msgf("failed to rename attributes: %s", err)
 				}
 				return
@@ -38,4 +51,110 @@ var renameCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(renameCmd)
+	renameCmd.Flags().Bool("resume", false, "Continue from the checkpoint of an interrupted rename")
+	renameCmd.Flags().String("rollback", "", "Restore original attributes of renamed items from the journal file")
+}
+
+func runRename(cfg *config.DynamoDBRenameConfig) error {
+	fmt.Println(
+		Bold(Green("Target")),
+		BrightBlue("region: ").String()+cfg.Target.Region+" ",
+		BrightBlue("table: ").String()+cfg.Target.TableName+" ",
+		BrightBlue("endpoint: ").String()+cfg.Target.Endpoint,
+	)
+
+	ok, err := prompt.Confirm(fmt.Sprintf("\nAre you sure about renaming attributes in %s? [Y/n] ", BrightBlue(cfg.Target.TableName)))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		fmt.Println(Green("Goodbye👋"))
+		return nil
+	}
+	fmt.Print("\n")
+	if cfg.Scan.Resume {
+		fmt.Println("Resuming from the checkpoint.")
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	p := newProgress("failed to rename an item", func(elapsed time.Duration, read, written, failed int64) string {
+		return fmt.Sprintf("\tTime spent: %.1f. Read %d items, Processed %d items. %.2f items/s", elapsed.Seconds(), Blue(read), Blue(written), Blue(float64(written)/elapsed.Seconds()))
+	})
+	result, err := db.Rename(ctx, cfg, &db.Options{Progress: p})
+	p.Stop()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Renamed %d items of %s table.\nExecution Time: %.2f seconds\nAvg: %.2f ops/s\n",
+		Green(result.Written),
+		BrightBlue(cfg.Target.TableName),
+		Green(result.Duration.Seconds()),
+		Green(float64(result.Written)/result.Duration.Seconds()),
+	)
+	printRetries(&result.Result)
+	fmt.Printf("Journal: %s\n", BrightBlue(result.Journal))
+
+	// Print metrics for each rename operation
+	fmt.Println("\nDetailed Rename Metrics:")
+	for _, metric := range result.Renames {
+		key := fmt.Sprintf("%s -> %s", metric.Before, metric.After)
+		if metric.Count == 0 {
+			fmt.Printf("%s: No items changed\n", BrightBlue(key))
+			continue
+		}
+
+		avgTime := metric.Duration.Seconds() / float64(metric.Count)
+		fmt.Printf("%s: %d items changed, Total Time: %.2f seconds, Avg Time per item: %.4f seconds\n",
+			BrightBlue(key),
+			Green(metric.Count),
+			Green(metric.Duration.Seconds()),
+			Green(avgTime),
+		)
+	}
+	return nil
+}
+
+func runRollback(cfg *config.DynamoDBRenameConfig, journal string) error {
+	fmt.Println(
+		Bold(Green("Target")),
+		BrightBlue("region: ").String()+cfg.Target.Region+" ",
+		BrightBlue("table: ").String()+cfg.Target.TableName+" ",
+		BrightBlue("endpoint: ").String()+cfg.Target.Endpoint,
+	)
+
+	ok, err := prompt.Confirm(fmt.Sprintf("\nAre you sure about rolling back renames of %s from %s? [Y/n] ", BrightBlue(cfg.Target.TableName), BrightBlue(journal)))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		fmt.Println(Green("Goodbye👋"))
+		return nil
+	}
+	fmt.Print("\n")
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	p := newProgress("failed to restore an item", func(elapsed time.Duration, read, written, failed int64) string {
+		return fmt.Sprintf("\tTime spent: %.1f. Read %d entries, Restored %d items. %.2f items/s", elapsed.Seconds(), Blue(read), Blue(written), Blue(float64(written)/elapsed.Seconds()))
+	})
+	result, err := db.RollbackRename(ctx, cfg, journal, &db.Options{Progress: p})
+	p.Stop()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Restored %d items of %s table.\nExecution Time: %.2f seconds\n",
+		Green(result.Written),
+		BrightBlue(cfg.Target.TableName),
+		Green(result.Duration.Seconds()),
+	)
+	if result.Failed > 0 {
+		fmt.Printf("%d items were not restored, since they were changed after the rename or were not renamed.\n", Red(result.Failed))
+	}
+	printRetries(result)
+	return nil
 }
@@ -18,7 +18,9 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strconv"
 
+	"github.com/daangn/dynamoutil/pkg/prompt"
 	"github.com/spf13/cobra"
 
 	homedir "github.com/mitchellh/go-homedir"
@@ -26,6 +28,7 @@ import (
 )
 
 var cfgFile string
+var assumeYes bool
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -45,6 +48,7 @@ func Execute() {
 func init() {
 	cobra.OnInitialize(initConfig)
 	rootCmd.PersistentFlags().StringVarP(&cfgFile, "config", "c", "", "config file (default is $HOME/.dynamoutil.yaml)")
+	rootCmd.PersistentFlags().BoolVarP(&assumeYes, "yes", "y", false, "answer yes to every confirmation (or set "+prompt.AssumeYesEnv+"=true)")
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 }
 
@@ -64,4 +68,7 @@ func initConfig() {
 	}
 
 	viper.AutomaticEnv()
+
+	envYes, _ := strconv.ParseBool(os.Getenv(prompt.AssumeYesEnv))
+	prompt.AssumeYes = assumeYes || envYes
 }
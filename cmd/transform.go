@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/daangn/dynamoutil/pkg/config"
+	"github.com/daangn/dynamoutil/pkg/db"
+	"github.com/daangn/dynamoutil/pkg/prompt"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	. "github.com/logrusorgru/aurora"
+)
+
+// transformCmd represents the transform command
+var transformCmd = &cobra.Command{
+	Use:   "transform",
+	Short: "Transform attributes in the DynamoDB table as defined in the configuration",
+	Long: `This command applies operations defined in the configuration file to every item
+    of the DynamoDB table in order, such as removing, setting, converting, copying and renaming
+    attributes. This requires read and write capacity on the DynamoDB table.`,
+	Args: cobra.RangeArgs(0, 1),
+	PreRun: func(cmd *cobra.Command, args []string) {
+		config.MustReadCfgFile()
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		service := defaultService
+		if len(args) == 1 {
+			service = args[0]
+		}
+
+		for _, cfg := range config.MustBind().Transform {
+			if cfg.Service == service {
+				cfg.Scan.Resume, _ = cmd.Flags().GetBool("resume")
+				if journal, _ := cmd.Flags().GetString("rollback"); journal != "" {
+					if err := runTransformRollback(cfg, journal); err != nil {
+						log.Fatal().Msgf("failed to roll back transform: %s", err)
+					}
+					return
+				}
+				if err := runTransform(cfg); err != nil {
+					log.Fatal().Msgf("failed to transform items: %s", err)
+				}
+				return
+			}
+		}
+		log.Error().Msgf("'%s' is not a valid service", service)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(transformCmd)
+	transformCmd.Flags().Bool("resume", false, "Continue from the checkpoint of an interrupted transform")
+	transformCmd.Flags().String("rollback", "", "Restore original attributes of transformed items from the journal file")
+}
+
+func runTransform(cfg *config.DynamoDBTransformConfig) error {
+	fmt.Println(
+		Bold(Green("Target")),
+		BrightBlue("region: ").String()+cfg.Target.Region+" ",
+		BrightBlue("table: ").String()+cfg.Target.TableName+" ",
+		BrightBlue("endpoint: ").String()+cfg.Target.Endpoint,
+	)
+	fmt.Println(Bold(Green("Operations")))
+	for i, op := range cfg.Operations {
+		fmt.Printf("\t%d. %s\n", i+1, describeOperation(op))
+	}
+
+	ok, err := prompt.Confirm(fmt.Sprintf("\nAre you sure about transforming items in %s? [Y/n] ", BrightBlue(cfg.Target.TableName)))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		fmt.Println(Green("Goodbye👋"))
+		return nil
+	}
+	fmt.Print("\n")
+	if cfg.Scan.Resume {
+		fmt.Println("Resuming from the checkpoint.")
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	p := newProgress("failed to transform an item", func(elapsed time.Duration, read, written, failed int64) string {
+		return fmt.Sprintf("\tTime spent: %.1f. Read %d items, Processed %d items. %.2f items/s", elapsed.Seconds(), Blue(read), Blue(written), Blue(float64(written)/elapsed.Seconds()))
+	})
+	result, err := db.Transform(ctx, cfg, &db.Options{Progress: p})
+	p.Stop()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Transformed %d items of %s table.\nExecution Time: %.2f seconds\nAvg: %.2f ops/s\n",
+		Green(result.Written),
+		BrightBlue(cfg.Target.TableName),
+		Green(result.Duration.Seconds()),
+		Green(float64(result.Written)/result.Duration.Seconds()),
+	)
+	if result.Failed > 0 {
+		fmt.Printf("%d items were not transformed.\n", Red(result.Failed))
+	}
+	printRetries(&result.Result)
+	fmt.Printf("Journal: %s\n", BrightBlue(result.Journal))
+
+	// Print metrics for each operation
+	fmt.Println("\nDetailed Transform Metrics:")
+	for i, metric := range result.Operations {
+		key := describeOperation(cfg.Operations[i])
+		if metric.Count == 0 {
+			fmt.Printf("%s: No items changed\n", BrightBlue(key))
+			continue
+		}
+
+		avgTime := metric.Duration.Seconds() / float64(metric.Count)
+		fmt.Printf("%s: %d items changed, Total Time: %.2f seconds, Avg Time per item: %.4f seconds\n",
+			BrightBlue(key),
+			Green(metric.Count),
+			Green(metric.Duration.Seconds()),
+			Green(avgTime),
+		)
+	}
+	return nil
+}
+
+// describeOperation returns an operation in a line. e.g. convert createdAt to S (iso8601)
+func describeOperation(op config.TransformOperation) string {
+	switch {
+	case len(op.From) > 0:
+		return fmt.Sprintf("%s %s -> %s", op.Op, strings.Join(op.From, ", "), op.Path)
+	case op.Value != nil:
+		return fmt.Sprintf("%s %s = %v", op.Op, op.Path, op.Value)
+	case op.Format != "":
+		return fmt.Sprintf("%s %s to %s (%s)", op.Op, op.Path, op.To, op.Format)
+	case op.To != "":
+		return fmt.Sprintf("%s %s to %s", op.Op, op.Path, op.To)
+	default:
+		return fmt.Sprintf("%s %s", op.Op, op.Path)
+	}
+}
+
+func runTransformRollback(cfg *config.DynamoDBTransformConfig, journal string) error {
+	fmt.Println(
+		Bold(Green("Target")),
+		BrightBlue("region: ").String()+cfg.Target.Region+" ",
+		BrightBlue("table: ").String()+cfg.Target.TableName+" ",
+		BrightBlue("endpoint: ").String()+cfg.Target.Endpoint,
+	)
+
+	ok, err := prompt.Confirm(fmt.Sprintf("\nAre you sure about rolling back the transform of %s from %s? [Y/n] ", BrightBlue(cfg.Target.TableName), BrightBlue(journal)))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		fmt.Println(Green("Goodbye👋"))
+		return nil
+	}
+	fmt.Print("\n")
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	p := newProgress("failed to restore an item", func(elapsed time.Duration, read, written, failed int64) string {
+		return fmt.Sprintf("\tTime spent: %.1f. Read %d entries, Restored %d items. %.2f items/s", elapsed.Seconds(), Blue(read), Blue(written), Blue(float64(written)/elapsed.Seconds()))
+	})
+	result, err := db.RollbackTransform(ctx, cfg, journal, &db.Options{Progress: p})
+	p.Stop()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Restored %d items of %s table.\nExecution Time: %.2f seconds\n",
+		Green(result.Written),
+		BrightBlue(cfg.Target.TableName),
+		Green(result.Duration.Seconds()),
+	)
+	if result.Failed > 0 {
+		fmt.Printf("%d items were not restored, since they were changed after the transform or were not transformed.\n", Red(result.Failed))
+	}
+	printRetries(result)
+	return nil
+}
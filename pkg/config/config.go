@@ -2,7 +2,11 @@ package config
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"time"
 
+	"github.com/daangn/dynamoutil/pkg/util"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
 
@@ -11,9 +15,12 @@ import (
 
 // Config represents a global configuration
 type Config struct {
-	Copy   []*DynamoDBCopyConfig   `mapstructure:"copy"`
-	Dump   []*DynamoDBDumpConfig   `mapstructure:"dump"`
-	Rename []*DynamoDBRenameConfig `mapstructure:"rename"`
+	Copy      []*DynamoDBCopyConfig      `mapstructure:"copy"`
+	Dump      []*DynamoDBDumpConfig      `mapstructure:"dump"`
+	Rename    []*DynamoDBRenameConfig    `mapstructure:"rename"`
+	Load      []*DynamoDBLoadConfig      `mapstructure:"load"`
+	Diff      []*DynamoDBDiffConfig      `mapstructure:"diff"`
+	Transform []*DynamoDBTransformConfig `mapstructure:"transform"`
 }
 
 // Output represents a file extension
@@ -23,11 +30,27 @@ type Output string
 const (
 	OutputJSON    Output = "json"
 	OutputJSONRaw Output = "jsonRaw"
+	// OutputDynamoDBJSON writes an item per line with the layout of DynamoDB S3 export.
+	// e.g. {"Item":{"pk":{"S":"value"}}}
+	OutputDynamoDBJSON Output = "dynamodbJson"
+	// OutputAttributeValue writes a typed item per line.
+	// e.g. {"pk":{"S":"value"}}
+	OutputAttributeValue Output = "attributeValue"
+	// OutputCSV and OutputTSV write a header of attribute names, and an item per row.
+	OutputCSV Output = "csv"
+	OutputTSV Output = "tsv"
+	// OutputParquet writes parquet files with a schema inferred from items.
+	OutputParquet Output = "parquet"
 )
 
 // DefaultOutput represents the default output
 var DefaultOutput = OutputJSONRaw
 
+// Typed returns true if the output keeps types of attributes
+func (o Output) Typed() bool {
+	return o == OutputDynamoDBJSON || o == OutputAttributeValue
+}
+
 // DumpPrefix returns a prefix string for dump
 func (o Output) DumpPrefix() []byte {
 	switch o {
@@ -58,24 +81,250 @@ func (o Output) DumpSuffix() []byte {
 	}
 }
 
+// Ext returns a file extension of the output
+func (o Output) Ext() string {
+	switch o {
+	case OutputJSON:
+		return ".json"
+	case OutputCSV:
+		return ".csv"
+	case OutputTSV:
+		return ".tsv"
+	case OutputParquet:
+		return ".parquet"
+	default:
+		return ".jsonl"
+	}
+}
+
+// Compression represents a compression of dump files
+type Compression string
+
+// Compression constants
+const (
+	CompressionNone Compression = ""
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+)
+
+// Ext returns a file extension of the compression
+func (c Compression) Ext() string {
+	switch c {
+	case CompressionGzip:
+		return ".gz"
+	case CompressionZstd:
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
 // RenameAttribute defines a before and after pair for attribute renaming.
+// They are document paths such as profile.address.zip, items[0] or items[*].price.
+// A dot, a bracket or a backslash of an attribute name is escaped with a backslash, like a\.b.
 type RenameAttribute struct {
 	Before string `mapstructure:"before"`
 	After  string `mapstructure:"after"`
 }
 
+// ScanConfig represents options for parallel scan
+type ScanConfig struct {
+	// TotalSegments splits the table into segments to be scanned in parallel
+	TotalSegments int `mapstructure:"totalSegments"`
+	// Workers is the number of segments scanned at the same time. Default is TotalSegments
+	Workers int `mapstructure:"workers"`
+	// Checkpoint is the file to save the progress of the scan after every page.
+	// It is removed when the command is finished.
+	Checkpoint string `mapstructure:"checkpoint"`
+	// Resume continues from Checkpoint. It is set by --resume flag
+	Resume bool `mapstructure:"-"`
+}
+
+// ThroughputConfig represents limits of consumed capacity units per second.
+// They are enforced with capacity returned by DynamoDB, so that live traffic isn't starved.
+// The limits are lowered while requests are throttled, and raised back after the throttling clears.
+// Zero is unlimited.
+type ThroughputConfig struct {
+	// TargetPercent limits capacity to a percentage of the provisioned capacity of the table. e.g. 30
+	// On-demand tables have no provisioned capacity, so MaxReadCapacity and MaxWriteCapacity are ceilings of them.
+	TargetPercent float64 `mapstructure:"targetPercent"`
+	// MaxReadCapacity limits Scan of the table to read
+	MaxReadCapacity float64 `mapstructure:"maxReadCapacity"`
+	// MaxWriteCapacity limits BatchWriteItem to the table to write
+	MaxWriteCapacity float64 `mapstructure:"maxWriteCapacity"`
+}
+
+// SelectConfig represents items and attributes to read from the table
+type SelectConfig struct {
+	// Index is the name of a global or local secondary index to read instead of the table
+	Index string `mapstructure:"index"`
+	// Filter is applied to items after they are read, so it doesn't reduce consumed capacity
+	Filter FilterConfig `mapstructure:"filter"`
+	// Projection is a list of top-level attributes to read. Default is all attributes
+	Projection []string `mapstructure:"projection"`
+	// Query reads partitions with Query instead of scanning the whole table
+	Query QueryConfig `mapstructure:"query"`
+}
+
+// QueryConfig represents partitions to read with Query.
+// Keys are of Index if it is set. Values are converted to the types of the key attributes.
+type QueryConfig struct {
+	// PartitionKeys are values of the partition key
+	PartitionKeys []interface{} `mapstructure:"partitionKeys"`
+	// PartitionKeysFile is a file of partition key values, a value per line
+	PartitionKeysFile string `mapstructure:"partitionKeysFile"`
+	// SortKey is a condition of the sort key. Default reads the whole partition
+	SortKey SortKeyCondition `mapstructure:"sortKey"`
+}
+
+// Enabled returns true if partition keys are given
+func (c QueryConfig) Enabled() bool {
+	return len(c.PartitionKeys) > 0 || c.PartitionKeysFile != ""
+}
+
+// SortKeyCondition represents a condition of the sort key. Only one of them can be set.
+type SortKeyCondition struct {
+	Eq  interface{} `mapstructure:"eq"`
+	Lt  interface{} `mapstructure:"lt"`
+	Lte interface{} `mapstructure:"lte"`
+	Gt  interface{} `mapstructure:"gt"`
+	Gte interface{} `mapstructure:"gte"`
+	// Between is a pair of inclusive bounds
+	Between    []interface{} `mapstructure:"between"`
+	BeginsWith interface{}   `mapstructure:"beginsWith"`
+}
+
+// FilterConfig represents a FilterExpression of DynamoDB.
+// e.g. "#status = :status AND begins_with(tenant, :tenant)"
+type FilterConfig struct {
+	Expression string `mapstructure:"expression"`
+	// NOTE: lists are used instead of maps since viper lowercases map keys
+	Names  []ExpressionName  `mapstructure:"names"`
+	Values []ExpressionValue `mapstructure:"values"`
+}
+
+// ExpressionName is a placeholder of an attribute name. e.g. #status
+type ExpressionName struct {
+	Name      string `mapstructure:"name"`
+	Attribute string `mapstructure:"attribute"`
+}
+
+// ExpressionValue is a placeholder of a value. e.g. :status
+// Value is converted like an attribute of a loaded item, and Type forces the DynamoDB type of it.
+type ExpressionValue struct {
+	Name  string      `mapstructure:"name"`
+	Value interface{} `mapstructure:"value"`
+	Type  string      `mapstructure:"type"`
+}
+
+// RetryConfig represents the backoff of batch writes and gets which are throttled or left unprocessed.
+// A retry waits a random delay up to BaseDelay * 2^(retries-1), capped by MaxDelay.
+type RetryConfig struct {
+	// MaxAttempts is the number of BatchWriteItem or BatchGetItem calls for a chunk before failing. Default is 10
+	MaxAttempts int `mapstructure:"maxAttempts"`
+	// BaseDelay is a duration such as 100ms. Default is 50ms
+	BaseDelay time.Duration `mapstructure:"baseDelay"`
+	// MaxDelay is a duration such as 30s. Default is 20s
+	MaxDelay time.Duration `mapstructure:"maxDelay"`
+}
+
+// VerifyConfig verifies a copy by reading copied items from the target.
+type VerifyConfig struct {
+	// Enabled verifies after the copy. It is set by --verify flag as well
+	Enabled bool `mapstructure:"enabled"`
+	// SampleSize is the number of randomly sampled items to verify. Default 0 verifies every item
+	SampleSize int `mapstructure:"sampleSize"`
+	// MaxMismatches is the number of mismatched items in the result. Default is 100
+	MaxMismatches int `mapstructure:"maxMismatches"`
+}
+
 // DynamoDBRenameConfig defines the configuration for renaming attributes.
 type DynamoDBRenameConfig struct {
-	Service string            `mapstructure:"service"`
-	Target  *DynamoDBConfig   `mapstructure:"target"`
-	Rename  []RenameAttribute `mapstructure:"rename"`
+	Service    string            `mapstructure:"service"`
+	Target     *DynamoDBConfig   `mapstructure:"target"`
+	Rename     []RenameAttribute `mapstructure:"rename"`
+	Scan       ScanConfig        `mapstructure:",squash"`
+	Throughput ThroughputConfig  `mapstructure:",squash"`
+	Retry      RetryConfig       `mapstructure:"retry"`
+	// Journal is the file of keys and original attributes of renamed items,
+	// which can be rolled back. Default is <service>-rename.journal.jsonl, and the journal of a completed run
+	// is renamed with the time, e.g. <service>-rename.journal.20201017T120000.000Z.jsonl
+	Journal string `mapstructure:"journal"`
+}
+
+// TransformOperation is an operation of transform. Op is one of
+// remove, set, setIfMissing, convert, copyAttr and rename. Paths are document paths like RenameAttribute.
+type TransformOperation struct {
+	Op string `mapstructure:"op"`
+	// Path is the attribute which the operation changes
+	Path string `mapstructure:"path"`
+	// From is the source of copyAttr and rename. copyAttr joins string values of many paths with Separator
+	From []string `mapstructure:"from"`
+	// Value is set by set and setIfMissing. It is converted like an attribute of a loaded item, and Type forces the DynamoDB type of it
+	Value interface{} `mapstructure:"value"`
+	Type  string      `mapstructure:"type"`
+	// To is the type which convert changes values to. (S, N, SS or L)
+	To string `mapstructure:"to"`
+	// Format of convert is lower or upper for strings, or iso8601 for epoch seconds and ISO-8601 strings
+	Format string `mapstructure:"format"`
+	// Separator splits a string to SS or L with convert, and joins strings with copyAttr
+	Separator string `mapstructure:"separator"`
+}
+
+// DynamoDBTransformConfig defines the configuration for transforming attributes.
+// Operations are applied to every item in order.
+type DynamoDBTransformConfig struct {
+	Service    string               `mapstructure:"service"`
+	Target     *DynamoDBConfig      `mapstructure:"target"`
+	Operations []TransformOperation `mapstructure:"operations"`
+	Scan       ScanConfig           `mapstructure:",squash"`
+	Throughput ThroughputConfig     `mapstructure:",squash"`
+	Retry      RetryConfig          `mapstructure:"retry"`
+	// Journal is the file of keys and original attributes of transformed items,
+	// which can be rolled back. Default is <service>-transform.journal.jsonl, and the journal of a completed run
+	// is renamed with the time, e.g. <service>-transform.journal.20201017T120000.000Z.jsonl
+	Journal string `mapstructure:"journal"`
 }
 
 // DynamoDBCopyConfig maps origin and target configs for DynamoDB
 type DynamoDBCopyConfig struct {
+	Service    string           `mapstructure:"service"`
+	Origin     *DynamoDBConfig  `mapstructure:"origin"`
+	Target     *DynamoDBConfig  `mapstructure:"target"`
+	Scan       ScanConfig       `mapstructure:",squash"`
+	Select     SelectConfig     `mapstructure:",squash"`
+	Throughput ThroughputConfig `mapstructure:",squash"`
+	Retry      RetryConfig      `mapstructure:"retry"`
+	Verify     VerifyConfig     `mapstructure:"verify"`
+	// CreateTarget creates the target table without asking if it doesn't exist.
+	// It is set by --create-target flag
+	CreateTarget bool `mapstructure:"-"`
+	// Sync makes the target a mirror of the origin. Unchanged items are skipped,
+	// and target-only items are deleted with Delete
+	Sync bool `mapstructure:"sync"`
+	// Delete deletes target-only items with Sync. It is set by --delete flag or a confirmation
+	Delete bool `mapstructure:"-"`
+	// DryRun counts items to put and delete with Sync without writing. It is set by --dry-run flag
+	DryRun bool `mapstructure:"-"`
+}
+
+// DynamoDBDiffConfig maps origin and target of diff.
+// The origin is compared with the target table, or with a dump file.
+type DynamoDBDiffConfig struct {
 	Service string          `mapstructure:"service"`
 	Origin  *DynamoDBConfig `mapstructure:"origin"`
 	Target  *DynamoDBConfig `mapstructure:"target"`
+	// File is a dump file to compare with instead of the target table
+	File string `mapstructure:"file"`
+	// Input is the output format of the dump file
+	Input Output `mapstructure:"input"`
+	// Types restore types of the dump file like load
+	Types      TypeConfig       `mapstructure:"types"`
+	Scan       ScanConfig       `mapstructure:",squash"`
+	Throughput ThroughputConfig `mapstructure:",squash"`
+	// MaxDiffs is the number of different items to report. Default is 100
+	// Every different item is counted regardless of it.
+	MaxDiffs int `mapstructure:"maxDiffs"`
 }
 
 // DynamoDBDumpConfig maps dump configs for DynamoDB
@@ -84,6 +333,112 @@ type DynamoDBDumpConfig struct {
 	Service  string         `mapstructure:"service"`
 	FileName string         `mapstructure:"filename"`
 	Output   Output         `mapstructure:"output"`
+	Scan     ScanConfig     `mapstructure:",squash"`
+	Select   SelectConfig   `mapstructure:",squash"`
+	// MaxReadCapacity of Throughput limits the scan. MaxWriteCapacity is not used
+	Throughput ThroughputConfig `mapstructure:",squash"`
+	// Binary is the encoding of binary attributes. (base64, hex or wrapped)
+	Binary util.BinaryEncoding `mapstructure:"binary"`
+	// KeepNull writes NULL as JSON null instead of true, so that load restores it to NULL.
+	// It is used for json and jsonRaw outputs, and JSON of nested values.
+	KeepNull bool `mapstructure:"keepNull"`
+	// CSV is used for csv and tsv outputs
+	CSV CSVConfig `mapstructure:"csv"`
+	// Parquet is used for parquet output
+	Parquet ParquetConfig `mapstructure:"parquet"`
+	// Compression compresses files. (gzip or zstd)
+	// Parquet files are compressed with the codec instead. Default codec is snappy.
+	Compression Compression `mapstructure:"compression"`
+	// MaxFileSize and MaxItemsPerFile split the output into part files,
+	// and a manifest lists every part. MaxFileSize is bytes after compression.
+	MaxFileSize     int64 `mapstructure:"maxFileSize"`
+	MaxItemsPerFile int   `mapstructure:"maxItemsPerFile"`
+}
+
+// MarshalOptions returns options for util.MarshalDynamo
+func (c *DynamoDBDumpConfig) MarshalOptions() *util.MarshalOptions {
+	return &util.MarshalOptions{Binary: c.Binary, KeepNull: c.KeepNull}
+}
+
+// CSVConfig represents options for csv and tsv outputs
+type CSVConfig struct {
+	// Columns is an explicit list of columns. If empty, columns are inferred from items
+	Columns []string `mapstructure:"columns"`
+	// Inference is the way to infer columns. (sample or twoPass)
+	// sample infers from the first SampleSize items, and twoPass scans the table twice.
+	Inference string `mapstructure:"inference"`
+	// SampleSize is the number of items to infer columns with. Default is 1000
+	SampleSize int `mapstructure:"sampleSize"`
+	// ColumnOrder is the order of inferred columns. (sorted or firstSeen)
+	// Key attributes of the table always come first.
+	ColumnOrder string `mapstructure:"columnOrder"`
+	// Null is written for NULL and missing attributes. Default is an empty string
+	Null string `mapstructure:"null"`
+}
+
+// DynamoDBLoadConfig maps load configs for DynamoDB
+type DynamoDBLoadConfig struct {
+	DynamoDB DynamoDBConfig `mapstructure:"db"`
+	Service  string         `mapstructure:"service"`
+	FileName string         `mapstructure:"filename"`
+	// Input is the output format of the dump to load
+	Input Output      `mapstructure:"input"`
+	Types TypeConfig  `mapstructure:"types"`
+	Retry RetryConfig `mapstructure:"retry"`
+}
+
+// TypeConfig represents rules to restore DynamoDB types from flattened items
+type TypeConfig struct {
+	// NumericStrings loads strings which look like numbers as N
+	NumericStrings bool `mapstructure:"numericStrings"`
+	// NumbersAsStrings loads JSON numbers as S
+	NumbersAsStrings bool `mapstructure:"numbersAsStrings"`
+	// Sets loads arrays of unique strings or numbers as SS or NS
+	Sets bool `mapstructure:"sets"`
+	// NOTE: a list is used instead of a map since viper lowercases map keys
+	Attributes []AttributeType `mapstructure:"attributes"`
+	// Binary must match the binary encoding of the dump
+	Binary util.BinaryEncoding `mapstructure:"binary"`
+}
+
+// AttributeType forces the DynamoDB type of an attribute path
+type AttributeType struct {
+	Path string `mapstructure:"path"`
+	Type string `mapstructure:"type"`
+}
+
+// UnmarshalOptions returns options for util.UnmarshalDynamo
+func (c TypeConfig) UnmarshalOptions() *util.UnmarshalOptions {
+	opts := &util.UnmarshalOptions{
+		NumericStrings:   c.NumericStrings,
+		NumbersAsStrings: c.NumbersAsStrings,
+		Sets:             c.Sets,
+		Binary:           c.Binary,
+		Types:            make(map[string]string, len(c.Attributes)),
+	}
+	for _, a := range c.Attributes {
+		opts.Types[a.Path] = a.Type
+	}
+	return opts
+}
+
+// ParquetConfig represents options for parquet output
+type ParquetConfig struct {
+	// Inference is the way to infer a schema. (sample or twoPass)
+	// sample infers from the first SampleSize items, and twoPass scans the table twice.
+	Inference string `mapstructure:"inference"`
+	// SampleSize is the number of items to infer a schema with. Default is 1000
+	SampleSize int `mapstructure:"sampleSize"`
+	// Number is the type of N attributes. (double, int64 or decimal) Default is double
+	Number string `mapstructure:"number"`
+	// DecimalScale and DecimalPrecision are used with decimal number. Default precision is 18
+	DecimalScale     int `mapstructure:"decimalScale"`
+	DecimalPrecision int `mapstructure:"decimalPrecision"`
+	// Map is the way to write M attributes. (json or struct) Default is json
+	Map string `mapstructure:"map"`
+	// RowGroupSize is the number of items in a row group. Default is 100000
+	// Unless the output is split by maxFileSize or maxItemsPerFile, each file has a row group.
+	RowGroupSize int `mapstructure:"rowGroupSize"`
 }
 
 // DynamoDBConfig represents connection info for a specific table
@@ -128,9 +483,15 @@ func BindDumpConfigByKey(key string) (*Config, error) {
 
 // MustReadCfgFile reads the config file stated with or without given config file location
 func MustReadCfgFile() {
+	MustReadCfgFileTo(os.Stdout)
+}
+
+// MustReadCfgFileTo reads the config file like MustReadCfgFile, and prints its location to w.
+// Commands which write results to stdout print it to stderr.
+func MustReadCfgFileTo(w io.Writer) {
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err != nil {
 		log.Fatal().Err(err).Msgf("couldn't read the config file: %s", viper.ConfigFileUsed())
 	}
-	fmt.Println(Blue("Config file:" + viper.ConfigFileUsed() + "\n"))
+	fmt.Fprintln(w, Blue("Config file:"+viper.ConfigFileUsed()+"\n"))
 }
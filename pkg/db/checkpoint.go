@@ -0,0 +1,139 @@
+package db
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/util"
+	"github.com/pkg/errors"
+)
+
+// checkpoint persists the progress of a scan after every page,
+// so that an interrupted command can continue from it with --resume.
+type checkpoint struct {
+	path  string
+	mu    sync.Mutex
+	state checkpointState
+}
+
+// checkpointState is the content of a checkpoint file.
+type checkpointState struct {
+	Table         string              `json:"table"`
+	TotalSegments int                 `json:"totalSegments"`
+	Segments      []segmentCheckpoint `json:"segments"`
+	Read          int64               `json:"read"`
+	Written       int64               `json:"written"`
+	// Output is the position of the dump output
+	Output *outputState `json:"output,omitempty"`
+}
+
+// segmentCheckpoint is the progress of a segment.
+type segmentCheckpoint struct {
+	LastEvaluatedKey checkpointKey `json:"lastEvaluatedKey,omitempty"`
+	Done             bool          `json:"done"`
+	Items            int64         `json:"items"`
+}
+
+// checkpointKey is written as DynamoDB JSON.
+type checkpointKey map[string]*dynamodb.AttributeValue
+
+func (k checkpointKey) MarshalJSON() ([]byte, error) {
+	return json.Marshal(util.TypedDynamo(k))
+}
+
+// openCheckpoint reads the checkpoint file with resume, or starts a new one.
+func openCheckpoint(path, table string, totalSegments int, resume bool) (*checkpoint, error) {
+	if totalSegments < 1 {
+		totalSegments = 1
+	}
+
+	cp := &checkpoint{path: path}
+	if !resume {
+		cp.state = checkpointState{
+			Table:         table,
+			TotalSegments: totalSegments,
+			Segments:      make([]segmentCheckpoint, totalSegments),
+		}
+		return cp, cp.save(nil)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read checkpoint")
+	}
+	if err := json.Unmarshal(b, &cp.state); err != nil {
+		return nil, errors.Wrapf(err, "invalid checkpoint %s", path)
+	}
+	if cp.state.Table != table {
+		return nil, errors.Wrapf(ErrCheckpointMismatch, "%s is for %s table, not %s", path, cp.state.Table, table)
+	}
+	if cp.state.TotalSegments != totalSegments || len(cp.state.Segments) != totalSegments {
+		return nil, errors.Wrapf(ErrCheckpointMismatch, "%s has %d segments, but totalSegments is %d", path, cp.state.TotalSegments, totalSegments)
+	}
+	return cp, nil
+}
+
+// start returns the key to continue the segment from, and whether the segment is already done.
+func (cp *checkpoint) start(segment int) (map[string]*dynamodb.AttributeValue, bool) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	s := cp.state.Segments[segment]
+	return s.LastEvaluatedKey, s.Done
+}
+
+// advance records that a page of the segment is finished.
+// It is not saved until save is called.
+func (cp *checkpoint) advance(segment int, lastKey map[string]*dynamodb.AttributeValue, read, written int) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	s := &cp.state.Segments[segment]
+	s.LastEvaluatedKey = lastKey
+	s.Done = lastKey == nil
+	s.Items += int64(read)
+	cp.state.Read += int64(read)
+	cp.state.Written += int64(written)
+}
+
+// commit advances the segment and saves the checkpoint.
+func (cp *checkpoint) commit(segment int, lastKey map[string]*dynamodb.AttributeValue, read, written int) error {
+	cp.advance(segment, lastKey, read, written)
+	return cp.save(nil)
+}
+
+// save writes the checkpoint file with the position of the output.
+// The file is replaced at once, so a crash while saving keeps the previous checkpoint.
+func (cp *checkpoint) save(output *outputState) error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	if output != nil {
+		cp.state.Output = output
+	}
+	b, err := json.MarshalIndent(cp.state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := cp.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return errors.Wrap(err, "failed to write checkpoint")
+	}
+	return errors.Wrap(os.Rename(tmp, cp.path), "failed to write checkpoint")
+}
+
+// counts returns the number of read and written items so far.
+func (cp *checkpoint) counts() (int64, int64) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.state.Read, cp.state.Written
+}
+
+// remove deletes the checkpoint file after the command is finished.
+func (cp *checkpoint) remove() error {
+	return os.Remove(cp.path)
+}
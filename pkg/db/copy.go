@@ -1,205 +1,186 @@
 package db
 
 import (
-	"bufio"
-	"fmt"
-	"os"
-	"strings"
-	"sync"
-	"sync/atomic"
-	"time"
+	"context"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/daangn/dynamoutil/pkg/config"
-	"github.com/rs/zerolog/log"
-
-	. "github.com/logrusorgru/aurora"
+	"github.com/pkg/errors"
 )
 
 // Copy copy dynamodb items from origin to target table.
-// This performs BatchGetItems from origin dynamodb table, and
-// BatchPutItems to target dynamodb table.
-func Copy(cfg *config.DynamoDBCopyConfig) error {
-	fmt.Println(
-		Bold(Green("Origin")),
-		BrightBlue("region: ").String()+cfg.Origin.Region+" ",
-		BrightBlue("table: ").String()+cfg.Origin.TableName+" ",
-		BrightBlue("endpoint: ").String()+cfg.Origin.Endpoint,
-	)
-	fmt.Println(
-		Bold(Green("Target")),
-		BrightBlue("region: ").String()+cfg.Target.Region+" ",
-		BrightBlue("table: ").String()+cfg.Target.TableName+" ",
-		BrightBlue("endpoint: ").String()+cfg.Target.Endpoint,
-	)
-
-	fmt.Printf("\nAre you sure about copying all items from %s? [Y/n] ", BrightBlue(cfg.Origin.TableName))
-	yn, _ := bufio.NewReader(os.Stdin).ReadString('\n')
-	if strings.Trim(yn, "\n") != "Y" {
-		fmt.Println(Green("Goodbye👋"))
-		return nil
-	}
-	fmt.Print("\n")
-
-	originDB, err := new(cfg.Origin)
+// This scans origin dynamodb table, and performs BatchWriteItems to target dynamodb table.
+// If the target table doesn't exist, it is created like the origin with CreateTarget,
+// or ErrTargetNotFound is returned.
+// With Sync, unchanged items are skipped and target-only items are deleted. See CopyResult.
+// With Verify, copied items are read from the target after the copy, and compared by their hashes.
+// Items copied before a resume are not verified, but they are counted.
+func Copy(ctx context.Context, cfg *config.DynamoDBCopyConfig, opts *Options) (*CopyResult, error) {
+	cfg = copyDefaults(cfg)
+	if cfg.Sync {
+		if err := validateSync(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	originDB, err := opts.connect(cfg.Origin)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to connect to origin database. Check .dynamoutil.yaml or origin database status")
+		return nil, errors.Wrap(err, "failed to connect to origin database")
 	}
-	targetDB, err := new(cfg.Target)
+	targetDB, err := opts.connect(cfg.Target)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to connect to target database. Check .dynamoutil.yaml or target database status")
+		return nil, errors.Wrap(err, "failed to connect to target database")
 	}
 
-	oo, err := originDB.DescribeTable(&dynamodb.DescribeTableInput{
-		TableName: &cfg.Origin.TableName,
-	})
+	origin, err := describeTable(ctx, originDB, cfg.Origin.TableName)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Origin table does not exist")
+		return nil, errors.Wrap(err, "origin")
 	}
 
-	_, err = targetDB.DescribeTable(&dynamodb.DescribeTableInput{
-		TableName: &cfg.Target.TableName,
-	})
-	if err != nil {
-		if strings.Contains(err.Error(), "ResourceNotFoundException") {
-			fmt.Printf("\nTable does not exist on <%s>.\nDo you want to create %s table at target endpoint?[Y/n] ",
-				BrightBlue(fmt.Sprintf("%s %s %s", cfg.Target.Region, cfg.Target.TableName, cfg.Target.Endpoint)),
-				BrightBlue(cfg.Target.TableName),
-			)
-			yn, _ := bufio.NewReader(os.Stdin).ReadString('\n')
-			if strings.Trim(yn, "\n") != "Y" {
-				fmt.Println("Goodbye~ 👋")
-				return nil
-			}
-
-			cti := &dynamodb.CreateTableInput{
-				KeySchema:            oo.Table.KeySchema,
-				AttributeDefinitions: oo.Table.AttributeDefinitions,
-				BillingMode:          oo.Table.BillingModeSummary.BillingMode,
-				TableName:            &cfg.Target.TableName,
-			}
-
-			wcu := oo.Table.ProvisionedThroughput.WriteCapacityUnits
-			if *wcu < 1 {
-				wcu = aws.Int64(1)
-			}
-
-			rcu := oo.Table.ProvisionedThroughput.ReadCapacityUnits
-			if *rcu < 1 {
-				rcu = aws.Int64(1)
-			}
-			cti.ProvisionedThroughput = &dynamodb.ProvisionedThroughput{
-				ReadCapacityUnits:  rcu,
-				WriteCapacityUnits: wcu,
-			}
-
-			if len(oo.Table.GlobalSecondaryIndexes) > 0 {
-				var gsi []*dynamodb.GlobalSecondaryIndex
-				for _, idx := range oo.Table.GlobalSecondaryIndexes {
-					gsi = append(gsi, &dynamodb.GlobalSecondaryIndex{
-						IndexName:             idx.IndexName,
-						KeySchema:             idx.KeySchema,
-						Projection:            idx.Projection,
-						ProvisionedThroughput: cti.ProvisionedThroughput,
-					})
-				}
-				cti.GlobalSecondaryIndexes = gsi
-			}
-
-			if len(oo.Table.LocalSecondaryIndexes) > 0 {
-				var lsi []*dynamodb.LocalSecondaryIndex
-				for _, idx := range oo.Table.LocalSecondaryIndexes {
-					lsi = append(lsi, &dynamodb.LocalSecondaryIndex{
-						IndexName:  idx.IndexName,
-						KeySchema:  idx.KeySchema,
-						Projection: idx.Projection,
-					})
-				}
-				cti.LocalSecondaryIndexes = lsi
-			}
-
-			_, err := targetDB.CreateTable(cti)
-			if err != nil {
-				log.Fatal().Err(err).Msg("Failed to create target dynamodb")
-			}
-		} else {
-			log.Fatal().Err(err).Msg("Failed to describe target dynamodb table")
+	target, err := describeTable(ctx, targetDB, cfg.Target.TableName)
+	if errors.Is(err, ErrTableNotFound) {
+		if !cfg.CreateTarget {
+			return nil, errors.Wrap(ErrTargetNotFound, cfg.Target.TableName)
+		}
+		if cfg.Sync && cfg.DryRun {
+			// A dry run doesn't create the table, and every item is put to the empty table
+			return syncCopy(ctx, cfg, originDB, targetDB, origin, nil, newTracker(opts))
+		}
+		if err := createTable(ctx, targetDB, origin, cfg.Target.TableName); err != nil {
+			return nil, err
 		}
+		target, err = describeTable(ctx, targetDB, cfg.Target.TableName)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "target")
+	}
+	if cfg.Sync {
+		return syncCopy(ctx, cfg, originDB, targetDB, origin, target, newTracker(opts))
 	}
 
-	fmt.Println()
-	var lastKey map[string]*dynamodb.AttributeValue
+	// Keys of the origin are always projected, since they are needed to put items
+	r, err := newReader(originDB, origin, cfg.Select, cfg.Scan, 2500, keyAttributes(origin)...)
+	if err != nil {
+		return nil, err
+	}
 
-	wg := sync.WaitGroup{}
-	now := time.Now()
-	var ops int32
-	var readOps int32
-	go func() {
-		for {
-			time.Sleep(time.Millisecond * 100)
-			fmt.Printf("\r\tTime spent: %.1f. Read %d items, Writes %d items. %.2f items/s", time.Since(now).Seconds(), Blue(readOps), Blue(ops), Blue(float64(ops)/(time.Since(now).Seconds())))
-		}
-	}()
-
-	for {
-		o, err := originDB.Scan(&dynamodb.ScanInput{
-			TableName:         &cfg.Origin.TableName,
-			Limit:             aws.Int64(2500),
-			ExclusiveStartKey: lastKey,
-		})
-		if err != nil {
-			log.Fatal().Err(err).Msg("Failed to scan origin dynamodb")
-		}
-		atomic.AddInt32(&readOps, int32(len(o.Items)))
-
-		var (
-			chunks [][]*dynamodb.WriteRequest
-			wrs    []*dynamodb.WriteRequest
-		)
-		cnt := len(o.Items)
-		for i, item := range o.Items {
+	cp, err := openCheckpoint(cfg.Scan.Checkpoint, cfg.Origin.TableName, r.segments(), cfg.Scan.Resume)
+	if err != nil {
+		return nil, err
+	}
+
+	t := newTracker(opts)
+	bw := newBatchWriter(targetDB, cfg.Target.TableName, cfg.Retry, writeLimiter(cfg.Throughput, target), t)
+	read, written := cp.counts()
+	t.addRead(int(read))
+	t.addWritten(int(written))
+	sample := newVerifySample(cfg.Verify, keyAttributes(target))
+
+	err = r.read(ctx, cp, readLimiter(cfg.Throughput, origin), func(segment int, items []map[string]*dynamodb.AttributeValue, lastKey map[string]*dynamodb.AttributeValue) error {
+		t.addRead(len(items))
+		sample.add(items)
+
+		var wrs []*dynamodb.WriteRequest
+		for _, item := range items {
 			wrs = append(wrs, &dynamodb.WriteRequest{
 				PutRequest: &dynamodb.PutRequest{
 					Item: item,
 				},
 			})
-			if (i+1)%25 == 0 || i == cnt-1 {
-				chunks = append(chunks, wrs)
-				wrs = []*dynamodb.WriteRequest{}
-			}
 		}
 
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
+		// The page is written before the checkpoint moves past it
+		if err := bw.writeChunks(ctx, wrs, t.addWritten); err != nil {
+			return err
+		}
+		return cp.commit(segment, lastKey, len(items), len(items))
+	})
+	result := &CopyResult{Result: *t.result()}
+	result.Puts = result.Read
+	if err != nil {
+		return result, errors.Wrap(err, "failed to copy items")
+	}
+
+	if err := cp.remove(); err != nil {
+		return result, errors.Wrap(err, "failed to remove checkpoint")
+	}
+
+	if sample != nil {
+		result.Verify, err = verifyCopy(ctx, cfg, targetDB, target, sample, result.Read, t)
+		r := t.result()
+		result.Retries, result.Throttles = r.Retries, r.Throttles
+		if err != nil {
+			return result, errors.Wrap(err, "failed to verify")
+		}
+	}
+	return result, nil
+}
+
+// copyDefaults returns a copy of the config with defaults, so that the config of the caller isn't changed.
+func copyDefaults(cfg *config.DynamoDBCopyConfig) *config.DynamoDBCopyConfig {
+	c := *cfg
+	if c.Scan.Checkpoint == "" {
+		c.Scan.Checkpoint = c.Service + "-copy.checkpoint.json"
+	}
+	return &c
+}
+
+// createTable creates a table with the keys and indexes of origin, and waits until it is active.
+func createTable(ctx context.Context, db Client, origin *dynamodb.TableDescription, table string) error {
+	cti := &dynamodb.CreateTableInput{
+		KeySchema:            origin.KeySchema,
+		AttributeDefinitions: origin.AttributeDefinitions,
+		TableName:            &table,
+	}
+	if origin.BillingModeSummary != nil {
+		cti.BillingMode = origin.BillingModeSummary.BillingMode
+	}
 
-			for _, ch := range chunks {
-				batchWrite(targetDB, map[string][]*dynamodb.WriteRequest{
-					cfg.Target.TableName: ch,
-				})
-				atomic.AddInt32(&ops, int32(len(ch)))
-			}
+	wcu := origin.ProvisionedThroughput.WriteCapacityUnits
+	if *wcu < 1 {
+		wcu = aws.Int64(1)
+	}
 
-		}()
+	rcu := origin.ProvisionedThroughput.ReadCapacityUnits
+	if *rcu < 1 {
+		rcu = aws.Int64(1)
+	}
+	cti.ProvisionedThroughput = &dynamodb.ProvisionedThroughput{
+		ReadCapacityUnits:  rcu,
+		WriteCapacityUnits: wcu,
+	}
 
-		if o.LastEvaluatedKey != nil {
-			lastKey = o.LastEvaluatedKey
-			continue
+	if len(origin.GlobalSecondaryIndexes) > 0 {
+		var gsi []*dynamodb.GlobalSecondaryIndex
+		for _, idx := range origin.GlobalSecondaryIndexes {
+			gsi = append(gsi, &dynamodb.GlobalSecondaryIndex{
+				IndexName:             idx.IndexName,
+				KeySchema:             idx.KeySchema,
+				Projection:            idx.Projection,
+				ProvisionedThroughput: cti.ProvisionedThroughput,
+			})
 		}
+		cti.GlobalSecondaryIndexes = gsi
+	}
 
-		break
+	if len(origin.LocalSecondaryIndexes) > 0 {
+		var lsi []*dynamodb.LocalSecondaryIndex
+		for _, idx := range origin.LocalSecondaryIndexes {
+			lsi = append(lsi, &dynamodb.LocalSecondaryIndex{
+				IndexName:  idx.IndexName,
+				KeySchema:  idx.KeySchema,
+				Projection: idx.Projection,
+			})
+		}
+		cti.LocalSecondaryIndexes = lsi
 	}
-	wg.Wait()
-	since := time.Since(now)
-	time.Sleep(time.Millisecond * 110)
 
-	fmt.Print("\n\n")
-	fmt.Printf("Copied %d items of %s table.\nExecution Time: %.2f seconds\nAvg: %.2f ops/s\n",
-		Green(ops),
-		BrightBlue(cfg.Origin.TableName),
-		Green(since.Seconds()),
-		Green(float64(ops)/since.Seconds()),
-	)
-	return nil
+	if _, err := db.CreateTableWithContext(ctx, cti); err != nil {
+		return errors.Wrap(err, "failed to create target table")
+	}
+	err := db.WaitUntilTableExistsWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: &table,
+	})
+	return errors.Wrap(err, "failed to wait for target table")
 }
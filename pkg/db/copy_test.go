@@ -0,0 +1,138 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/daangn/dynamoutil/pkg/config"
+	"github.com/daangn/dynamoutil/pkg/db"
+)
+
+func copyConfig() *config.DynamoDBCopyConfig {
+	return &config.DynamoDBCopyConfig{
+		Service: "test",
+		Origin:  &config.DynamoDBConfig{TableName: "origin"},
+		Target:  &config.DynamoDBConfig{TableName: "target"},
+		Retry:   fastRetry,
+	}
+}
+
+func TestCopyPages(t *testing.T) {
+	for _, segments := range []int{1, 3} {
+		chdirTemp(t)
+		items := newItems(50)
+		d := newTable("origin", items)
+		d.AddTable("target", "pk", "")
+		d.MaxPageSize = 7
+
+		cfg := copyConfig()
+		cfg.Scan.TotalSegments = segments
+		result, err := db.Copy(context.Background(), cfg, &db.Options{Connect: d.Connect})
+		if err != nil {
+			t.Fatalf("Copy() error = %v", err)
+		}
+		if result.Read != 50 || result.Written != 50 {
+			t.Errorf("Copy() with %d segments read %d and wrote %d items, want 50", segments, result.Read, result.Written)
+		}
+		assertItems(t, d, "target", items)
+	}
+}
+
+func TestCopyUnprocessedItems(t *testing.T) {
+	chdirTemp(t)
+	items := newItems(30)
+	d := newTable("origin", items)
+	d.AddTable("target", "pk", "")
+	d.MaxBatchWrite = 4
+	d.Throttle = 2
+
+	result, err := db.Copy(context.Background(), copyConfig(), &db.Options{Connect: d.Connect})
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if result.Written != 30 || result.Failed != 0 {
+		t.Errorf("Copy() wrote %d items and failed %d, want 30 and 0", result.Written, result.Failed)
+	}
+	if result.Throttles != 2 || result.Retries <= result.Throttles {
+		t.Errorf("Copy() retried %d times with %d throttles, want unprocessed retries and 2 throttles", result.Retries, result.Throttles)
+	}
+	assertItems(t, d, "target", items)
+}
+
+func TestCopyMaxAttempts(t *testing.T) {
+	chdirTemp(t)
+	d := newTable("origin", newItems(3))
+	d.AddTable("target", "pk", "")
+	d.Throttle = 100
+
+	cfg := copyConfig()
+	cfg.Retry.MaxAttempts = 3
+	_, err := db.Copy(context.Background(), cfg, &db.Options{Connect: d.Connect})
+	if !errors.Is(err, db.ErrMaxAttempts) {
+		t.Errorf("Copy() error = %v, want ErrMaxAttempts", err)
+	}
+}
+
+func TestCopyResume(t *testing.T) {
+	chdirTemp(t)
+	items := newItems(20)
+	d := newTable("origin", items)
+	d.AddTable("target", "pk", "")
+	d.MaxPageSize = 5
+
+	cfg := copyConfig()
+	if _, err := db.Copy(context.Background(), cfg, &db.Options{Connect: failAfterScans(d, 2).Connect}); err == nil {
+		t.Fatal("Copy() succeeded with a lost connection")
+	}
+	if n := len(d.Items("target")); n != 10 {
+		t.Fatalf("target has %d items after 2 pages, want 10", n)
+	}
+
+	cfg.Scan.Resume = true
+	result, err := db.Copy(context.Background(), cfg, &db.Options{Connect: d.Connect})
+	if err != nil {
+		t.Fatalf("resumed Copy() error = %v", err)
+	}
+	if result.Read != 20 || result.Written != 20 {
+		t.Errorf("resumed Copy() read %d and wrote %d items, want 20", result.Read, result.Written)
+	}
+	assertItems(t, d, "target", items)
+	if _, err := os.Stat("test-copy.checkpoint.json"); !os.IsNotExist(err) {
+		t.Errorf("checkpoint is not removed: %v", err)
+	}
+}
+
+func TestCopyTargetNotFound(t *testing.T) {
+	chdirTemp(t)
+	d := newTable("origin", newItems(3))
+
+	_, err := db.Copy(context.Background(), copyConfig(), &db.Options{Connect: d.Connect})
+	if !errors.Is(err, db.ErrTargetNotFound) {
+		t.Errorf("Copy() error = %v, want ErrTargetNotFound", err)
+	}
+
+	cfg := copyConfig()
+	cfg.CreateTarget = true
+	if _, err := db.Copy(context.Background(), cfg, &db.Options{Connect: d.Connect}); err != nil {
+		t.Fatalf("Copy() with CreateTarget error = %v", err)
+	}
+	assertItems(t, d, "target", d.Items("origin"))
+}
+
+func TestCopyKeepsConfig(t *testing.T) {
+	chdirTemp(t)
+	d := newTable("origin", newItems(3))
+	d.AddTable("target", "pk", "")
+
+	cfg := copyConfig()
+	want := *cfg
+	if _, err := db.Copy(context.Background(), cfg, &db.Options{Connect: d.Connect}); err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if !reflect.DeepEqual(*cfg, want) {
+		t.Errorf("Copy() changed the config to %+v", *cfg)
+	}
+}
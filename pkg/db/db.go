@@ -1,12 +1,21 @@
 package db
 
 import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/daangn/dynamoutil/pkg/config"
-	"github.com/rs/zerolog/log"
+	"github.com/pkg/errors"
 )
 
 // DynamoDBConfig represents required parameters to open
@@ -25,19 +34,155 @@ type SyncDynamoDBConfig struct {
 	Target *DynamoDBConfig
 }
 
-func batchWrite(db *dynamodb.DynamoDB, r map[string][]*dynamodb.WriteRequest) {
-	o, err := db.BatchWriteItem(&dynamodb.BatchWriteItemInput{
-		RequestItems: r,
-	})
-	if err != nil {
-		log.Fatal().Err(err).Interface("items", r).Msg("Failed to batch write items")
+// Client is the part of DynamoDB API used by commands.
+// *dynamodb.DynamoDB implements it, and dbtest.DB is an in-memory fake of it.
+type Client interface {
+	ScanWithContext(ctx aws.Context, input *dynamodb.ScanInput, opts ...request.Option) (*dynamodb.ScanOutput, error)
+	QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error)
+	BatchWriteItemWithContext(ctx aws.Context, input *dynamodb.BatchWriteItemInput, opts ...request.Option) (*dynamodb.BatchWriteItemOutput, error)
+	BatchGetItemWithContext(ctx aws.Context, input *dynamodb.BatchGetItemInput, opts ...request.Option) (*dynamodb.BatchGetItemOutput, error)
+	UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error)
+	TransactWriteItemsWithContext(ctx aws.Context, input *dynamodb.TransactWriteItemsInput, opts ...request.Option) (*dynamodb.TransactWriteItemsOutput, error)
+	DescribeTableWithContext(ctx aws.Context, input *dynamodb.DescribeTableInput, opts ...request.Option) (*dynamodb.DescribeTableOutput, error)
+	CreateTableWithContext(ctx aws.Context, input *dynamodb.CreateTableInput, opts ...request.Option) (*dynamodb.CreateTableOutput, error)
+	WaitUntilTableExistsWithContext(ctx aws.Context, input *dynamodb.DescribeTableInput, opts ...request.WaiterOption) error
+}
+
+// Defaults of config.RetryConfig
+const (
+	defaultMaxAttempts = 10
+	defaultBaseDelay   = 50 * time.Millisecond
+	defaultMaxDelay    = 20 * time.Second
+)
+
+// batchWriter writes requests to a table, and retries throttled and unprocessed requests
+// with jittered exponential backoff. With a limiter, every call waits for write capacity.
+type batchWriter struct {
+	db    Client
+	table string
+	retry config.RetryConfig
+	limit *limiter
+	t     *tracker
+}
+
+func newBatchWriter(db Client, table string, retry config.RetryConfig, limit *limiter, t *tracker) *batchWriter {
+	return &batchWriter{db: db, table: table, retry: retryDefaults(retry), limit: limit, t: t}
+}
+
+// retryDefaults returns the config with defaults of zero values.
+func retryDefaults(retry config.RetryConfig) config.RetryConfig {
+	if retry.MaxAttempts < 1 {
+		retry.MaxAttempts = defaultMaxAttempts
+	}
+	if retry.BaseDelay <= 0 {
+		retry.BaseDelay = defaultBaseDelay
+	}
+	if retry.MaxDelay <= 0 {
+		retry.MaxDelay = defaultMaxDelay
 	}
+	return retry
+}
+
+// batchWrite writes requests until there are no unprocessed items, or MaxAttempts calls are made.
+// Throttling and other retryable errors are retried like unprocessed items.
+func (w *batchWriter) batchWrite(ctx context.Context, wrs []*dynamodb.WriteRequest) error {
+	r := map[string][]*dynamodb.WriteRequest{w.table: wrs}
+	for attempt := 1; ; attempt++ {
+		input := &dynamodb.BatchWriteItemInput{RequestItems: r}
+		if w.limit != nil {
+			input.ReturnConsumedCapacity = aws.String(dynamodb.ReturnConsumedCapacityTotal)
+		}
+		if err := w.limit.wait(ctx); err != nil {
+			return err
+		}
+
+		// The SDK doesn't retry by itself, so that every retry is backed off and counted here
+		o, err := w.db.BatchWriteItemWithContext(ctx, input, func(req *request.Request) {
+			req.Retryer = client.NoOpRetryer{}
+		})
+		if err != nil {
+			if ctx.Err() != nil || !request.IsErrorRetryable(err) && !request.IsErrorThrottle(err) {
+				return errors.Wrap(err, "failed to batch write items")
+			}
+		} else {
+			w.limit.take(consumedUnits(o.ConsumedCapacity...))
+			r = o.UnprocessedItems
+			if len(r[w.table]) == 0 {
+				return nil
+			}
+		}
 
-	for _, v := range o.UnprocessedItems {
-		if len(v) > 0 {
-			batchWrite(db, o.UnprocessedItems)
+		if attempt == w.retry.MaxAttempts {
+			if err == nil {
+				err = errors.Errorf("%d items are unprocessed", len(r[w.table]))
+			}
+			return errors.Wrap(fmt.Errorf("%w after %d attempts: %v", ErrMaxAttempts, attempt, err), "failed to batch write items")
 		}
+		// Unprocessed items are usually throttled too
+		w.limit.throttled()
+		w.t.addRetry(request.IsErrorThrottle(err))
+
+		select {
+		case <-time.After(backoff(w.retry, attempt)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// backoff returns a random delay up to BaseDelay * 2^(attempt-1), capped by MaxDelay.
+func backoff(retry config.RetryConfig, attempt int) time.Duration {
+	d := retry.MaxDelay
+	if attempt < 32 {
+		if exp := retry.BaseDelay << uint(attempt-1); exp > 0 && exp < d {
+			d = exp
+		}
+	}
+	return time.Duration(rand.Int63n(int64(d))) + 1
+}
+
+// writeChunks writes requests to the table in chunks of 25 at the same time,
+// and calls written with the size of every written chunk.
+func (w *batchWriter) writeChunks(ctx context.Context, wrs []*dynamodb.WriteRequest, written func(n int)) error {
+	var (
+		wg   sync.WaitGroup
+		errs = make(chan error, len(wrs)/25+1)
+	)
+	for i := 0; i < len(wrs); i += 25 {
+		end := i + 25
+		if end > len(wrs) {
+			end = len(wrs)
+		}
+
+		wg.Add(1)
+		go func(chunk []*dynamodb.WriteRequest) {
+			defer wg.Done()
+
+			if err := w.batchWrite(ctx, chunk); err != nil {
+				errs <- err
+				return
+			}
+			written(len(chunk))
+		}(wrs[i:end])
+	}
+	wg.Wait()
+	close(errs)
+
+	return <-errs
+}
+
+// describeTable returns the table, or ErrTableNotFound if it doesn't exist.
+func describeTable(ctx context.Context, db Client, table string) (*dynamodb.TableDescription, error) {
+	o, err := db.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: &table,
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+		return nil, errors.Wrap(ErrTableNotFound, table)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to describe %s table", table)
 	}
+	return o.Table, nil
 }
 
 func new(cfg *config.DynamoDBConfig) (*dynamodb.DynamoDB, error) {
@@ -0,0 +1,122 @@
+package db_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/config"
+	"github.com/daangn/dynamoutil/pkg/db"
+	"github.com/daangn/dynamoutil/pkg/db/dbtest"
+)
+
+// newItems returns n items with pk from item-000 and a few typed attributes.
+func newItems(n int) []map[string]*dynamodb.AttributeValue {
+	items := make([]map[string]*dynamodb.AttributeValue, n)
+	for i := range items {
+		items[i] = map[string]*dynamodb.AttributeValue{
+			"pk":    {S: aws.String(fmt.Sprintf("item-%03d", i))},
+			"count": {N: aws.String(fmt.Sprint(i))},
+			"name":  {S: aws.String(fmt.Sprintf("name %d", i))},
+		}
+	}
+	return items
+}
+
+// newTable returns a DB with a table of items whose partition key is pk.
+func newTable(name string, items []map[string]*dynamodb.AttributeValue) *dbtest.DB {
+	d := dbtest.New()
+	d.AddTable(name, "pk", "")
+	d.Put(name, items...)
+	return d
+}
+
+// chdirTemp changes the working directory to a new temporary directory for files of commands.
+func chdirTemp(t *testing.T) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "dynamoutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	})
+	return dir
+}
+
+// assertItems fails the test unless the table has the items in the order of their keys.
+func assertItems(t *testing.T, d *dbtest.DB, table string, want []map[string]*dynamodb.AttributeValue) {
+	t.Helper()
+
+	got := d.Items(table)
+	if len(got) != len(want) {
+		t.Fatalf("%s has %d items, want %d", table, len(got), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("%s item %d = %v, want %v", table, i, got[i], want[i])
+		}
+	}
+}
+
+// fastRetry backs off for a millisecond at most, so that retries don't slow tests down.
+var fastRetry = config.RetryConfig{BaseDelay: time.Microsecond, MaxDelay: time.Millisecond}
+
+// failingDB fails Scan calls after the first scans, like a connection lost in the middle of a command.
+type failingDB struct {
+	*dbtest.DB
+	scans int32
+}
+
+func failAfterScans(d *dbtest.DB, scans int) *failingDB {
+	return &failingDB{DB: d, scans: int32(scans)}
+}
+
+func (d *failingDB) Connect(cfg *config.DynamoDBConfig) (db.Client, error) {
+	return d, nil
+}
+
+func (d *failingDB) ScanWithContext(ctx aws.Context, input *dynamodb.ScanInput, opts ...request.Option) (*dynamodb.ScanOutput, error) {
+	if atomic.AddInt32(&d.scans, -1) < 0 {
+		return nil, errors.New("connection lost")
+	}
+	return d.DB.ScanWithContext(ctx, input, opts...)
+}
+
+// changingDB puts the item after the first Scan, like another writer changing the item after it is read.
+type changingDB struct {
+	*dbtest.DB
+	table string
+	item  map[string]*dynamodb.AttributeValue
+	once  sync.Once
+}
+
+func (d *changingDB) Connect(cfg *config.DynamoDBConfig) (db.Client, error) {
+	return d, nil
+}
+
+func (d *changingDB) ScanWithContext(ctx aws.Context, input *dynamodb.ScanInput, opts ...request.Option) (*dynamodb.ScanOutput, error) {
+	o, err := d.DB.ScanWithContext(ctx, input, opts...)
+	d.once.Do(func() {
+		d.Put(d.table, d.item)
+	})
+	return o, err
+}
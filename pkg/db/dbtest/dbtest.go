@@ -0,0 +1,420 @@
+// Package dbtest provides an in-memory fake of DynamoDB for tests of pkg/db.
+package dbtest
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/config"
+	"github.com/daangn/dynamoutil/pkg/db"
+	"github.com/daangn/dynamoutil/pkg/util"
+)
+
+// DB is an in-memory fake of DynamoDB which implements db.Client.
+// Tables are shared by every config, so origin and target of Copy can be the same DB.
+// It is safe for concurrent use.
+type DB struct {
+	// MaxBatchWrite limits the number of requests processed by a BatchWriteItem call.
+	// The rest are returned as UnprocessedItems. Zero processes all requests.
+	MaxBatchWrite int
+	// Throttle is the number of next BatchWriteItem, UpdateItem or TransactWriteItems calls which fail with
+	// ProvisionedThroughputExceededException.
+	Throttle int
+	// MaxBatchGet limits the number of keys processed by a BatchGetItem call.
+	// The rest are returned as UnprocessedKeys. Zero processes all keys.
+	MaxBatchGet int
+	// MaxPageSize limits the number of items of a Scan or Query page below Limit,
+	// like the 1MB limit of DynamoDB. Zero returns Limit items.
+	MaxPageSize int
+
+	mu     sync.Mutex
+	tables map[string]*table
+}
+
+type table struct {
+	desc  *dynamodb.TableDescription
+	items map[string]map[string]*dynamodb.AttributeValue
+}
+
+// New returns an empty DB.
+func New() *DB {
+	return &DB{tables: make(map[string]*table)}
+}
+
+// Connect returns the DB for any config. It can be used as db.Options.Connect.
+func (d *DB) Connect(cfg *config.DynamoDBConfig) (db.Client, error) {
+	return d, nil
+}
+
+// AddTable creates a table with a partition key, and an optional sort key.
+// Key attributes are strings.
+func (d *DB) AddTable(name, partitionKey, sortKey string) {
+	in := &dynamodb.CreateTableInput{
+		TableName: aws.String(name),
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String(partitionKey), KeyType: aws.String(dynamodb.KeyTypeHash)},
+		},
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String(partitionKey), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+		},
+		BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
+	}
+	if sortKey != "" {
+		in.KeySchema = append(in.KeySchema, &dynamodb.KeySchemaElement{
+			AttributeName: aws.String(sortKey), KeyType: aws.String(dynamodb.KeyTypeRange),
+		})
+		in.AttributeDefinitions = append(in.AttributeDefinitions, &dynamodb.AttributeDefinition{
+			AttributeName: aws.String(sortKey), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS),
+		})
+	}
+	if _, err := d.CreateTableWithContext(aws.BackgroundContext(), in); err != nil {
+		panic(err)
+	}
+}
+
+// Put stores items in the table, replacing items with the same keys.
+func (d *DB) Put(name string, items ...map[string]*dynamodb.AttributeValue) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	t := d.tables[name]
+	for _, item := range items {
+		t.items[t.key(item)] = item
+	}
+}
+
+// Items returns items of the table in the order of Scan.
+func (d *DB) Items(name string) []map[string]*dynamodb.AttributeValue {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	t, ok := d.tables[name]
+	if !ok {
+		return nil
+	}
+
+	var items []map[string]*dynamodb.AttributeValue
+	for _, k := range t.sortedKeys() {
+		items = append(items, t.items[k])
+	}
+	return items
+}
+
+// ScanWithContext returns a page of Limit or MaxPageSize items in the order of their keys.
+// With TotalSegments, items are split into segments by the hash of their keys.
+// ConsumedCapacity is approximated with the JSON size of items.
+// Expressions are not supported.
+func (d *DB) ScanWithContext(ctx aws.Context, input *dynamodb.ScanInput, opts ...request.Option) (*dynamodb.ScanOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	t, err := d.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, k := range t.sortedKeys() {
+		if input.TotalSegments != nil && segment(k, *input.TotalSegments) != aws.Int64Value(input.Segment) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	if input.ExclusiveStartKey != nil {
+		start := t.key(input.ExclusiveStartKey)
+		i := sort.SearchStrings(keys, start)
+		if i < len(keys) && keys[i] == start {
+			i++
+		}
+		keys = keys[i:]
+	}
+
+	o := &dynamodb.ScanOutput{}
+	limit := d.pageSize(input.Limit)
+	for i, k := range keys {
+		if i == limit {
+			o.LastEvaluatedKey = t.keyOf(o.Items[len(o.Items)-1])
+			break
+		}
+		o.Items = append(o.Items, copyItem(t.items[k]))
+	}
+	o.Count = aws.Int64(int64(len(o.Items)))
+	o.ScannedCount = o.Count
+	if aws.StringValue(input.ReturnConsumedCapacity) != "" && aws.StringValue(input.ReturnConsumedCapacity) != dynamodb.ReturnConsumedCapacityNone {
+		// An eventually consistent read consumes half a unit for every 4KB of the page
+		size := 0
+		for _, item := range o.Items {
+			size += itemSize(item)
+		}
+		o.ConsumedCapacity = &dynamodb.ConsumedCapacity{
+			TableName:     input.TableName,
+			CapacityUnits: aws.Float64(float64((size+4095)/4096) * 0.5),
+		}
+	}
+	return o, nil
+}
+
+// BatchWriteItemWithContext puts and deletes items.
+// Requests over MaxBatchWrite are returned as UnprocessedItems, and Throttle fails the call.
+func (d *DB) BatchWriteItemWithContext(ctx aws.Context, input *dynamodb.BatchWriteItemInput, opts ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.Throttle > 0 {
+		d.Throttle--
+		return nil, awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "The level of configured provisioned throughput for the table was exceeded.", nil)
+	}
+
+	n := 0
+	for _, wrs := range input.RequestItems {
+		n += len(wrs)
+	}
+	if n == 0 || n > 25 {
+		return nil, awserr.New("ValidationException", "1 to 25 requests are allowed in BatchWriteItem", nil)
+	}
+
+	o := &dynamodb.BatchWriteItemOutput{UnprocessedItems: make(map[string][]*dynamodb.WriteRequest)}
+	consumed := make(map[string]float64)
+	processed := 0
+	for name, wrs := range input.RequestItems {
+		t, err := d.table(aws.String(name))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, wr := range wrs {
+			if d.MaxBatchWrite > 0 && processed == d.MaxBatchWrite {
+				o.UnprocessedItems[name] = append(o.UnprocessedItems[name], wr)
+				continue
+			}
+			processed++
+
+			// A write consumes a unit for every 1KB of the item
+			switch {
+			case wr.PutRequest != nil:
+				t.items[t.key(wr.PutRequest.Item)] = copyItem(wr.PutRequest.Item)
+				consumed[name] += float64((itemSize(wr.PutRequest.Item) + 1023) / 1024)
+			case wr.DeleteRequest != nil:
+				k := t.key(wr.DeleteRequest.Key)
+				consumed[name] += float64((itemSize(t.items[k]) + 1023) / 1024)
+				delete(t.items, k)
+			}
+		}
+	}
+	if aws.StringValue(input.ReturnConsumedCapacity) != "" && aws.StringValue(input.ReturnConsumedCapacity) != dynamodb.ReturnConsumedCapacityNone {
+		for name, units := range consumed {
+			o.ConsumedCapacity = append(o.ConsumedCapacity, &dynamodb.ConsumedCapacity{
+				TableName:     aws.String(name),
+				CapacityUnits: aws.Float64(units),
+			})
+		}
+	}
+	return o, nil
+}
+
+// BatchGetItemWithContext returns items of keys. Keys over MaxBatchGet are returned as UnprocessedKeys.
+// Projections are not supported.
+func (d *DB) BatchGetItemWithContext(ctx aws.Context, input *dynamodb.BatchGetItemInput, opts ...request.Option) (*dynamodb.BatchGetItemOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	n := 0
+	for _, ka := range input.RequestItems {
+		n += len(ka.Keys)
+	}
+	if n == 0 || n > 100 {
+		return nil, awserr.New("ValidationException", "1 to 100 keys are allowed in BatchGetItem", nil)
+	}
+
+	o := &dynamodb.BatchGetItemOutput{
+		Responses:       make(map[string][]map[string]*dynamodb.AttributeValue),
+		UnprocessedKeys: make(map[string]*dynamodb.KeysAndAttributes),
+	}
+	processed := 0
+	for name, ka := range input.RequestItems {
+		t, err := d.table(aws.String(name))
+		if err != nil {
+			return nil, err
+		}
+
+		size := 0
+		for _, key := range ka.Keys {
+			if d.MaxBatchGet > 0 && processed == d.MaxBatchGet {
+				if o.UnprocessedKeys[name] == nil {
+					o.UnprocessedKeys[name] = &dynamodb.KeysAndAttributes{ConsistentRead: ka.ConsistentRead}
+				}
+				o.UnprocessedKeys[name].Keys = append(o.UnprocessedKeys[name].Keys, key)
+				continue
+			}
+			processed++
+
+			if item, ok := t.items[t.key(key)]; ok {
+				o.Responses[name] = append(o.Responses[name], copyItem(item))
+				size += itemSize(item)
+			}
+		}
+		if aws.StringValue(input.ReturnConsumedCapacity) != "" && aws.StringValue(input.ReturnConsumedCapacity) != dynamodb.ReturnConsumedCapacityNone {
+			o.ConsumedCapacity = append(o.ConsumedCapacity, &dynamodb.ConsumedCapacity{
+				TableName:     aws.String(name),
+				CapacityUnits: aws.Float64(float64((size + 4095) / 4096)),
+			})
+		}
+	}
+	return o, nil
+}
+
+// DescribeTableWithContext returns the table, or ResourceNotFoundException.
+func (d *DB) DescribeTableWithContext(ctx aws.Context, input *dynamodb.DescribeTableInput, opts ...request.Option) (*dynamodb.DescribeTableOutput, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	t, err := d.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	desc := *t.desc
+	desc.ItemCount = aws.Int64(int64(len(t.items)))
+	return &dynamodb.DescribeTableOutput{Table: &desc}, nil
+}
+
+// CreateTableWithContext creates an active table, or returns ResourceInUseException.
+func (d *DB) CreateTableWithContext(ctx aws.Context, input *dynamodb.CreateTableInput, opts ...request.Option) (*dynamodb.CreateTableOutput, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	name := aws.StringValue(input.TableName)
+	if _, ok := d.tables[name]; ok {
+		return nil, awserr.New(dynamodb.ErrCodeResourceInUseException, "Table already exists: "+name, nil)
+	}
+
+	desc := &dynamodb.TableDescription{
+		TableName:            input.TableName,
+		TableStatus:          aws.String(dynamodb.TableStatusActive),
+		KeySchema:            input.KeySchema,
+		AttributeDefinitions: input.AttributeDefinitions,
+		BillingModeSummary:   &dynamodb.BillingModeSummary{BillingMode: input.BillingMode},
+		ProvisionedThroughput: &dynamodb.ProvisionedThroughputDescription{
+			ReadCapacityUnits:  aws.Int64(0),
+			WriteCapacityUnits: aws.Int64(0),
+		},
+	}
+	if input.ProvisionedThroughput != nil {
+		desc.ProvisionedThroughput.ReadCapacityUnits = input.ProvisionedThroughput.ReadCapacityUnits
+		desc.ProvisionedThroughput.WriteCapacityUnits = input.ProvisionedThroughput.WriteCapacityUnits
+	}
+	for _, idx := range input.GlobalSecondaryIndexes {
+		desc.GlobalSecondaryIndexes = append(desc.GlobalSecondaryIndexes, &dynamodb.GlobalSecondaryIndexDescription{
+			IndexName:  idx.IndexName,
+			KeySchema:  idx.KeySchema,
+			Projection: idx.Projection,
+		})
+	}
+	for _, idx := range input.LocalSecondaryIndexes {
+		desc.LocalSecondaryIndexes = append(desc.LocalSecondaryIndexes, &dynamodb.LocalSecondaryIndexDescription{
+			IndexName:  idx.IndexName,
+			KeySchema:  idx.KeySchema,
+			Projection: idx.Projection,
+		})
+	}
+
+	d.tables[name] = &table{desc: desc, items: make(map[string]map[string]*dynamodb.AttributeValue)}
+	return &dynamodb.CreateTableOutput{TableDescription: desc}, nil
+}
+
+// WaitUntilTableExistsWithContext returns immediately since tables are created active.
+func (d *DB) WaitUntilTableExistsWithContext(ctx aws.Context, input *dynamodb.DescribeTableInput, opts ...request.WaiterOption) error {
+	_, err := d.DescribeTableWithContext(ctx, input)
+	return err
+}
+
+// pageSize returns the number of items of a page with the limit, or -1 for all items.
+func (d *DB) pageSize(limit *int64) int {
+	n := -1
+	if limit != nil {
+		n = int(*limit)
+	}
+	if d.MaxPageSize > 0 && (n < 0 || d.MaxPageSize < n) {
+		n = d.MaxPageSize
+	}
+	return n
+}
+
+func (d *DB) table(name *string) (*table, error) {
+	t, ok := d.tables[aws.StringValue(name)]
+	if !ok {
+		return nil, awserr.New(dynamodb.ErrCodeResourceNotFoundException, "Requested resource not found: "+aws.StringValue(name), nil)
+	}
+	return t, nil
+}
+
+// keyOf returns key attributes of the item.
+func (t *table) keyOf(item map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	key := make(map[string]*dynamodb.AttributeValue, len(t.desc.KeySchema))
+	for _, k := range t.desc.KeySchema {
+		key[*k.AttributeName] = item[*k.AttributeName]
+	}
+	return key
+}
+
+// key returns a string of key attributes, which sorts items in the order of the key schema.
+func (t *table) key(item map[string]*dynamodb.AttributeValue) string {
+	var key []interface{}
+	for _, k := range t.desc.KeySchema {
+		key = append(key, util.TypedDynamoValue(item[*k.AttributeName]))
+	}
+	b, _ := json.Marshal(key)
+	return string(b)
+}
+
+func (t *table) sortedKeys() []string {
+	keys := make([]string, 0, len(t.items))
+	for k := range t.items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// itemSize approximates the size of the item with its DynamoDB JSON.
+// Deleting a missing item is counted as 1 byte.
+func itemSize(item map[string]*dynamodb.AttributeValue) int {
+	if item == nil {
+		return 1
+	}
+	b, _ := json.Marshal(util.TypedDynamo(item))
+	return len(b)
+}
+
+func segment(key string, total int64) int64 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int64(h.Sum32()) % total
+}
+
+// copyItem copies the top-level attributes, so that callers can't change stored items.
+func copyItem(item map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	c := make(map[string]*dynamodb.AttributeValue, len(item))
+	for k, v := range item {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,287 @@
+package dbtest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func newDB(n int) *DB {
+	d := New()
+	d.AddTable("items", "pk", "sk")
+	for i := 0; i < n; i++ {
+		d.Put("items", map[string]*dynamodb.AttributeValue{
+			"pk":   {S: aws.String(fmt.Sprintf("p%d", i%2))},
+			"sk":   {S: aws.String(fmt.Sprintf("s%02d", i))},
+			"name": {S: aws.String(fmt.Sprint(i))},
+		})
+	}
+	return d
+}
+
+func errCode(err error) string {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code()
+	}
+	return ""
+}
+
+func TestScanPages(t *testing.T) {
+	d := newDB(10)
+	d.MaxPageSize = 3
+
+	var (
+		pages    int
+		startKey map[string]*dynamodb.AttributeValue
+		seen     = make(map[string]bool)
+	)
+	for {
+		o, err := d.ScanWithContext(aws.BackgroundContext(), &dynamodb.ScanInput{
+			TableName:         aws.String("items"),
+			Limit:             aws.Int64(4),
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		if len(o.Items) > 3 {
+			t.Errorf("page %d has %d items over MaxPageSize", pages, len(o.Items))
+		}
+		for _, item := range o.Items {
+			seen[*item["sk"].S] = true
+		}
+		if o.LastEvaluatedKey == nil {
+			break
+		}
+		startKey = o.LastEvaluatedKey
+	}
+	if pages != 4 || len(seen) != 10 {
+		t.Errorf("Scan read %d items in %d pages, want 10 items in 4 pages", len(seen), pages)
+	}
+}
+
+func TestScanSegments(t *testing.T) {
+	d := newDB(20)
+
+	seen := make(map[string]int)
+	for segment := int64(0); segment < 3; segment++ {
+		o, err := d.ScanWithContext(aws.BackgroundContext(), &dynamodb.ScanInput{
+			TableName:     aws.String("items"),
+			Segment:       aws.Int64(segment),
+			TotalSegments: aws.Int64(3),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range o.Items {
+			seen[*item["sk"].S]++
+		}
+	}
+	if len(seen) != 20 {
+		t.Errorf("segments have %d items, want 20", len(seen))
+	}
+	for sk, n := range seen {
+		if n != 1 {
+			t.Errorf("%s is in %d segments", sk, n)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	d := newDB(10)
+
+	o, err := d.QueryWithContext(aws.BackgroundContext(), &dynamodb.QueryInput{
+		TableName:              aws.String("items"),
+		KeyConditionExpression: aws.String("#pk = :pk AND #sk BETWEEN :lo AND :hi"),
+		ExpressionAttributeNames: map[string]*string{
+			"#pk": aws.String("pk"),
+			"#sk": aws.String("sk"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {S: aws.String("p0")},
+			":lo": {S: aws.String("s02")},
+			":hi": {S: aws.String("s06")},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, item := range o.Items {
+		got = append(got, *item["sk"].S)
+	}
+	if fmt.Sprint(got) != "[s02 s04 s06]" {
+		t.Errorf("Query() = %v, want [s02 s04 s06]", got)
+	}
+}
+
+func TestBatchWriteItemUnprocessed(t *testing.T) {
+	d := newDB(0)
+	d.MaxBatchWrite = 2
+	d.Throttle = 1
+
+	var wrs []*dynamodb.WriteRequest
+	for i := 0; i < 5; i++ {
+		wrs = append(wrs, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String("p")},
+			"sk": {S: aws.String(fmt.Sprint(i))},
+		}}})
+	}
+	in := &dynamodb.BatchWriteItemInput{RequestItems: map[string][]*dynamodb.WriteRequest{"items": wrs}}
+
+	_, err := d.BatchWriteItemWithContext(aws.BackgroundContext(), in)
+	if !request.IsErrorThrottle(err) {
+		t.Fatalf("BatchWriteItem() error = %v, want throttling", err)
+	}
+	o, err := d.BatchWriteItemWithContext(aws.BackgroundContext(), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(o.UnprocessedItems["items"]); n != 3 {
+		t.Errorf("BatchWriteItem() has %d unprocessed items, want 3", n)
+	}
+	if n := len(d.Items("items")); n != 2 {
+		t.Errorf("table has %d items, want 2", n)
+	}
+}
+
+func TestBatchGetItemUnprocessed(t *testing.T) {
+	d := newDB(4)
+	d.MaxBatchGet = 1
+
+	o, err := d.BatchGetItemWithContext(aws.BackgroundContext(), &dynamodb.BatchGetItemInput{
+		RequestItems: map[string]*dynamodb.KeysAndAttributes{"items": {Keys: []map[string]*dynamodb.AttributeValue{
+			{"pk": {S: aws.String("p0")}, "sk": {S: aws.String("s00")}},
+			{"pk": {S: aws.String("p1")}, "sk": {S: aws.String("s01")}},
+		}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(o.Responses["items"]) != 1 || len(o.UnprocessedKeys["items"].Keys) != 1 {
+		t.Errorf("BatchGetItem() = %d items and %d unprocessed keys, want 1 and 1", len(o.Responses["items"]), len(o.UnprocessedKeys["items"].Keys))
+	}
+}
+
+func TestUpdateItem(t *testing.T) {
+	key := map[string]*dynamodb.AttributeValue{"pk": {S: aws.String("p0")}, "sk": {S: aws.String("s00")}}
+	tests := []struct {
+		name     string
+		update   string
+		cond     string
+		wantCode string
+		want     map[string]*dynamodb.AttributeValue
+	}{
+		{
+			name:   "set and remove",
+			update: "SET #n2 = #n REMOVE #n",
+			cond:   "attribute_exists(#n) AND #n = :v",
+			want:   map[string]*dynamodb.AttributeValue{"pk": key["pk"], "sk": key["sk"], "renamed": {S: aws.String("0")}},
+		},
+		{
+			name:     "failed condition",
+			update:   "SET #n2 = :v",
+			cond:     "attribute_not_exists(#n)",
+			wantCode: dynamodb.ErrCodeConditionalCheckFailedException,
+		},
+		{
+			name:     "key",
+			update:   "SET #pk = :v",
+			wantCode: "ValidationException",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDB(1)
+			in := &dynamodb.UpdateItemInput{
+				TableName:        aws.String("items"),
+				Key:              key,
+				UpdateExpression: aws.String(tt.update),
+				ExpressionAttributeNames: map[string]*string{
+					"#n":  aws.String("name"),
+					"#n2": aws.String("renamed"),
+					"#pk": aws.String("pk"),
+				},
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":v": {S: aws.String("0")}},
+			}
+			if tt.cond != "" {
+				in.ConditionExpression = aws.String(tt.cond)
+			}
+			_, err := d.UpdateItemWithContext(aws.BackgroundContext(), in)
+			if errCode(err) != tt.wantCode {
+				t.Fatalf("UpdateItem() error = %v, want %q", err, tt.wantCode)
+			}
+			if tt.want == nil {
+				return
+			}
+			if got := d.Items("items")[0]; !equalValue(&dynamodb.AttributeValue{M: got}, &dynamodb.AttributeValue{M: tt.want}) {
+				t.Errorf("item = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransactWriteItems(t *testing.T) {
+	item := func(sk string) map[string]*dynamodb.AttributeValue {
+		return map[string]*dynamodb.AttributeValue{"pk": {S: aws.String("p0")}, "sk": {S: aws.String(sk)}}
+	}
+	move := func(to, cond string) *dynamodb.TransactWriteItemsInput {
+		return &dynamodb.TransactWriteItemsInput{TransactItems: []*dynamodb.TransactWriteItem{
+			{Put: &dynamodb.Put{
+				TableName:                aws.String("items"),
+				Item:                     item(to),
+				ConditionExpression:      aws.String("attribute_not_exists(#pk)"),
+				ExpressionAttributeNames: map[string]*string{"#pk": aws.String("pk")},
+			}},
+			{Delete: &dynamodb.Delete{
+				TableName:                 aws.String("items"),
+				Key:                       item("s00"),
+				ConditionExpression:       aws.String("#n = :v"),
+				ExpressionAttributeNames:  map[string]*string{"#n": aws.String("name")},
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":v": {S: aws.String(cond)}},
+			}},
+		}}
+	}
+	tests := []struct {
+		name        string
+		in          *dynamodb.TransactWriteItemsInput
+		wantReasons string
+		wantKeys    string
+	}{
+		{"moved", move("new", "0"), "[]", "[new s02]"},
+		{"existing key", move("s02", "0"), "[ConditionalCheckFailed None]", "[s00 s02]"},
+		{"changed item", move("new", "1"), "[None ConditionalCheckFailed]", "[s00 s02]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDB(3)
+			_, err := d.TransactWriteItemsWithContext(aws.BackgroundContext(), tt.in)
+			var reasons []string
+			if canceled, ok := err.(*dynamodb.TransactionCanceledException); ok {
+				for _, r := range canceled.CancellationReasons {
+					reasons = append(reasons, *r.Code)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(reasons) != tt.wantReasons {
+				t.Errorf("TransactWriteItems() reasons = %v, want %s", reasons, tt.wantReasons)
+			}
+
+			var keys []string
+			for _, item := range d.Items("items") {
+				if *item["pk"].S == "p0" {
+					keys = append(keys, *item["sk"].S)
+				}
+			}
+			if fmt.Sprint(keys) != tt.wantKeys {
+				t.Errorf("keys = %v, want %s", keys, tt.wantKeys)
+			}
+		})
+	}
+}
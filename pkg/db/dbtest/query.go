@@ -0,0 +1,221 @@
+package dbtest
+
+import (
+	"bytes"
+	"math/big"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+var (
+	compareRegexp    = regexp.MustCompile(`^(#?[\w.]+) (=|<|<=|>|>=) (:\w+)$`)
+	betweenRegexp    = regexp.MustCompile(`^(#?[\w.]+) BETWEEN (:\w+) AND (:\w+)$`)
+	beginsWithRegexp = regexp.MustCompile(`^begins_with\((#?[\w.]+), (:\w+)\)$`)
+)
+
+// QueryWithContext returns a page of Limit or MaxPageSize items of a partition in the order of the sort key.
+// KeyConditionExpression supports comparisons, BETWEEN and begins_with joined by AND.
+// Other expressions are not supported.
+func (d *DB) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	t, err := d.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	schema := t.desc.KeySchema
+	if input.IndexName != nil {
+		if schema = t.indexSchema(*input.IndexName); schema == nil {
+			return nil, awserr.New("ValidationException", "The table does not have the specified index: "+*input.IndexName, nil)
+		}
+	}
+	conds, err := keyConditions(aws.StringValue(input.KeyConditionExpression), input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, k := range t.sortedKeys() {
+		item := t.items[k]
+		ok := true
+		for _, c := range conds {
+			if !c(item) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			keys = append(keys, k)
+		}
+	}
+	// Items are sorted by keys of the schema, and then by keys of the table
+	sort.SliceStable(keys, func(i, j int) bool {
+		for _, k := range schema {
+			if c := compare(t.items[keys[i]][*k.AttributeName], t.items[keys[j]][*k.AttributeName]); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	if input.ExclusiveStartKey != nil {
+		start := t.key(input.ExclusiveStartKey)
+		for i, k := range keys {
+			if k == start {
+				keys = keys[i+1:]
+				break
+			}
+		}
+	}
+
+	o := &dynamodb.QueryOutput{}
+	limit := d.pageSize(input.Limit)
+	for i, k := range keys {
+		if i == limit {
+			last := o.Items[len(o.Items)-1]
+			o.LastEvaluatedKey = t.keyOf(last)
+			for _, k := range schema {
+				o.LastEvaluatedKey[*k.AttributeName] = last[*k.AttributeName]
+			}
+			break
+		}
+		o.Items = append(o.Items, copyItem(t.items[k]))
+	}
+	o.Count = aws.Int64(int64(len(o.Items)))
+	o.ScannedCount = o.Count
+	return o, nil
+}
+
+// indexSchema returns the key schema of the index, or nil if it doesn't exist.
+func (t *table) indexSchema(name string) []*dynamodb.KeySchemaElement {
+	for _, idx := range t.desc.GlobalSecondaryIndexes {
+		if aws.StringValue(idx.IndexName) == name {
+			return idx.KeySchema
+		}
+	}
+	for _, idx := range t.desc.LocalSecondaryIndexes {
+		if aws.StringValue(idx.IndexName) == name {
+			return idx.KeySchema
+		}
+	}
+	return nil
+}
+
+// keyConditions parses the expression into conditions of items.
+func keyConditions(expr string, names map[string]*string, values map[string]*dynamodb.AttributeValue) ([]func(map[string]*dynamodb.AttributeValue) bool, error) {
+	invalid := awserr.New("ValidationException", "Invalid KeyConditionExpression: "+expr, nil)
+	name := func(s string) string {
+		if n, ok := names[s]; ok {
+			return *n
+		}
+		return s
+	}
+
+	// BETWEEN has AND inside, so its parts are joined back
+	var parts []string
+	for _, p := range strings.Split(expr, " AND ") {
+		if n := len(parts); n > 0 && strings.Contains(parts[n-1], " BETWEEN ") && !strings.Contains(parts[n-1], " AND ") {
+			parts[n-1] += " AND " + p
+			continue
+		}
+		parts = append(parts, p)
+	}
+
+	var conds []func(map[string]*dynamodb.AttributeValue) bool
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		switch {
+		case compareRegexp.MatchString(p):
+			m := compareRegexp.FindStringSubmatch(p)
+			attr, op, v := name(m[1]), m[2], values[m[3]]
+			if v == nil {
+				return nil, invalid
+			}
+			conds = append(conds, func(item map[string]*dynamodb.AttributeValue) bool {
+				if item[attr] == nil {
+					return false
+				}
+				c := compare(item[attr], v)
+				switch op {
+				case "=":
+					return c == 0
+				case "<":
+					return c < 0
+				case "<=":
+					return c <= 0
+				case ">":
+					return c > 0
+				default:
+					return c >= 0
+				}
+			})
+		case betweenRegexp.MatchString(p):
+			m := betweenRegexp.FindStringSubmatch(p)
+			attr, lo, hi := name(m[1]), values[m[2]], values[m[3]]
+			if lo == nil || hi == nil {
+				return nil, invalid
+			}
+			conds = append(conds, func(item map[string]*dynamodb.AttributeValue) bool {
+				return item[attr] != nil && compare(item[attr], lo) >= 0 && compare(item[attr], hi) <= 0
+			})
+		case beginsWithRegexp.MatchString(p):
+			m := beginsWithRegexp.FindStringSubmatch(p)
+			attr, v := name(m[1]), values[m[2]]
+			if v == nil {
+				return nil, invalid
+			}
+			conds = append(conds, func(item map[string]*dynamodb.AttributeValue) bool {
+				av := item[attr]
+				switch {
+				case av == nil:
+					return false
+				case av.S != nil && v.S != nil:
+					return strings.HasPrefix(*av.S, *v.S)
+				case av.B != nil && v.B != nil:
+					return bytes.HasPrefix(av.B, v.B)
+				}
+				return false
+			})
+		default:
+			return nil, invalid
+		}
+	}
+	return conds, nil
+}
+
+// compare compares scalar values of the same type. Missing values come first.
+func compare(a, b *dynamodb.AttributeValue) int {
+	switch {
+	case a == nil || b == nil:
+		if a == b {
+			return 0
+		}
+		if a == nil {
+			return -1
+		}
+		return 1
+	case a.S != nil && b.S != nil:
+		return strings.Compare(*a.S, *b.S)
+	case a.N != nil && b.N != nil:
+		x, _ := new(big.Float).SetString(*a.N)
+		y, _ := new(big.Float).SetString(*b.N)
+		if x == nil || y == nil {
+			return strings.Compare(*a.N, *b.N)
+		}
+		return x.Cmp(y)
+	case a.B != nil && b.B != nil:
+		return bytes.Compare(a.B, b.B)
+	}
+	return 0
+}
@@ -0,0 +1,502 @@
+package dbtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/util"
+)
+
+var (
+	clauseRegexp      = regexp.MustCompile(`(?:^|\s)(SET|REMOVE)\s`)
+	ifNotExistsRegexp = regexp.MustCompile(`^if_not_exists\((.+), (:\w+)\)$`)
+	existsRegexp      = regexp.MustCompile(`^(attribute_exists|attribute_not_exists)\((.+)\)$`)
+	equalRegexp       = regexp.MustCompile(`^(.+) (=|<>) (:\w+)$`)
+	indexRegexp       = regexp.MustCompile(`^(.*)\[(\d+)\]$`)
+)
+
+// pathElement is a map key, or a list index if name is empty.
+type pathElement struct {
+	name  string
+	index int
+}
+
+// UpdateItemWithContext updates an item, or creates it if it doesn't exist.
+// UpdateExpression supports SET of paths, values and if_not_exists, and REMOVE.
+// ConditionExpression supports attribute_exists, attribute_not_exists, = and <> joined by AND.
+// Throttle fails the call like BatchWriteItem.
+func (d *DB) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.Throttle > 0 {
+		d.Throttle--
+		return nil, awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "The level of configured provisioned throughput for the table was exceeded.", nil)
+	}
+
+	t, err := d.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	if len(input.Key) != len(t.desc.KeySchema) {
+		return nil, awserr.New("ValidationException", "The provided key element does not match the schema", nil)
+	}
+	for _, k := range t.desc.KeySchema {
+		if input.Key[*k.AttributeName] == nil {
+			return nil, awserr.New("ValidationException", "The provided key element does not match the schema", nil)
+		}
+	}
+
+	names, values := input.ExpressionAttributeNames, input.ExpressionAttributeValues
+	k := t.key(input.Key)
+	old, exists := t.items[k]
+	item := copyItem(input.Key)
+	if exists {
+		item = cloneValue(&dynamodb.AttributeValue{M: old}).M
+	}
+
+	if cond := aws.StringValue(input.ConditionExpression); cond != "" {
+		ok, err := evalCondition(cond, names, values, item)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+		}
+	}
+
+	sets, removes, err := parseUpdate(aws.StringValue(input.UpdateExpression), names)
+	if err != nil {
+		return nil, err
+	}
+
+	// Operands are evaluated with the item before the update
+	invalid := awserr.New("ValidationException", "The document path provided in the update expression is invalid for update", nil)
+	setValues := make([]*dynamodb.AttributeValue, len(sets))
+	for i, s := range sets {
+		v, err := evalOperand(s.operand, names, values, item)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			return nil, awserr.New("ValidationException", "The provided expression refers to an attribute that does not exist in the item", nil)
+		}
+		setValues[i] = v
+	}
+	for _, s := range sets {
+		if isKey(t, s.path) {
+			return nil, awserr.New("ValidationException", "Cannot update attribute "+s.path[0].name+". This attribute is part of the key", nil)
+		}
+	}
+	for _, p := range removes {
+		if isKey(t, p) {
+			return nil, awserr.New("ValidationException", "Cannot update attribute "+p[0].name+". This attribute is part of the key", nil)
+		}
+	}
+
+	for i, s := range sets {
+		if !setPath(item, s.path, cloneValue(setValues[i])) {
+			return nil, invalid
+		}
+	}
+	// Indexes of a list are removed from the last, so that they don't shift each other
+	sort.SliceStable(removes, func(i, j int) bool {
+		return removes[i][len(removes[i])-1].index > removes[j][len(removes[j])-1].index
+	})
+	for _, p := range removes {
+		removePath(item, p)
+	}
+
+	t.items[k] = item
+	o := &dynamodb.UpdateItemOutput{}
+	if aws.StringValue(input.ReturnConsumedCapacity) != "" && aws.StringValue(input.ReturnConsumedCapacity) != dynamodb.ReturnConsumedCapacityNone {
+		o.ConsumedCapacity = &dynamodb.ConsumedCapacity{
+			TableName:     input.TableName,
+			CapacityUnits: aws.Float64(float64((itemSize(item) + 1023) / 1024)),
+		}
+	}
+	return o, nil
+}
+
+// TransactWriteItemsWithContext writes Put, Delete and ConditionCheck items of a transaction at once.
+// ConditionExpression of the items supports the same conditions as UpdateItem. If a condition fails,
+// nothing is written and TransactionCanceledException has ConditionalCheckFailed for the item, and None for the others.
+// Throttle fails the call like BatchWriteItem.
+func (d *DB) TransactWriteItemsWithContext(ctx aws.Context, input *dynamodb.TransactWriteItemsInput, opts ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.Throttle > 0 {
+		d.Throttle--
+		return nil, awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "The level of configured provisioned throughput for the table was exceeded.", nil)
+	}
+
+	type write struct {
+		t    *table
+		key  string
+		item map[string]*dynamodb.AttributeValue
+	}
+	var (
+		writes  []write
+		reasons []*dynamodb.CancellationReason
+		failed  bool
+		seen    = make(map[string]bool)
+	)
+	for _, ti := range input.TransactItems {
+		var (
+			name, cond *string
+			key        map[string]*dynamodb.AttributeValue
+			names      map[string]*string
+			values     map[string]*dynamodb.AttributeValue
+			// item is the written item of a Put, and nil for a Delete
+			item    map[string]*dynamodb.AttributeValue
+			isWrite bool
+		)
+		switch {
+		case ti.Put != nil:
+			name, cond, names, values = ti.Put.TableName, ti.Put.ConditionExpression, ti.Put.ExpressionAttributeNames, ti.Put.ExpressionAttributeValues
+			key, item, isWrite = ti.Put.Item, ti.Put.Item, true
+		case ti.Delete != nil:
+			name, cond, names, values = ti.Delete.TableName, ti.Delete.ConditionExpression, ti.Delete.ExpressionAttributeNames, ti.Delete.ExpressionAttributeValues
+			key, isWrite = ti.Delete.Key, true
+		case ti.ConditionCheck != nil:
+			name, cond, names, values = ti.ConditionCheck.TableName, ti.ConditionCheck.ConditionExpression, ti.ConditionCheck.ExpressionAttributeNames, ti.ConditionCheck.ExpressionAttributeValues
+			key = ti.ConditionCheck.Key
+		default:
+			return nil, awserr.New("ValidationException", "Only Put, Delete and ConditionCheck are supported", nil)
+		}
+
+		t, err := d.table(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range t.desc.KeySchema {
+			if key[*k.AttributeName] == nil {
+				return nil, awserr.New("ValidationException", "The provided key element does not match the schema", nil)
+			}
+		}
+		k := aws.StringValue(name) + "/" + t.key(key)
+		if seen[k] {
+			return nil, awserr.New("ValidationException", "Transaction request cannot include multiple operations on one item", nil)
+		}
+		seen[k] = true
+
+		reason := &dynamodb.CancellationReason{Code: aws.String("None")}
+		if c := aws.StringValue(cond); c != "" {
+			old := t.items[t.key(key)]
+			if old == nil {
+				old = make(map[string]*dynamodb.AttributeValue)
+			}
+			ok, err := evalCondition(c, names, values, old)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				reason = &dynamodb.CancellationReason{
+					Code:    aws.String("ConditionalCheckFailed"),
+					Message: aws.String("The conditional request failed"),
+				}
+				failed = true
+			}
+		}
+		reasons = append(reasons, reason)
+		if isWrite {
+			writes = append(writes, write{t: t, key: t.key(key), item: item})
+		}
+	}
+	if failed {
+		return nil, &dynamodb.TransactionCanceledException{
+			Message_:            aws.String("Transaction cancelled, please refer cancellation reasons for specific reasons"),
+			CancellationReasons: reasons,
+		}
+	}
+
+	var size int
+	for _, w := range writes {
+		if w.item == nil {
+			delete(w.t.items, w.key)
+			continue
+		}
+		w.t.items[w.key] = copyItem(w.item)
+		size += itemSize(w.item)
+	}
+	o := &dynamodb.TransactWriteItemsOutput{}
+	if aws.StringValue(input.ReturnConsumedCapacity) != "" && aws.StringValue(input.ReturnConsumedCapacity) != dynamodb.ReturnConsumedCapacityNone {
+		// Transactions consume twice the units of writes
+		o.ConsumedCapacity = []*dynamodb.ConsumedCapacity{{CapacityUnits: aws.Float64(float64(2 * (len(writes) + size/1024)))}}
+	}
+	return o, nil
+}
+
+type setAction struct {
+	path    []pathElement
+	operand string
+}
+
+// parseUpdate parses SET and REMOVE clauses of the expression.
+func parseUpdate(expr string, names map[string]*string) ([]setAction, [][]pathElement, error) {
+	invalid := awserr.New("ValidationException", "Invalid UpdateExpression: "+expr, nil)
+
+	var (
+		sets    []setAction
+		removes [][]pathElement
+	)
+	locs := clauseRegexp.FindAllStringSubmatchIndex(expr, -1)
+	if len(locs) == 0 {
+		return nil, nil, invalid
+	}
+	for i, loc := range locs {
+		end := len(expr)
+		if i+1 < len(locs) {
+			end = locs[i+1][0]
+		}
+		clause := expr[loc[2]:loc[3]]
+		for _, action := range splitTopLevel(expr[loc[1]:end]) {
+			action = strings.TrimSpace(action)
+			if clause == "REMOVE" {
+				p, err := parsePath(action, names)
+				if err != nil {
+					return nil, nil, invalid
+				}
+				removes = append(removes, p)
+				continue
+			}
+
+			parts := strings.SplitN(action, " = ", 2)
+			if len(parts) != 2 {
+				return nil, nil, invalid
+			}
+			p, err := parsePath(strings.TrimSpace(parts[0]), names)
+			if err != nil {
+				return nil, nil, invalid
+			}
+			sets = append(sets, setAction{path: p, operand: strings.TrimSpace(parts[1])})
+		}
+	}
+	return sets, removes, nil
+}
+
+// splitTopLevel splits actions by commas outside of parentheses.
+func splitTopLevel(s string) []string {
+	var (
+		parts []string
+		depth int
+		start int
+	)
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// evalOperand returns the value of a path, a placeholder of a value, or if_not_exists.
+// A missing path is nil.
+func evalOperand(operand string, names map[string]*string, values map[string]*dynamodb.AttributeValue, item map[string]*dynamodb.AttributeValue) (*dynamodb.AttributeValue, error) {
+	if m := ifNotExistsRegexp.FindStringSubmatch(operand); m != nil {
+		p, err := parsePath(m[1], names)
+		if err != nil {
+			return nil, err
+		}
+		if v := getPath(item, p); v != nil {
+			return v, nil
+		}
+		operand = m[2]
+	}
+	if strings.HasPrefix(operand, ":") {
+		v, ok := values[operand]
+		if !ok {
+			return nil, awserr.New("ValidationException", "An expression attribute value used in expression is not defined: "+operand, nil)
+		}
+		return v, nil
+	}
+	p, err := parsePath(operand, names)
+	if err != nil {
+		return nil, err
+	}
+	return getPath(item, p), nil
+}
+
+// evalCondition returns true if the item satisfies every condition of the expression.
+func evalCondition(expr string, names map[string]*string, values map[string]*dynamodb.AttributeValue, item map[string]*dynamodb.AttributeValue) (bool, error) {
+	invalid := awserr.New("ValidationException", "Invalid ConditionExpression: "+expr, nil)
+	for _, c := range strings.Split(expr, " AND ") {
+		c = strings.TrimSpace(c)
+		if m := existsRegexp.FindStringSubmatch(c); m != nil {
+			p, err := parsePath(m[2], names)
+			if err != nil {
+				return false, invalid
+			}
+			if (getPath(item, p) != nil) != (m[1] == "attribute_exists") {
+				return false, nil
+			}
+			continue
+		}
+		if m := equalRegexp.FindStringSubmatch(c); m != nil {
+			p, err := parsePath(m[1], names)
+			v, ok := values[m[3]]
+			if err != nil || !ok {
+				return false, invalid
+			}
+			if equal := equalValue(getPath(item, p), v); equal != (m[2] == "=") {
+				return false, nil
+			}
+			continue
+		}
+		return false, invalid
+	}
+	return true, nil
+}
+
+// parsePath parses a document path such as #a.#b[0] with placeholders of names.
+func parsePath(s string, names map[string]*string) ([]pathElement, error) {
+	invalid := awserr.New("ValidationException", "Invalid document path: "+s, nil)
+	var path []pathElement
+	for _, part := range strings.Split(s, ".") {
+		var indexes []int
+		for {
+			m := indexRegexp.FindStringSubmatch(part)
+			if m == nil {
+				break
+			}
+			i, _ := strconv.Atoi(m[2])
+			indexes = append([]int{i}, indexes...)
+			part = m[1]
+		}
+		if part == "" {
+			return nil, invalid
+		}
+		if strings.HasPrefix(part, "#") {
+			n, ok := names[part]
+			if !ok {
+				return nil, awserr.New("ValidationException", "An expression attribute name used in the document path is not defined: "+part, nil)
+			}
+			part = *n
+		}
+		path = append(path, pathElement{name: part})
+		for _, i := range indexes {
+			path = append(path, pathElement{index: i})
+		}
+	}
+	return path, nil
+}
+
+func isKey(t *table, path []pathElement) bool {
+	for _, k := range t.desc.KeySchema {
+		if len(path) == 1 && path[0].name == *k.AttributeName {
+			return true
+		}
+	}
+	return false
+}
+
+func getPath(item map[string]*dynamodb.AttributeValue, path []pathElement) *dynamodb.AttributeValue {
+	v := &dynamodb.AttributeValue{M: item}
+	for _, e := range path {
+		switch {
+		case e.name != "" && v.M != nil:
+			v = v.M[e.name]
+		case e.name == "" && v.L != nil && e.index < len(v.L):
+			v = v.L[e.index]
+		default:
+			return nil
+		}
+		if v == nil {
+			return nil
+		}
+	}
+	return v
+}
+
+// setPath sets the value at the path. It returns false if the parent of the path doesn't exist.
+// An index over the length of a list appends the value.
+func setPath(item map[string]*dynamodb.AttributeValue, path []pathElement, v *dynamodb.AttributeValue) bool {
+	parent := getPath(item, path[:len(path)-1])
+	if parent == nil {
+		return false
+	}
+	last := path[len(path)-1]
+	switch {
+	case last.name != "" && parent.M != nil:
+		parent.M[last.name] = v
+	case last.name == "" && parent.L != nil:
+		if last.index < len(parent.L) {
+			parent.L[last.index] = v
+		} else {
+			parent.L = append(parent.L, v)
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+func removePath(item map[string]*dynamodb.AttributeValue, path []pathElement) {
+	parent := getPath(item, path[:len(path)-1])
+	if parent == nil {
+		return
+	}
+	last := path[len(path)-1]
+	switch {
+	case last.name != "" && parent.M != nil:
+		delete(parent.M, last.name)
+	case last.name == "" && parent.L != nil && last.index < len(parent.L):
+		parent.L = append(parent.L[:last.index], parent.L[last.index+1:]...)
+	}
+}
+
+// cloneValue copies the value deeply, so that updates don't change values of callers.
+func cloneValue(v *dynamodb.AttributeValue) *dynamodb.AttributeValue {
+	if v == nil {
+		return nil
+	}
+	c := *v
+	if v.M != nil {
+		c.M = make(map[string]*dynamodb.AttributeValue, len(v.M))
+		for k, e := range v.M {
+			c.M[k] = cloneValue(e)
+		}
+	}
+	if v.L != nil {
+		c.L = make([]*dynamodb.AttributeValue, len(v.L))
+		for i, e := range v.L {
+			c.L[i] = cloneValue(e)
+		}
+	}
+	return &c
+}
+
+// equalValue compares values by their DynamoDB JSON.
+func equalValue(a, b *dynamodb.AttributeValue) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	x, _ := json.Marshal(util.TypedDynamoValue(a))
+	y, _ := json.Marshal(util.TypedDynamoValue(b))
+	return bytes.Equal(x, y)
+}
@@ -0,0 +1,340 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/config"
+	"github.com/daangn/dynamoutil/pkg/util"
+	"github.com/pkg/errors"
+)
+
+// defaultMaxDiffs is the default number of different items in DiffResult.
+const defaultMaxDiffs = 100
+
+// DiffKind is a kind of a different item.
+type DiffKind string
+
+// DiffKind constants
+const (
+	// DiffMissing is an item of the origin which is not in the target
+	DiffMissing DiffKind = "missing"
+	// DiffExtra is an item of the target which is not in the origin
+	DiffExtra DiffKind = "extra"
+	// DiffChanged is an item of both with different attributes
+	DiffChanged DiffKind = "changed"
+)
+
+// DiffResult is the result of Diff. Read is the number of items of both.
+type DiffResult struct {
+	Result
+	OriginItems  int64
+	TargetItems  int64
+	MissingItems int64
+	ExtraItems   int64
+	ChangedItems int64
+	// Diffs are the first MaxDiffs different items, in the order of kinds and keys.
+	Diffs []ItemDiff
+}
+
+// Equal returns true if the origin and the target have the same items.
+func (r *DiffResult) Equal() bool {
+	return r.MissingItems == 0 && r.ExtraItems == 0 && r.ChangedItems == 0
+}
+
+// ItemDiff is a different item. Attributes are set for DiffChanged.
+type ItemDiff struct {
+	Kind       DiffKind
+	Key        map[string]*dynamodb.AttributeValue
+	Attributes []AttributeDiff
+}
+
+// AttributeDiff is a different attribute of an item. A missing attribute is nil.
+type AttributeDiff struct {
+	Name   string
+	Origin *dynamodb.AttributeValue
+	Target *dynamodb.AttributeValue
+}
+
+// MarshalJSON writes the key as DynamoDB JSON.
+func (d ItemDiff) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind       DiffKind               `json:"kind"`
+		Key        map[string]interface{} `json:"key"`
+		Attributes []AttributeDiff        `json:"attributes,omitempty"`
+	}{d.Kind, util.TypedDynamo(d.Key), d.Attributes})
+}
+
+// MarshalJSON writes values as DynamoDB JSON.
+func (d AttributeDiff) MarshalJSON() ([]byte, error) {
+	v := struct {
+		Name   string      `json:"name"`
+		Origin interface{} `json:"origin"`
+		Target interface{} `json:"target"`
+	}{Name: d.Name}
+	if d.Origin != nil {
+		v.Origin = util.TypedDynamoValue(d.Origin)
+	}
+	if d.Target != nil {
+		v.Target = util.TypedDynamoValue(d.Target)
+	}
+	return json.Marshal(v)
+}
+
+// Diff compares items of the origin table with items of the target table, or of a dump file.
+// Items are matched by the primary key of the origin, and items of the origin are held in memory.
+func Diff(ctx context.Context, cfg *config.DynamoDBDiffConfig, opts *Options) (*DiffResult, error) {
+	if cfg.File == "" && cfg.Target == nil {
+		return nil, errors.New("either target or file is required")
+	}
+	cfg = diffDefaults(cfg)
+
+	originDB, err := opts.connect(cfg.Origin)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to origin database")
+	}
+	origin, err := describeTable(ctx, originDB, cfg.Origin.TableName)
+	if err != nil {
+		return nil, errors.Wrap(err, "origin")
+	}
+	keys := keyAttributes(origin)
+
+	t := newTracker(opts)
+	result := &DiffResult{}
+
+	var mu sync.Mutex
+	originItems := make(map[string]map[string]*dynamodb.AttributeValue)
+	err = parallelScan(ctx, originDB, &dynamodb.ScanInput{
+		TableName: &cfg.Origin.TableName,
+		Limit:     aws.Int64(2500),
+	}, cfg.Scan, nil, readLimiter(cfg.Throughput, origin), func(segment int, items []map[string]*dynamodb.AttributeValue, lastKey map[string]*dynamodb.AttributeValue) error {
+		t.addRead(len(items))
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, item := range items {
+			originItems[itemKey(item, keys)] = item
+		}
+		result.OriginItems += int64(len(items))
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read origin")
+	}
+
+	var missing, extra, changed []ItemDiff
+	compare := func(item map[string]*dynamodb.AttributeValue) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		result.TargetItems++
+		k := itemKey(item, keys)
+		o, ok := originItems[k]
+		if !ok {
+			result.ExtraItems++
+			extra = append(extra, ItemDiff{Kind: DiffExtra, Key: keyOf(item, keys)})
+			return
+		}
+		delete(originItems, k)
+
+		if attrs := diffAttributes(o, item); len(attrs) > 0 {
+			result.ChangedItems++
+			changed = append(changed, ItemDiff{Kind: DiffChanged, Key: keyOf(item, keys), Attributes: attrs})
+		}
+	}
+
+	if cfg.File != "" {
+		err = diffFile(cfg, t, compare)
+	} else {
+		err = diffTable(ctx, cfg, opts, t, compare)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range originItems {
+		result.MissingItems++
+		missing = append(missing, ItemDiff{Kind: DiffMissing, Key: keyOf(item, keys)})
+	}
+
+	for _, diffs := range [][]ItemDiff{missing, extra, changed} {
+		sort.Slice(diffs, func(i, j int) bool {
+			return itemKey(diffs[i].Key, keys) < itemKey(diffs[j].Key, keys)
+		})
+		for _, d := range diffs {
+			if len(result.Diffs) == cfg.MaxDiffs {
+				break
+			}
+			result.Diffs = append(result.Diffs, d)
+		}
+	}
+	result.Result = *t.result()
+	return result, nil
+}
+
+// diffDefaults returns a copy of the config with defaults.
+func diffDefaults(cfg *config.DynamoDBDiffConfig) *config.DynamoDBDiffConfig {
+	c := *cfg
+	if c.MaxDiffs < 1 {
+		c.MaxDiffs = defaultMaxDiffs
+	}
+	if c.Input == "" {
+		c.Input = config.DefaultOutput
+	}
+	return &c
+}
+
+// diffTable calls compare with every item of the target table.
+func diffTable(ctx context.Context, cfg *config.DynamoDBDiffConfig, opts *Options, t *tracker, compare func(item map[string]*dynamodb.AttributeValue)) error {
+	targetDB, err := opts.connect(cfg.Target)
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to target database")
+	}
+	target, err := describeTable(ctx, targetDB, cfg.Target.TableName)
+	if err != nil {
+		return errors.Wrap(err, "target")
+	}
+
+	err = parallelScan(ctx, targetDB, &dynamodb.ScanInput{
+		TableName: &cfg.Target.TableName,
+		Limit:     aws.Int64(2500),
+	}, cfg.Scan, nil, readLimiter(cfg.Throughput, target), func(segment int, items []map[string]*dynamodb.AttributeValue, lastKey map[string]*dynamodb.AttributeValue) error {
+		t.addRead(len(items))
+		for _, item := range items {
+			compare(item)
+		}
+		return nil
+	})
+	return errors.Wrap(err, "failed to read target")
+}
+
+// diffFile calls compare with every item of the dump file, which is read like readDumpFile.
+// Lines which can't be restored to items are failures of the result.
+func diffFile(cfg *config.DynamoDBDiffConfig, t *tracker, compare func(item map[string]*dynamodb.AttributeValue)) error {
+	unmarshalOpts := cfg.Types.UnmarshalOptions()
+	err := readDumpFile(cfg.File, cfg.Input, func(line int, raw []byte) error {
+		t.addRead(1)
+
+		item, err := loadItem(raw, cfg.Input, unmarshalOpts)
+		if err != nil {
+			t.fail(errors.Wrapf(err, "rejected line %d", line))
+			return nil
+		}
+		compare(item)
+		return nil
+	})
+	return errors.Wrap(err, "failed to read file")
+}
+
+// diffAttributes returns different attributes of items in the order of names.
+func diffAttributes(origin, target map[string]*dynamodb.AttributeValue) []AttributeDiff {
+	var names []string
+	for name := range origin {
+		names = append(names, name)
+	}
+	for name := range target {
+		if _, ok := origin[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var diffs []AttributeDiff
+	for _, name := range names {
+		if !equalValue(origin[name], target[name]) {
+			diffs = append(diffs, AttributeDiff{Name: name, Origin: origin[name], Target: target[name]})
+		}
+	}
+	return diffs
+}
+
+// equalValue returns true if values are the same. Elements of sets may be in any order.
+func equalValue(a, b *dynamodb.AttributeValue) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	switch {
+	case a.SS != nil || b.SS != nil:
+		return equalSet(aws.StringValueSlice(a.SS), aws.StringValueSlice(b.SS))
+	case a.NS != nil || b.NS != nil:
+		return equalSet(aws.StringValueSlice(a.NS), aws.StringValueSlice(b.NS))
+	case a.BS != nil || b.BS != nil:
+		var x, y []string
+		for _, e := range a.BS {
+			x = append(x, string(e))
+		}
+		for _, e := range b.BS {
+			y = append(y, string(e))
+		}
+		return equalSet(x, y)
+	case a.L != nil || b.L != nil:
+		if a.L == nil || b.L == nil || len(a.L) != len(b.L) {
+			return false
+		}
+		for i := range a.L {
+			if !equalValue(a.L[i], b.L[i]) {
+				return false
+			}
+		}
+		return true
+	case a.M != nil || b.M != nil:
+		if a.M == nil || b.M == nil || len(a.M) != len(b.M) {
+			return false
+		}
+		for k, v := range a.M {
+			if !equalValue(v, b.M[k]) {
+				return false
+			}
+		}
+		return true
+	case a.B != nil || b.B != nil:
+		return a.B != nil && b.B != nil && bytes.Equal(a.B, b.B)
+	}
+	return aws.StringValue(a.S) == aws.StringValue(b.S) && (a.S == nil) == (b.S == nil) &&
+		aws.StringValue(a.N) == aws.StringValue(b.N) && (a.N == nil) == (b.N == nil) &&
+		aws.BoolValue(a.BOOL) == aws.BoolValue(b.BOOL) && (a.BOOL == nil) == (b.BOOL == nil) &&
+		aws.BoolValue(a.NULL) == aws.BoolValue(b.NULL)
+}
+
+func equalSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// keyOf returns key attributes of the item.
+func keyOf(item map[string]*dynamodb.AttributeValue, keys []string) map[string]*dynamodb.AttributeValue {
+	key := make(map[string]*dynamodb.AttributeValue, len(keys))
+	for _, k := range keys {
+		key[k] = item[k]
+	}
+	return key
+}
+
+// itemKey returns a string of key attributes to match items by.
+func itemKey(item map[string]*dynamodb.AttributeValue, keys []string) string {
+	values := make([]interface{}, len(keys))
+	for i, k := range keys {
+		if v := item[k]; v != nil {
+			values[i] = util.TypedDynamoValue(v)
+		}
+	}
+	b, _ := json.Marshal(values)
+	return string(b)
+}
@@ -0,0 +1,42 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestEqualValue(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b *dynamodb.AttributeValue
+		want bool
+	}{
+		{"missing", nil, nil, true},
+		{"missing and value", nil, &dynamodb.AttributeValue{S: aws.String("")}, false},
+		{"S", &dynamodb.AttributeValue{S: aws.String("a")}, &dynamodb.AttributeValue{S: aws.String("a")}, true},
+		{"S and N", &dynamodb.AttributeValue{S: aws.String("1")}, &dynamodb.AttributeValue{N: aws.String("1")}, false},
+		{"BOOL", &dynamodb.AttributeValue{BOOL: aws.Bool(false)}, &dynamodb.AttributeValue{BOOL: aws.Bool(false)}, true},
+		{"BOOL and NULL", &dynamodb.AttributeValue{BOOL: aws.Bool(true)}, &dynamodb.AttributeValue{NULL: aws.Bool(true)}, false},
+		{"B", &dynamodb.AttributeValue{B: []byte("a")}, &dynamodb.AttributeValue{B: []byte("a")}, true},
+		{"SS in another order", &dynamodb.AttributeValue{SS: aws.StringSlice([]string{"a", "b"})}, &dynamodb.AttributeValue{SS: aws.StringSlice([]string{"b", "a"})}, true},
+		{"SS and L", &dynamodb.AttributeValue{SS: aws.StringSlice([]string{"a"})}, &dynamodb.AttributeValue{L: []*dynamodb.AttributeValue{{S: aws.String("a")}}}, false},
+		{"NS", &dynamodb.AttributeValue{NS: aws.StringSlice([]string{"1", "2"})}, &dynamodb.AttributeValue{NS: aws.StringSlice([]string{"2"})}, false},
+		{"BS in another order", &dynamodb.AttributeValue{BS: [][]byte{[]byte("a"), []byte("b")}}, &dynamodb.AttributeValue{BS: [][]byte{[]byte("b"), []byte("a")}}, true},
+		{"L in another order", &dynamodb.AttributeValue{L: []*dynamodb.AttributeValue{{N: aws.String("1")}, {N: aws.String("2")}}}, &dynamodb.AttributeValue{L: []*dynamodb.AttributeValue{{N: aws.String("2")}, {N: aws.String("1")}}}, false},
+		{"M", &dynamodb.AttributeValue{M: map[string]*dynamodb.AttributeValue{"a": {N: aws.String("1")}}}, &dynamodb.AttributeValue{M: map[string]*dynamodb.AttributeValue{"a": {N: aws.String("1")}}}, true},
+		{"M with another key", &dynamodb.AttributeValue{M: map[string]*dynamodb.AttributeValue{"a": {N: aws.String("1")}}}, &dynamodb.AttributeValue{M: map[string]*dynamodb.AttributeValue{"b": {N: aws.String("1")}}}, false},
+		{"empty M and L", &dynamodb.AttributeValue{M: map[string]*dynamodb.AttributeValue{}}, &dynamodb.AttributeValue{L: []*dynamodb.AttributeValue{}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := equalValue(tt.a, tt.b); got != tt.want {
+				t.Errorf("equalValue() = %v, want %v", got, tt.want)
+			}
+			if got := equalValue(tt.b, tt.a); got != tt.want {
+				t.Errorf("equalValue() of swapped values = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
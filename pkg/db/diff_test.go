@@ -0,0 +1,164 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/config"
+	"github.com/daangn/dynamoutil/pkg/db"
+	"github.com/daangn/dynamoutil/pkg/db/dbtest"
+)
+
+// diffTables returns a DB with the origin of items, and the target with differences of every kind.
+// item-001 and item-002 are changed, item-003 and item-004 are missing, and item-009 is extra.
+func diffTables() (*dbtest.DB, []map[string]*dynamodb.AttributeValue) {
+	items := newItems(5)
+	for _, item := range items {
+		item["tags"] = &dynamodb.AttributeValue{SS: aws.StringSlice([]string{"a", "b"})}
+	}
+	d := newTable("origin", items)
+	d.AddTable("target", "pk", "")
+
+	target := newItems(3)
+	// Sets are equal in any order
+	target[0]["tags"] = &dynamodb.AttributeValue{SS: aws.StringSlice([]string{"b", "a"})}
+	target[1]["tags"] = items[1]["tags"]
+	target[1]["name"] = &dynamodb.AttributeValue{S: aws.String("changed")}
+	target[2]["tags"] = items[2]["tags"]
+	delete(target[2], "count")
+	extra := newItems(10)[9]
+	d.Put("target", append(target, extra)...)
+	return d, items
+}
+
+func diffConfig() *config.DynamoDBDiffConfig {
+	return &config.DynamoDBDiffConfig{
+		Origin: &config.DynamoDBConfig{TableName: "origin"},
+		Target: &config.DynamoDBConfig{TableName: "target"},
+	}
+}
+
+// diffSummary returns kinds, keys and attributes of diffs in order.
+func diffSummary(diffs []db.ItemDiff) string {
+	var s []string
+	for _, d := range diffs {
+		var attrs []string
+		for _, a := range d.Attributes {
+			attrs = append(attrs, a.Name)
+		}
+		s = append(s, fmt.Sprintf("%s %s %v", d.Kind, *d.Key["pk"].S, attrs))
+	}
+	return fmt.Sprint(s)
+}
+
+func TestDiffTables(t *testing.T) {
+	d, _ := diffTables()
+	for _, segments := range []int{1, 3} {
+		cfg := diffConfig()
+		cfg.Scan.TotalSegments = segments
+		result, err := db.Diff(context.Background(), cfg, &db.Options{Connect: d.Connect})
+		if err != nil {
+			t.Fatalf("Diff() error = %v", err)
+		}
+		if result.Equal() {
+			t.Error("Equal() = true, want false")
+		}
+		if result.OriginItems != 5 || result.TargetItems != 4 {
+			t.Errorf("Diff() read %d and %d items, want 5 and 4", result.OriginItems, result.TargetItems)
+		}
+		if result.MissingItems != 2 || result.ExtraItems != 1 || result.ChangedItems != 2 {
+			t.Errorf("Diff() = %d missing, %d extra and %d changed items, want 2, 1 and 2", result.MissingItems, result.ExtraItems, result.ChangedItems)
+		}
+		want := "[missing item-003 [] missing item-004 [] extra item-009 [] changed item-001 [name] changed item-002 [count]]"
+		if got := diffSummary(result.Diffs); got != want {
+			t.Errorf("Diffs = %s, want %s", got, want)
+		}
+		if a := result.Diffs[4].Attributes[0]; a.Origin == nil || a.Target != nil {
+			t.Errorf("missing attribute = %v -> %v, want a value -> nil", a.Origin, a.Target)
+		}
+	}
+}
+
+func TestDiffMaxDiffs(t *testing.T) {
+	d, _ := diffTables()
+	cfg := diffConfig()
+	cfg.MaxDiffs = 3
+	result, err := db.Diff(context.Background(), cfg, &db.Options{Connect: d.Connect})
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	// Every different item is counted, but only MaxDiffs are reported
+	if len(result.Diffs) != 3 || result.MissingItems+result.ExtraItems+result.ChangedItems != 5 {
+		t.Errorf("Diff() reported %d of %d different items, want 3 of 5", len(result.Diffs), result.MissingItems+result.ExtraItems+result.ChangedItems)
+	}
+}
+
+func TestDiffEqual(t *testing.T) {
+	d := newTable("origin", newItems(10))
+	d.AddTable("target", "pk", "")
+	d.Put("target", newItems(10)...)
+
+	result, err := db.Diff(context.Background(), diffConfig(), &db.Options{Connect: d.Connect})
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	if !result.Equal() || len(result.Diffs) != 0 {
+		t.Errorf("Diff() = %s, want no differences", diffSummary(result.Diffs))
+	}
+}
+
+func TestDiffFile(t *testing.T) {
+	chdirTemp(t)
+	d, _ := diffTables()
+
+	// The target is dumped to a file, and a line which isn't an item is rejected
+	dump := dumpConfig()
+	dump.DynamoDB.TableName = "target"
+	if _, err := db.Dump(context.Background(), dump, &db.Options{Connect: d.Connect}); err != nil {
+		t.Fatalf("Dump() error = %v", err)
+	}
+	b, err := ioutil.ReadFile(dump.FileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dump.FileName, append([]byte("[]\n"), b...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := diffConfig()
+	cfg.Target = nil
+	cfg.File = dump.FileName
+	cfg.Input = dump.Output
+	result, err := db.Diff(context.Background(), cfg, &db.Options{Connect: d.Connect})
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	if result.TargetItems != 4 || result.Failed != 1 {
+		t.Errorf("Diff() read %d items and rejected %d lines, want 4 and 1", result.TargetItems, result.Failed)
+	}
+	want := "[missing item-003 [] missing item-004 [] extra item-009 [] changed item-001 [name] changed item-002 [count]]"
+	if got := diffSummary(result.Diffs); got != want {
+		t.Errorf("Diffs = %s, want %s", got, want)
+	}
+}
+
+func TestDiffErrors(t *testing.T) {
+	d, _ := diffTables()
+
+	cfg := diffConfig()
+	cfg.Target = nil
+	if _, err := db.Diff(context.Background(), cfg, &db.Options{Connect: d.Connect}); err == nil {
+		t.Error("Diff() without target and file succeeded")
+	}
+
+	cfg = diffConfig()
+	cfg.Target.TableName = "unknown"
+	if _, err := db.Diff(context.Background(), cfg, &db.Options{Connect: d.Connect}); !errors.Is(err, db.ErrTableNotFound) {
+		t.Errorf("Diff() error = %v, want ErrTableNotFound", err)
+	}
+}
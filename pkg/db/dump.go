@@ -1,117 +1,261 @@
 package db
 
 import (
-	"bufio"
-	"encoding/json"
-	"fmt"
-	"os"
-	"strings"
-	"time"
-
-	"github.com/aws/aws-sdk-go/aws"
+	"context"
+	"io"
+	"sync"
+
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/daangn/dynamoutil/pkg/config"
 	"github.com/daangn/dynamoutil/pkg/util"
-	"github.com/rs/zerolog/log"
-
-	. "github.com/logrusorgru/aurora"
+	"github.com/pkg/errors"
 )
 
-// Dump make a file
-func Dump(cfg *config.DynamoDBDumpConfig) error {
-	fmt.Println(
-		Bold(Green("service: ").String()+cfg.Service+" "),
-		BrightBlue("region: ").String()+cfg.DynamoDB.Region+" ",
-		BrightBlue("table: ").String()+cfg.DynamoDB.TableName+" ",
-		BrightBlue("endpoint: ").String()+cfg.DynamoDB.Endpoint+" ",
-		BrightBlue("output: ").String()+string(cfg.Output)+" ",
-	)
+// Dump writes all items of the table to files of the output.
+// Read is the number of scanned items, and Written is the number of items in the output.
+// Items which can't be written are failures of the result.
+func Dump(ctx context.Context, cfg *config.DynamoDBDumpConfig, opts *Options) (*Result, error) {
+	cfg = dumpDefaults(cfg)
+	remoteDB, err := opts.connect(&cfg.DynamoDB)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to origin database")
+	}
 
-	fmt.Printf("\nAre you sure about dumping all items from %s? [Y/n] ", BrightBlue(cfg.DynamoDB.TableName))
-	yn, _ := bufio.NewReader(os.Stdin).ReadString('\n')
-	if strings.Trim(yn, "\n") != "Y" {
-		fmt.Println(Green("Goodbye👋"))
-		return nil
+	table, err := describeTable(ctx, remoteDB, cfg.DynamoDB.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := newReader(remoteDB, table, cfg.Select, cfg.Scan, 10000)
+	if err != nil {
+		return nil, err
 	}
-	fmt.Print("\n")
 
-	remoteDB, err := new(&cfg.DynamoDB)
+	if cfg.Scan.Resume && cfg.Output == config.OutputParquet {
+		return nil, errors.Wrap(ErrResumeUnsupported, "parquet files can't be appended")
+	}
+	cp, err := openCheckpoint(cfg.Scan.Checkpoint, cfg.DynamoDB.TableName, r.segments(), cfg.Scan.Resume)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to connect to origin database. Check .dynamoutil.yaml or origin database status")
+		return nil, err
 	}
 
-	file, err := os.Create(cfg.FileName)
+	resume := cp.state.Output
+	if resume != nil {
+		if resume.Output != cfg.Output || resume.Compression != cfg.Compression {
+			return nil, errors.Wrapf(ErrCheckpointMismatch, "written with %s output and %q compression", resume.Output, resume.Compression)
+		}
+		if len(resume.Columns) > 0 {
+			cfg.CSV.Columns = resume.Columns
+		}
+	}
+
+	w, err := newDumpWriter(ctx, remoteDB, table, cfg, resume)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to open file")
+		return nil, errors.Wrap(err, "failed to create output")
 	}
-	defer file.Close()
 
-	if cfg.Output == "" {
-		cfg.Output = config.DefaultOutput
+	t := newTracker(opts)
+	read, written := cp.counts()
+	t.addRead(int(read))
+	t.addWritten(int(written))
+
+	// Segments are scanned concurrently, so a single writer serializes items
+	// to keep the output well-formed. The checkpoint is saved by the writer
+	// after items of a page are synced, so it never gets ahead of the output.
+	type page struct {
+		segment int
+		items   []map[string]*dynamodb.AttributeValue
+		lastKey map[string]*dynamodb.AttributeValue
+	}
+	var (
+		mu       sync.Mutex
+		writeErr error
+	)
+	failed := func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		return writeErr
 	}
 
-	now := time.Now()
-	var ops int32
+	pages := make(chan page, 10)
+	done := make(chan struct{})
 	go func() {
-		for {
-			time.Sleep(time.Millisecond * 100)
-			fmt.Printf("\r    Writes %d items. %.2f items/s", Blue(ops), Blue(float64(ops)/(time.Since(now).Seconds())))
+		defer close(done)
+
+		for p := range pages {
+			// Pages after an error are drained not to block the scan
+			if failed() != nil {
+				continue
+			}
+			if err := dumpPage(w, cp, cfg, t, p.segment, p.items, p.lastKey); err != nil {
+				mu.Lock()
+				writeErr = err
+				mu.Unlock()
+			}
 		}
 	}()
 
-	file.Write(cfg.Output.DumpPrefix())
-	var lastKey map[string]*dynamodb.AttributeValue
-	for {
-		o, err := remoteDB.Scan(&dynamodb.ScanInput{
-			TableName:         &cfg.DynamoDB.TableName,
-			Limit:             aws.Int64(10000),
-			ExclusiveStartKey: lastKey,
-		})
-		if err != nil {
-			log.Fatal().Err(err).Msg("Failed to scan origin dynamodb")
+	err = r.read(ctx, cp, readLimiter(cfg.Throughput, table), func(segment int, items []map[string]*dynamodb.AttributeValue, lastKey map[string]*dynamodb.AttributeValue) error {
+		t.addRead(len(items))
+		pages <- page{segment: segment, items: items, lastKey: lastKey}
+		return failed()
+	})
+	close(pages)
+	<-done
+	if err == nil {
+		err = failed()
+	}
+	if closeErr := w.Close(); err == nil && closeErr != nil {
+		err = errors.Wrap(closeErr, "failed to write the end of file")
+	}
+	if err != nil {
+		return t.result(), errors.Wrap(err, "failed to dump items")
+	}
+
+	if err := cp.remove(); err != nil {
+		return t.result(), errors.Wrap(err, "failed to remove checkpoint")
+	}
+	return t.result(), nil
+}
+
+// dumpDefaults returns a copy of the config with defaults.
+// Inferred columns are kept in the copy too, so that the config of the caller isn't changed.
+func dumpDefaults(cfg *config.DynamoDBDumpConfig) *config.DynamoDBDumpConfig {
+	c := *cfg
+	if c.Output == "" {
+		c.Output = config.DefaultOutput
+	}
+	if c.Binary == "" {
+		c.Binary = util.DefaultBinaryEncoding
+	}
+	if c.Scan.Checkpoint == "" {
+		c.Scan.Checkpoint = c.FileName + ".checkpoint.json"
+	}
+	return &c
+}
+
+// dumpPage writes items of a page, and saves the checkpoint if the output is synced.
+func dumpPage(w resumableWriter, cp *checkpoint, cfg *config.DynamoDBDumpConfig, t *tracker, segment int, items []map[string]*dynamodb.AttributeValue, lastKey map[string]*dynamodb.AttributeValue) error {
+	written := 0
+	for _, item := range items {
+		if err := w.Write(item); err != nil {
+			t.fail(errors.Wrap(err, "failed to write dynamodb object"))
+			continue
 		}
+		written++
+	}
+	t.addWritten(written)
 
-		max := len(o.Items) - 1
-		for i, item := range o.Items {
-			b, err := json.Marshal(item)
-			if err != nil {
-				log.Err(err).Send()
-				continue
-			}
+	cp.advance(segment, lastKey, len(items), written)
+	state := &outputState{Output: cfg.Output, Compression: cfg.Compression, Columns: cfg.CSV.Columns}
+	synced, err := w.Sync(state)
+	if err != nil {
+		return errors.Wrap(err, "failed to sync output")
+	}
+	if !synced {
+		return nil
+	}
+	return cp.save(state)
+}
 
-			var jsonItem map[string]interface{}
-			if err := json.Unmarshal(b, &jsonItem); err != nil {
-				log.Err(err).Send()
-				continue
-			}
+// newDumpWriter returns a writer of the output.
+// Columns of csv and tsv, and the schema of parquet are inferred before the output is opened.
+// With resume, the output is appended from the checkpoint.
+func newDumpWriter(ctx context.Context, remoteDB Client, table *dynamodb.TableDescription, cfg *config.DynamoDBDumpConfig, resume *outputState) (resumableWriter, error) {
+	switch cfg.Output {
+	case config.OutputCSV, config.OutputTSV:
+		switch cfg.CSV.ColumnOrder {
+		case "", columnOrderSorted, columnOrderFirstSeen:
+		default:
+			return nil, errors.Errorf("unsupported column order: %s", cfg.CSV.ColumnOrder)
+		}
 
-			marshaled, err := util.MarshalDynamo(jsonItem)
-			if err != nil {
-				log.Err(err).Msg("failed to marshal dynamodb object")
-				continue
-			}
+		open := func() (resumableWriter, error) {
+			return newOutputWriter(cfg, func(w io.Writer, resume *outputState) (itemWriter, error) {
+				return newCSVWriter(w, cfg, cfg.CSV.Columns, resume)
+			}, resume)
+		}
+		if len(cfg.CSV.Columns) > 0 {
+			return open()
+		}
 
-			b2, err := json.Marshal(marshaled)
-			if err != nil {
-				log.Err(err).Msg("failed to marshal dynamodb object to json")
-				continue
-			}
+		var keys []string
+		for _, k := range table.KeySchema {
+			keys = append(keys, *k.AttributeName)
+		}
 
-			file.Write(b2)
-			if max != i {
-				file.Write(cfg.Output.DumpDelimiter())
+		var names []string
+		seen := make(map[string]bool)
+		return inferringWriter(ctx, remoteDB, table, cfg, cfg.CSV.Inference, cfg.CSV.SampleSize, func(item map[string]*dynamodb.AttributeValue) {
+			for _, name := range attributeNames(item) {
+				if !seen[name] {
+					seen[name] = true
+					names = append(names, name)
+				}
 			}
-			ops++
-		}
-		if o.LastEvaluatedKey != nil {
-			lastKey = o.LastEvaluatedKey
-			file.Write(cfg.Output.DumpDelimiter())
-			continue
+		}, func() (resumableWriter, error) {
+			// Inferred columns are saved in the checkpoint with the config
+			cfg.CSV.Columns = orderColumns(keys, names, cfg.CSV.ColumnOrder)
+			return open()
+		})
+	case config.OutputParquet:
+		if err := validateParquetConfig(&cfg.Parquet); err != nil {
+			return nil, err
 		}
 
-		break
+		root := newParquetStruct()
+		return inferringWriter(ctx, remoteDB, table, cfg, cfg.Parquet.Inference, cfg.Parquet.SampleSize, func(item map[string]*dynamodb.AttributeValue) {
+			root.add(item, &cfg.Parquet)
+		}, func() (resumableWriter, error) {
+			return newOutputWriter(cfg, func(w io.Writer, resume *outputState) (itemWriter, error) {
+				return newParquetWriter(w, cfg, root)
+			}, nil)
+		})
+	default:
+		return newOutputWriter(cfg, func(w io.Writer, resume *outputState) (itemWriter, error) {
+			return newJSONWriter(w, cfg, resume)
+		}, resume)
 	}
-	file.Write(cfg.Output.DumpSuffix())
+}
 
-	return nil
+// inferringWriter calls add with items to infer a schema, and then opens the output.
+// With twoPass inference, this scans the whole table first.
+// Otherwise, the first sampleSize items are buffered until the output is opened.
+func inferringWriter(ctx context.Context, remoteDB Client, table *dynamodb.TableDescription, cfg *config.DynamoDBDumpConfig, inference string, sampleSize int, add func(item map[string]*dynamodb.AttributeValue), open func() (resumableWriter, error)) (resumableWriter, error) {
+	switch inference {
+	case "", inferenceSample:
+		if sampleSize < 1 {
+			sampleSize = defaultSampleSize
+		}
+		return &samplingWriter{
+			size: sampleSize,
+			open: func(sample []map[string]*dynamodb.AttributeValue) (itemWriter, error) {
+				for _, item := range sample {
+					add(item)
+				}
+				return open()
+			},
+		}, nil
+	case inferenceTwoPass:
+		r, err := newReader(remoteDB, table, cfg.Select, cfg.Scan, 10000)
+		if err != nil {
+			return nil, err
+		}
+
+		var mu sync.Mutex
+		err = r.read(ctx, nil, readLimiter(cfg.Throughput, table), func(segment int, page []map[string]*dynamodb.AttributeValue, lastKey map[string]*dynamodb.AttributeValue) error {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, item := range page {
+				add(item)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return open()
+	default:
+		return nil, errors.Errorf("unsupported inference: %s", inference)
+	}
 }
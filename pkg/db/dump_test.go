@@ -0,0 +1,218 @@
+package db_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/config"
+	"github.com/daangn/dynamoutil/pkg/db"
+)
+
+func dumpConfig() *config.DynamoDBDumpConfig {
+	return &config.DynamoDBDumpConfig{
+		DynamoDB: config.DynamoDBConfig{TableName: "origin"},
+		FileName: "items.jsonl",
+		Output:   config.OutputAttributeValue,
+	}
+}
+
+// readItems reads items of an attributeValue dump, and fails the test if an item is written twice.
+func readItems(t *testing.T, name string) map[string]map[string]*dynamodb.AttributeValue {
+	t.Helper()
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	items := make(map[string]map[string]*dynamodb.AttributeValue)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var item map[string]*dynamodb.AttributeValue
+		if err := json.Unmarshal(s.Bytes(), &item); err != nil {
+			t.Fatalf("invalid line %q: %v", s.Text(), err)
+		}
+		pk := *item["pk"].S
+		if _, ok := items[pk]; ok {
+			t.Errorf("%s is written twice", pk)
+		}
+		items[pk] = item
+	}
+	return items
+}
+
+func TestDumpPages(t *testing.T) {
+	for _, segments := range []int{1, 4} {
+		chdirTemp(t)
+		d := newTable("origin", newItems(40))
+		d.MaxPageSize = 6
+
+		cfg := dumpConfig()
+		cfg.Scan.TotalSegments = segments
+		result, err := db.Dump(context.Background(), cfg, &db.Options{Connect: d.Connect})
+		if err != nil {
+			t.Fatalf("Dump() error = %v", err)
+		}
+		if result.Read != 40 || result.Written != 40 {
+			t.Errorf("Dump() with %d segments read %d and wrote %d items, want 40", segments, result.Read, result.Written)
+		}
+		if n := len(readItems(t, "items.jsonl")); n != 40 {
+			t.Errorf("dump has %d items, want 40", n)
+		}
+	}
+}
+
+func TestDumpResume(t *testing.T) {
+	for _, compression := range []config.Compression{config.CompressionNone, config.CompressionGzip} {
+		chdirTemp(t)
+		items := newItems(20)
+		d := newTable("origin", items)
+		d.MaxPageSize = 5
+
+		cfg := dumpConfig()
+		cfg.Compression = compression
+		if _, err := db.Dump(context.Background(), cfg, &db.Options{Connect: failAfterScans(d, 2).Connect}); err == nil {
+			t.Fatal("Dump() succeeded with a lost connection")
+		}
+
+		cfg.Scan.Resume = true
+		result, err := db.Dump(context.Background(), cfg, &db.Options{Connect: d.Connect})
+		if err != nil {
+			t.Fatalf("resumed Dump() error = %v", err)
+		}
+		if result.Read != 20 || result.Written != 20 {
+			t.Errorf("resumed Dump() read %d and wrote %d items, want 20", result.Read, result.Written)
+		}
+
+		// The dump is loaded to check that resumed gzip streams are read as a file
+		d.AddTable("target", "pk", "")
+		_, err = db.Load(context.Background(), &config.DynamoDBLoadConfig{
+			DynamoDB: config.DynamoDBConfig{TableName: "target"},
+			FileName: "items.jsonl" + compression.Ext(),
+			Input:    config.OutputAttributeValue,
+		}, &db.Options{Connect: d.Connect})
+		if err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		assertItems(t, d, "target", items)
+		if compression == config.CompressionNone && len(readItems(t, "items.jsonl")) != 20 {
+			t.Error("resumed dump doesn't have 20 items")
+		}
+	}
+}
+
+func TestDumpParquetResume(t *testing.T) {
+	chdirTemp(t)
+	d := newTable("origin", newItems(3))
+
+	cfg := dumpConfig()
+	cfg.Output = config.OutputParquet
+	cfg.Scan.Resume = true
+	_, err := db.Dump(context.Background(), cfg, &db.Options{Connect: d.Connect})
+	if !errors.Is(err, db.ErrResumeUnsupported) {
+		t.Errorf("Dump() error = %v, want ErrResumeUnsupported", err)
+	}
+}
+
+func TestDumpCSVSample(t *testing.T) {
+	chdirTemp(t)
+	items := newItems(5)
+	items[4]["extra"] = &dynamodb.AttributeValue{S: aws.String("x")}
+	items[3]["none"] = &dynamodb.AttributeValue{NULL: aws.Bool(true)}
+	d := newTable("origin", items)
+
+	// Columns are inferred from the first 2 items, so the item with extra is a failure instead of a row without it
+	cfg := dumpConfig()
+	cfg.Output = config.OutputCSV
+	cfg.FileName = "items.csv"
+	cfg.CSV = config.CSVConfig{Inference: "sample", SampleSize: 2}
+	result, err := db.Dump(context.Background(), cfg, &db.Options{Connect: d.Connect})
+	if err != nil {
+		t.Fatalf("Dump() error = %v", err)
+	}
+	if result.Written != 4 || result.Failed != 1 {
+		t.Fatalf("Dump() wrote %d and failed %d items, want 4 and 1", result.Written, result.Failed)
+	}
+
+	f, err := os.Open("items.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 5 || strings.Join(rows[0], ",") != "pk,count,name" {
+		t.Errorf("csv has %d rows with header %v, want 5 rows with pk,count,name", len(rows), rows[0])
+	}
+}
+
+// typedItem has a value of every type of DynamoDB.
+func typedItem() map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk":   {S: aws.String("typed")},
+		"n":    {N: aws.String("1.50")},
+		"b":    {B: []byte("bin")},
+		"ok":   {BOOL: aws.Bool(false)},
+		"none": {NULL: aws.Bool(true)},
+		"ss":   {SS: aws.StringSlice([]string{"a", "b"})},
+		"ns":   {NS: aws.StringSlice([]string{"1", "2"})},
+		"bs":   {BS: [][]byte{[]byte("x")}},
+		"l":    {L: []*dynamodb.AttributeValue{{N: aws.String("1")}, {S: aws.String("1")}}},
+		"m":    {M: map[string]*dynamodb.AttributeValue{"empty": {S: aws.String("")}}},
+	}
+}
+
+func TestDumpTypedOutputs(t *testing.T) {
+	const value = `{"b":{"B":"Ymlu"},"bs":{"BS":["eA=="]},"l":{"L":[{"N":"1"},{"S":"1"}]},"m":{"M":{"empty":{"S":""}}},` +
+		`"n":{"N":"1.50"},"none":{"NULL":true},"ns":{"NS":["1","2"]},"ok":{"BOOL":false},"pk":{"S":"typed"},"ss":{"SS":["a","b"]}}`
+	tests := []struct {
+		output config.Output
+		want   string
+	}{
+		{config.OutputAttributeValue, value},
+		{config.OutputDynamoDBJSON, `{"Item":` + value + "}"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.output), func(t *testing.T) {
+			chdirTemp(t)
+			d := newTable("origin", []map[string]*dynamodb.AttributeValue{typedItem()})
+			d.AddTable("target", "pk", "")
+
+			cfg := dumpConfig()
+			cfg.Output = tt.output
+			if _, err := db.Dump(context.Background(), cfg, &db.Options{Connect: d.Connect}); err != nil {
+				t.Fatalf("Dump() error = %v", err)
+			}
+			b, err := ioutil.ReadFile("items.jsonl")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("dump = %s, want %s", b, tt.want)
+			}
+
+			// Types are kept, so that the dump is loaded as the same item
+			_, err = db.Load(context.Background(), &config.DynamoDBLoadConfig{
+				DynamoDB: config.DynamoDBConfig{TableName: "target"},
+				FileName: "items.jsonl",
+				Input:    tt.output,
+			}, &db.Options{Connect: d.Connect})
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			assertItems(t, d, "target", []map[string]*dynamodb.AttributeValue{typedItem()})
+		})
+	}
+}
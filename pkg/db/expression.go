@@ -0,0 +1,138 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/config"
+	"github.com/daangn/dynamoutil/pkg/util"
+	"github.com/pkg/errors"
+)
+
+// expression holds expressions of a read, and their placeholders.
+type expression struct {
+	index      *string
+	filter     *string
+	projection *string
+	names      map[string]*string
+	values     map[string]*dynamodb.AttributeValue
+}
+
+// newExpression builds expressions of the config.
+// keys are added to the projection, so that projected items can be written to a table.
+func newExpression(cfg config.SelectConfig, keys ...string) (*expression, error) {
+	e := &expression{
+		names:  make(map[string]*string),
+		values: make(map[string]*dynamodb.AttributeValue),
+	}
+	if cfg.Index != "" {
+		e.index = aws.String(cfg.Index)
+	}
+
+	if cfg.Filter.Expression != "" {
+		e.filter = aws.String(cfg.Filter.Expression)
+	}
+	for _, n := range cfg.Filter.Names {
+		e.names[n.Name] = aws.String(n.Attribute)
+	}
+	for _, v := range cfg.Filter.Values {
+		av, err := expressionValue(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid filter value %s", v.Name)
+		}
+		e.values[v.Name] = av
+	}
+
+	if len(cfg.Projection) > 0 {
+		var (
+			placeholders []string
+			seen         = make(map[string]bool)
+		)
+		for _, attr := range append(append([]string{}, cfg.Projection...), keys...) {
+			if seen[attr] {
+				continue
+			}
+			seen[attr] = true
+
+			// Every attribute is a placeholder, so reserved words can be projected
+			p := fmt.Sprintf("#proj%d", len(placeholders))
+			e.names[p] = aws.String(attr)
+			placeholders = append(placeholders, p)
+		}
+		e.projection = aws.String(strings.Join(placeholders, ", "))
+	}
+	return e, nil
+}
+
+// applyScan sets the expressions to the input.
+func (e *expression) applyScan(input *dynamodb.ScanInput) {
+	input.IndexName = e.index
+	input.FilterExpression = e.filter
+	input.ProjectionExpression = e.projection
+	// DynamoDB rejects empty maps of placeholders
+	if len(e.names) > 0 {
+		input.ExpressionAttributeNames = e.names
+	}
+	if len(e.values) > 0 {
+		input.ExpressionAttributeValues = e.values
+	}
+}
+
+// expressionValue converts a value of the config file like an attribute of a loaded item.
+func expressionValue(v config.ExpressionValue) (*dynamodb.AttributeValue, error) {
+	// Numbers of the config file are decoded as Go numbers, and maps may have interface{} keys
+	b, err := json.Marshal(stringKeys(v.Value))
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var value interface{}
+	if err := d.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	opts := &util.UnmarshalOptions{}
+	if v.Type != "" {
+		opts.Types = map[string]string{v.Name: v.Type}
+	}
+	return util.UnmarshalDynamoValue(value, v.Name, opts)
+}
+
+func stringKeys(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = stringKeys(e)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = stringKeys(e)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			l[i] = stringKeys(e)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+// keyAttributes returns names of key attributes of the table.
+func keyAttributes(table *dynamodb.TableDescription) []string {
+	var keys []string
+	for _, k := range table.KeySchema {
+		keys = append(keys, aws.StringValue(k.AttributeName))
+	}
+	return keys
+}
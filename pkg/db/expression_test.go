@@ -0,0 +1,108 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/config"
+)
+
+func TestExpressionApplyScan(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.SelectConfig
+		keys []string
+		want dynamodb.ScanInput
+	}{
+		{
+			name: "none",
+			want: dynamodb.ScanInput{},
+		},
+		{
+			name: "index",
+			cfg:  config.SelectConfig{Index: "by-status"},
+			want: dynamodb.ScanInput{IndexName: aws.String("by-status")},
+		},
+		{
+			name: "filter",
+			cfg: config.SelectConfig{Filter: config.FilterConfig{
+				Expression: "#status = :status AND #count > :count",
+				Names:      []config.ExpressionName{{Name: "#status", Attribute: "status"}, {Name: "#count", Attribute: "count"}},
+				Values: []config.ExpressionValue{
+					{Name: ":status", Value: "active"},
+					{Name: ":count", Value: 10},
+				},
+			}},
+			want: dynamodb.ScanInput{
+				FilterExpression:         aws.String("#status = :status AND #count > :count"),
+				ExpressionAttributeNames: map[string]*string{"#status": aws.String("status"), "#count": aws.String("count")},
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+					":status": {S: aws.String("active")},
+					":count":  {N: aws.String("10")},
+				},
+			},
+		},
+		{
+			name: "typed and nested values",
+			cfg: config.SelectConfig{Filter: config.FilterConfig{
+				Expression: "code = :code AND meta = :meta",
+				Values: []config.ExpressionValue{
+					{Name: ":code", Value: "1E+3", Type: "N"},
+					// Maps of yaml have interface{} keys
+					{Name: ":meta", Value: map[interface{}]interface{}{"level": 1}},
+				},
+			}},
+			want: dynamodb.ScanInput{
+				FilterExpression: aws.String("code = :code AND meta = :meta"),
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+					":code": {N: aws.String("1E+3")},
+					":meta": {M: map[string]*dynamodb.AttributeValue{"level": {N: aws.String("1")}}},
+				},
+			},
+		},
+		{
+			name: "projection with keys",
+			cfg:  config.SelectConfig{Projection: []string{"name", "pk", "size"}},
+			keys: []string{"pk", "sk"},
+			want: dynamodb.ScanInput{
+				ProjectionExpression: aws.String("#proj0, #proj1, #proj2, #proj3"),
+				ExpressionAttributeNames: map[string]*string{
+					"#proj0": aws.String("name"),
+					"#proj1": aws.String("pk"),
+					"#proj2": aws.String("size"),
+					"#proj3": aws.String("sk"),
+				},
+			},
+		},
+		{
+			name: "keys without projection",
+			keys: []string{"pk"},
+			want: dynamodb.ScanInput{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := newExpression(tt.cfg, tt.keys...)
+			if err != nil {
+				t.Fatalf("newExpression() error = %v", err)
+			}
+			var got dynamodb.ScanInput
+			e.applyScan(&got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("applyScan() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpressionInvalidValue(t *testing.T) {
+	_, err := newExpression(config.SelectConfig{Filter: config.FilterConfig{
+		Expression: "n = :n",
+		Values:     []config.ExpressionValue{{Name: ":n", Value: "ten", Type: "N"}},
+	}})
+	if err == nil {
+		t.Error("newExpression() with a string of N error = nil")
+	}
+}
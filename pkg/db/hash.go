@@ -0,0 +1,52 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/util"
+)
+
+// itemHash returns a hash of the item, which is the same for equal items.
+// Attributes are hashed in the order of names, and elements of sets are sorted.
+func itemHash(item map[string]*dynamodb.AttributeValue) [sha256.Size]byte {
+	// json sorts keys of maps, so only sets are sorted here
+	b, _ := json.Marshal(util.TypedDynamoValue(canonicalValue(&dynamodb.AttributeValue{M: item})))
+	return sha256.Sum256(b)
+}
+
+// canonicalValue returns a copy of the value with sorted sets.
+func canonicalValue(av *dynamodb.AttributeValue) *dynamodb.AttributeValue {
+	if av == nil {
+		return nil
+	}
+	c := *av
+	switch {
+	case av.SS != nil:
+		c.SS = sortedStrings(av.SS)
+	case av.NS != nil:
+		c.NS = sortedStrings(av.NS)
+	case av.BS != nil:
+		c.BS = append([][]byte{}, av.BS...)
+		sort.Slice(c.BS, func(i, j int) bool { return string(c.BS[i]) < string(c.BS[j]) })
+	case av.L != nil:
+		c.L = make([]*dynamodb.AttributeValue, len(av.L))
+		for i, e := range av.L {
+			c.L[i] = canonicalValue(e)
+		}
+	case av.M != nil:
+		c.M = make(map[string]*dynamodb.AttributeValue, len(av.M))
+		for k, v := range av.M {
+			c.M[k] = canonicalValue(v)
+		}
+	}
+	return &c
+}
+
+func sortedStrings(ss []*string) []*string {
+	c := append([]*string{}, ss...)
+	sort.Slice(c, func(i, j int) bool { return *c[i] < *c[j] })
+	return c
+}
@@ -0,0 +1,240 @@
+package db
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/config"
+	"github.com/daangn/dynamoutil/pkg/util"
+	"github.com/pkg/errors"
+)
+
+// rollbackChunk is the number of journal entries restored at the same time
+const rollbackChunk = 1000
+
+// journalEntry is a line of a rename or transform journal.
+// Before and After are the changed attributes of the item before and after the change.
+// If the key is changed, NewKey is the key after the change, and Before and After are whole items.
+type journalEntry struct {
+	Key    map[string]*dynamodb.AttributeValue `json:"key"`
+	NewKey map[string]*dynamodb.AttributeValue `json:"newKey,omitempty"`
+	Before map[string]*dynamodb.AttributeValue `json:"before"`
+	After  map[string]*dynamodb.AttributeValue `json:"after"`
+}
+
+// MarshalJSON writes attributes as DynamoDB JSON.
+func (e journalEntry) MarshalJSON() ([]byte, error) {
+	v := struct {
+		Key    map[string]interface{} `json:"key"`
+		NewKey map[string]interface{} `json:"newKey,omitempty"`
+		Before map[string]interface{} `json:"before"`
+		After  map[string]interface{} `json:"after"`
+	}{
+		Key:    util.TypedDynamo(e.Key),
+		Before: util.TypedDynamo(e.Before),
+		After:  util.TypedDynamo(e.After),
+	}
+	if e.NewKey != nil {
+		v.NewKey = util.TypedDynamo(e.NewKey)
+	}
+	return json.Marshal(v)
+}
+
+// newJournalEntry returns an entry of the item changed to renamed.
+func newJournalEntry(item, renamed map[string]*dynamodb.AttributeValue, keys []string) journalEntry {
+	e := journalEntry{Key: keyOf(item, keys)}
+	if keyChanged(item, renamed, keys) {
+		e.NewKey = keyOf(renamed, keys)
+		e.Before, e.After = item, renamed
+		return e
+	}
+
+	e.Before = make(map[string]*dynamodb.AttributeValue)
+	e.After = make(map[string]*dynamodb.AttributeValue)
+	for _, name := range append(attributeNames(item), attributeNames(renamed)...) {
+		if equalValue(item[name], renamed[name]) {
+			continue
+		}
+		if v := item[name]; v != nil {
+			e.Before[name] = v
+		}
+		if v := renamed[name]; v != nil {
+			e.After[name] = v
+		}
+	}
+	return e
+}
+
+// journal appends entries of changed items to a file.
+// Entries are written before items are changed, so that a crash never leaves a changed item out of the journal.
+// It is safe for concurrent use by segments of a scan.
+type journal struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	w    *bufio.Writer
+}
+
+// openJournal creates the journal. With resume, entries are appended to the existing journal.
+// The journal of a completed run is rotated, so an existing journal is left by an interrupted run,
+// and it isn't overwritten without resume, since it may be needed to resume or roll back the run.
+func openJournal(path string, resume bool) (*journal, error) {
+	flag := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if resume {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flag, 0644)
+	if os.IsExist(err) {
+		return nil, errors.Errorf("journal %s of an interrupted run exists. Resume the run, or roll it back or remove it before running again", path)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create journal")
+	}
+	return &journal{path: path, f: f, w: bufio.NewWriter(f)}, nil
+}
+
+// write writes entries, and syncs them to the disk.
+func (j *journal) write(entries []journalEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	// Entries of a call are written together, and synced before other calls
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, e := range entries {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return errors.Wrap(err, "failed to write journal")
+		}
+		j.w.Write(b)
+		j.w.WriteByte('\n')
+	}
+	if err := j.w.Flush(); err != nil {
+		return errors.Wrap(err, "failed to write journal")
+	}
+	return errors.Wrap(j.f.Sync(), "failed to sync journal")
+}
+
+// rotate closes the journal of a completed run, and renames it with the time,
+// e.g. default-rename.journal.jsonl to default-rename.journal.20201017T120000.000Z.jsonl.
+// It returns the new path, which is needed to roll back the run.
+func (j *journal) rotate() (string, error) {
+	if err := j.f.Close(); err != nil {
+		return j.path, errors.Wrap(err, "failed to close journal")
+	}
+	ext := ".jsonl"
+	if !strings.HasSuffix(j.path, ext) {
+		ext = ""
+	}
+	base := strings.TrimSuffix(j.path, ext) + "." + time.Now().UTC().Format("20060102T150405.000Z")
+	path := base + ext
+	// Journals of runs in the same millisecond get numbers, since they must not overwrite each other
+	for n := 1; ; n++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			break
+		}
+		path = fmt.Sprintf("%s-%d%s", base, n, ext)
+	}
+	if err := os.Rename(j.path, path); err != nil {
+		return j.path, errors.Wrap(err, "failed to rotate journal")
+	}
+	return path, nil
+}
+
+func (j *journal) Close() error {
+	return j.f.Close()
+}
+
+// RollbackRename restores items of the target table from a journal written by Rename.
+// Changed attributes are restored with conditional UpdateItem calls, so items which were changed after the rename,
+// or which were not renamed, are failures of the result. Items whose key was changed are moved back to their keys
+// with transactions conditional on the journaled items, so items changed after the rename, or items at the original keys,
+// are failures too.
+// Read is the number of entries, and Written is the number of restored items.
+func RollbackRename(ctx context.Context, cfg *config.DynamoDBRenameConfig, path string, opts *Options) (*Result, error) {
+	result, err := rollback(ctx, cfg.Target, cfg.Throughput, cfg.Retry, path, opts)
+	return result, errors.Wrap(err, "failed to roll back renames")
+}
+
+// rollback restores items of the target table from a journal.
+func rollback(ctx context.Context, target *config.DynamoDBConfig, throughput config.ThroughputConfig, retry config.RetryConfig, path string, opts *Options) (*Result, error) {
+	targetDB, err := opts.connect(target)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to target database")
+	}
+	table, err := describeTable(ctx, targetDB, target.TableName)
+	if err != nil {
+		return nil, err
+	}
+	keys := keyAttributes(table)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open journal")
+	}
+	defer f.Close()
+
+	t := newTracker(opts)
+	u := newUpdater(targetDB, retry, writeLimiter(throughput, table), t)
+
+	var entries []journalEntry
+	restore := func() error {
+		var (
+			updates []*dynamodb.UpdateItemInput
+			moves   []*dynamodb.TransactWriteItemsInput
+		)
+		for _, e := range entries {
+			if e.NewKey != nil {
+				moves = append(moves, moveTransaction(target.TableName, keys, e.Before, e.After))
+				continue
+			}
+
+			// The renamed attributes are replaced with the original attributes
+			renamed := make(map[string]*dynamodb.AttributeValue, len(e.After)+len(e.Key))
+			original := make(map[string]*dynamodb.AttributeValue, len(e.Before)+len(e.Key))
+			for k, v := range e.Key {
+				renamed[k], original[k] = v, v
+			}
+			for k, v := range e.After {
+				renamed[k] = v
+			}
+			for k, v := range e.Before {
+				original[k] = v
+			}
+			updates = append(updates, replaceUpdate(target.TableName, keys, renamed, original))
+		}
+		entries = entries[:0]
+
+		if err := u.updateAll(ctx, updates, t.addWritten); err != nil {
+			return err
+		}
+		return u.transactAll(ctx, moves, keys, t.addWritten)
+	}
+
+	err = readDump(f, config.OutputAttributeValue, func(line int, raw []byte) error {
+		t.addRead(1)
+
+		var e journalEntry
+		if err := json.Unmarshal(raw, &e); err != nil || len(e.Key) == 0 {
+			t.fail(errors.Errorf("invalid journal line %d", line))
+			return nil
+		}
+		entries = append(entries, e)
+		if len(entries) < rollbackChunk {
+			return nil
+		}
+		return restore()
+	})
+	if err == nil {
+		err = restore()
+	}
+	return t.result(), err
+}
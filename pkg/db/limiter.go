@@ -0,0 +1,159 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/config"
+)
+
+// limiter is a token bucket of capacity units per second.
+// The consumed capacity is only known after a request, so a request waits until the bucket isn't empty,
+// and the capacity it returned is taken afterwards. The bucket may go negative, which delays the next requests.
+// The rate is halved when requests are throttled, and grows back to the max by a tenth every second without throttling.
+// A nil limiter doesn't limit.
+type limiter struct {
+	mu     sync.Mutex
+	max    float64
+	rate   float64
+	tokens float64
+	last   time.Time
+	// changed is when the rate was last changed, and throttledAt is when it was last halved
+	changed     time.Time
+	throttledAt time.Time
+}
+
+// newLimiter returns a limiter of rate units per second, or nil if rate is not positive.
+// The bucket holds up to a second of units, and starts full.
+func newLimiter(rate float64) *limiter {
+	if rate <= 0 {
+		return nil
+	}
+	now := time.Now()
+	return &limiter{max: rate, rate: rate, tokens: rate, last: now, changed: now}
+}
+
+// capacityLimiter returns a limiter of the capacity of a table.
+// With percent, the rate is the percentage of provisioned units, capped by max.
+// On-demand tables have no provisioned units, so max is the ceiling of them.
+func capacityLimiter(max, percent float64, provisioned int64, onDemand bool) *limiter {
+	if percent > 0 && !onDemand && provisioned > 0 {
+		rate := float64(provisioned) * percent / 100
+		if max <= 0 || rate < max {
+			max = rate
+		}
+	}
+	return newLimiter(max)
+}
+
+// readLimiter and writeLimiter return limiters of the table for the config.
+func readLimiter(cfg config.ThroughputConfig, table *dynamodb.TableDescription) *limiter {
+	var provisioned int64
+	if table.ProvisionedThroughput != nil && table.ProvisionedThroughput.ReadCapacityUnits != nil {
+		provisioned = *table.ProvisionedThroughput.ReadCapacityUnits
+	}
+	return capacityLimiter(cfg.MaxReadCapacity, cfg.TargetPercent, provisioned, onDemand(table))
+}
+
+func writeLimiter(cfg config.ThroughputConfig, table *dynamodb.TableDescription) *limiter {
+	var provisioned int64
+	if table.ProvisionedThroughput != nil && table.ProvisionedThroughput.WriteCapacityUnits != nil {
+		provisioned = *table.ProvisionedThroughput.WriteCapacityUnits
+	}
+	return capacityLimiter(cfg.MaxWriteCapacity, cfg.TargetPercent, provisioned, onDemand(table))
+}
+
+func onDemand(table *dynamodb.TableDescription) bool {
+	return table.BillingModeSummary != nil && table.BillingModeSummary.BillingMode != nil &&
+		*table.BillingModeSummary.BillingMode == dynamodb.BillingModePayPerRequest
+}
+
+// refill adds tokens for the time since the last refill. It must be called with mu held.
+func (l *limiter) refill() {
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+}
+
+// wait blocks until the bucket has tokens, or ctx is done.
+func (l *limiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	for {
+		l.mu.Lock()
+		l.refill()
+		if l.tokens > 0 {
+			l.mu.Unlock()
+			return nil
+		}
+		d := time.Duration(-l.tokens/l.rate*float64(time.Second)) + time.Millisecond
+		l.mu.Unlock()
+
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// take removes consumed units from the bucket.
+func (l *limiter) take(units float64) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill()
+	l.tokens -= units
+
+	if l.rate < l.max && time.Since(l.changed) >= time.Second {
+		l.rate += l.max / 10
+		if l.rate > l.max {
+			l.rate = l.max
+		}
+		l.changed = time.Now()
+	}
+}
+
+// throttled halves the rate down to a twentieth of the max, and empties the bucket.
+// Requests throttled at the same time halve it once, since it is halved at most once a second.
+func (l *limiter) throttled() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill()
+	if l.tokens > 0 {
+		l.tokens = 0
+	}
+	if time.Since(l.throttledAt) < time.Second {
+		return
+	}
+	l.rate /= 2
+	if min := l.max / 20; l.rate < min {
+		l.rate = min
+	}
+	l.changed = time.Now()
+	l.throttledAt = l.changed
+}
+
+// consumedUnits returns the sum of capacity units of the table and its indexes.
+func consumedUnits(ccs ...*dynamodb.ConsumedCapacity) float64 {
+	var units float64
+	for _, cc := range ccs {
+		if cc != nil && cc.CapacityUnits != nil {
+			units += *cc.CapacityUnits
+		}
+	}
+	return units
+}
@@ -0,0 +1,203 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/config"
+)
+
+func TestLimiterRefill(t *testing.T) {
+	l := newLimiter(100)
+	if l.tokens != 100 {
+		t.Fatalf("newLimiter(100) has %v tokens, want a full bucket", l.tokens)
+	}
+
+	l.take(150)
+	if l.tokens > -49 {
+		t.Fatalf("take(150) left %v tokens, want about -50", l.tokens)
+	}
+
+	// A quarter of a second refills a quarter of the rate
+	l.last = l.last.Add(-250 * time.Millisecond)
+	l.refill()
+	if l.tokens < -26 || l.tokens > -24 {
+		t.Errorf("refill() after 250ms = %v tokens, want about -25", l.tokens)
+	}
+
+	// The bucket holds a second of units at most
+	l.last = l.last.Add(-time.Hour)
+	l.refill()
+	if l.tokens != 100 {
+		t.Errorf("refill() after an hour = %v tokens, want 100", l.tokens)
+	}
+}
+
+func TestLimiterWait(t *testing.T) {
+	l := newLimiter(1000)
+	start := time.Now()
+	if err := l.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("wait() with a full bucket took %v", d)
+	}
+
+	// 20 units over the bucket take 20ms to refill
+	l.take(1020)
+	start = time.Now()
+	if err := l.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("wait() with -20 tokens took %v, want 20ms at least", d)
+	}
+}
+
+func TestLimiterWaitCanceled(t *testing.T) {
+	l := newLimiter(1)
+	l.take(100)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("wait() error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestLimiterNil(t *testing.T) {
+	l := newLimiter(0)
+	if l != nil {
+		t.Fatalf("newLimiter(0) = %v, want nil", l)
+	}
+	l.take(100)
+	if err := l.wait(context.Background()); err != nil {
+		t.Errorf("wait() of nil limiter error = %v", err)
+	}
+}
+
+func TestConsumedUnits(t *testing.T) {
+	got := consumedUnits(
+		&dynamodb.ConsumedCapacity{CapacityUnits: aws.Float64(1.5)},
+		nil,
+		&dynamodb.ConsumedCapacity{},
+		&dynamodb.ConsumedCapacity{CapacityUnits: aws.Float64(2)},
+	)
+	if got != 3.5 {
+		t.Errorf("consumedUnits() = %v, want 3.5", got)
+	}
+}
+
+func TestLimiterThrottled(t *testing.T) {
+	l := newLimiter(100)
+	l.throttled()
+	if l.rate != 50 || l.tokens != 0 {
+		t.Fatalf("throttled() left rate %v and %v tokens, want 50 and 0", l.rate, l.tokens)
+	}
+
+	// Requests throttled at the same time halve the rate once
+	l.throttled()
+	if l.rate != 50 {
+		t.Errorf("throttled() twice in a second = rate %v, want 50", l.rate)
+	}
+
+	// The rate is halved down to a twentieth of the max
+	for i := 0; i < 10; i++ {
+		l.throttledAt = l.throttledAt.Add(-time.Second)
+		l.throttled()
+	}
+	if l.rate != 5 {
+		t.Errorf("throttled() 10 times = rate %v, want 5", l.rate)
+	}
+
+	// Without throttling, it grows back by a tenth of the max every second
+	l.changed = l.changed.Add(-time.Second)
+	l.take(0)
+	if l.rate != 15 {
+		t.Errorf("take() a second after = rate %v, want 15", l.rate)
+	}
+	l.take(0)
+	if l.rate != 15 {
+		t.Errorf("take() in the same second = rate %v, want 15", l.rate)
+	}
+	for i := 0; i < 10; i++ {
+		l.changed = l.changed.Add(-time.Second)
+		l.take(0)
+	}
+	if l.rate != 100 {
+		t.Errorf("take() after 10 seconds = rate %v, want the max 100", l.rate)
+	}
+}
+
+func TestCapacityLimiter(t *testing.T) {
+	tests := []struct {
+		name        string
+		max         float64
+		percent     float64
+		provisioned int64
+		onDemand    bool
+		want        float64
+	}{
+		{"none", 0, 0, 100, false, 0},
+		{"max", 30, 0, 100, false, 30},
+		{"percent", 0, 40, 100, false, 40},
+		{"percent under max", 50, 40, 100, false, 40},
+		{"max under percent", 20, 40, 100, false, 20},
+		{"on demand", 20, 40, 0, true, 20},
+		{"on demand without max", 0, 40, 0, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := capacityLimiter(tt.max, tt.percent, tt.provisioned, tt.onDemand)
+			var got float64
+			if l != nil {
+				got = l.max
+			}
+			if got != tt.want {
+				t.Errorf("capacityLimiter() = %v units, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// throttlingClient fails the first BatchWriteItem calls with throttling.
+type throttlingClient struct {
+	Client
+	throttles int
+}
+
+func (c *throttlingClient) BatchWriteItemWithContext(ctx aws.Context, input *dynamodb.BatchWriteItemInput, opts ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+	if c.throttles > 0 {
+		c.throttles--
+		return nil, awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "throttled", nil)
+	}
+	units := float64(len(input.RequestItems["items"]))
+	return &dynamodb.BatchWriteItemOutput{
+		ConsumedCapacity: []*dynamodb.ConsumedCapacity{{CapacityUnits: aws.Float64(units)}},
+	}, nil
+}
+
+func TestBatchWriterThrottled(t *testing.T) {
+	l := newLimiter(1000)
+	w := newBatchWriter(&throttlingClient{throttles: 1}, "items", config.RetryConfig{BaseDelay: time.Microsecond, MaxDelay: time.Millisecond}, l, newTracker(nil))
+
+	wrs := []*dynamodb.WriteRequest{{PutRequest: &dynamodb.PutRequest{Item: map[string]*dynamodb.AttributeValue{"pk": {S: aws.String("a")}}}}}
+	if err := w.batchWrite(context.Background(), wrs); err != nil {
+		t.Fatalf("batchWrite() error = %v", err)
+	}
+	if l.rate != 500 {
+		t.Errorf("batchWrite() throttled once = rate %v, want 500", l.rate)
+	}
+	// The bucket is emptied by the throttling, and the unit of the retry is taken
+	if l.tokens > 0 {
+		t.Errorf("batchWrite() left %v tokens, want a negative bucket", l.tokens)
+	}
+	if r := w.t.result(); r.Retries != 1 || r.Throttles != 1 {
+		t.Errorf("batchWrite() retried %d times with %d throttles, want 1 and 1", r.Retries, r.Throttles)
+	}
+}
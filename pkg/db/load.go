@@ -0,0 +1,302 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/config"
+	"github.com/daangn/dynamoutil/pkg/util"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// loadPageSize is the number of items written by a single goroutine.
+const loadPageSize = 2500
+
+// loadConcurrency limits the number of pages written at the same time.
+const loadConcurrency = 8
+
+// Load imports items from a file produced by Dump into the table.
+// This performs BatchWriteItems to the dynamodb table.
+// Compressed files and manifests of split dumps are read like readDumpFile.
+// Lines which can't be restored to items are rejected as failures of the result.
+func Load(ctx context.Context, cfg *config.DynamoDBLoadConfig, opts *Options) (*Result, error) {
+	cfg = loadDefaults(cfg)
+
+	targetDB, err := opts.connect(&cfg.DynamoDB)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to target database")
+	}
+
+	if _, err := describeTable(ctx, targetDB, cfg.DynamoDB.TableName); err != nil {
+		return nil, err
+	}
+
+	t := newTracker(opts)
+	bw := newBatchWriter(targetDB, cfg.DynamoDB.TableName, cfg.Retry, nil, t)
+	wg := sync.WaitGroup{}
+	sem := make(chan struct{}, loadConcurrency)
+	var (
+		mu       sync.Mutex
+		writeErr error
+	)
+	failed := func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		return writeErr
+	}
+
+	write := func(page []map[string]*dynamodb.AttributeValue) {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			var wrs []*dynamodb.WriteRequest
+			for _, item := range page {
+				wrs = append(wrs, &dynamodb.WriteRequest{
+					PutRequest: &dynamodb.PutRequest{
+						Item: item,
+					},
+				})
+			}
+			if err := bw.writeChunks(ctx, wrs, t.addWritten); err != nil {
+				mu.Lock()
+				if writeErr == nil {
+					writeErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	unmarshalOpts := cfg.Types.UnmarshalOptions()
+	var page []map[string]*dynamodb.AttributeValue
+	err = readDumpFile(cfg.FileName, cfg.Input, func(line int, raw []byte) error {
+		t.addRead(1)
+
+		item, err := loadItem(raw, cfg.Input, unmarshalOpts)
+		if err != nil {
+			t.fail(errors.Wrapf(err, "rejected line %d", line))
+			return nil
+		}
+
+		page = append(page, item)
+		if len(page) == loadPageSize {
+			write(page)
+			page = nil
+		}
+		return failed()
+	})
+	if err == nil && len(page) > 0 {
+		write(page)
+	}
+	wg.Wait()
+	if err != nil {
+		return t.result(), errors.Wrap(err, "failed to load items")
+	}
+	if err := failed(); err != nil {
+		return t.result(), errors.Wrap(err, "failed to load items")
+	}
+	return t.result(), nil
+}
+
+// loadDefaults returns a copy of the config with the default file and input.
+func loadDefaults(cfg *config.DynamoDBLoadConfig) *config.DynamoDBLoadConfig {
+	c := *cfg
+	if c.FileName == "" {
+		c.FileName = c.DynamoDB.TableName
+	}
+	if c.Input == "" {
+		c.Input = config.DefaultOutput
+	}
+	return &c
+}
+
+// manifestSuffix is the suffix of manifests written by Dump for split files.
+const manifestSuffix = "-manifest.json"
+
+// Magic bytes of compressed files
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// readDumpFile calls fn with every raw item of a dump file like readDump.
+// gzip and zstd files are detected by their magic bytes, and a manifest named <filename>-manifest.json
+// is read as its parts in order. Parts must match the size and the checksum of the manifest.
+func readDumpFile(path string, output config.Output, fn func(line int, raw []byte) error) error {
+	if !strings.HasSuffix(path, manifestSuffix) {
+		return readPart(path, output, fn, nil)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to open manifest")
+	}
+	var m manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return errors.Wrap(err, "invalid manifest")
+	}
+	if m.Output != output {
+		return errors.Errorf("manifest lists %s files, but input is %s", m.Output, output)
+	}
+
+	lines := 0
+	for _, part := range m.Parts {
+		part := part
+		offset := lines
+		err := readPart(filepath.Join(filepath.Dir(path), part.File), output, func(line int, raw []byte) error {
+			lines = offset + line
+			return fn(lines, raw)
+		}, &part)
+		if err != nil {
+			return errors.Wrapf(err, "part %s", part.File)
+		}
+	}
+	return nil
+}
+
+// readPart calls fn with every raw item of a file, which may be compressed.
+// With a manifest entry, the file must match its size and checksum.
+func readPart(path string, output config.Output, fn func(line int, raw []byte) error, entry *manifestPart) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to open file")
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	size := &countingWriter{w: h}
+	br := bufio.NewReader(io.TeeReader(file, size))
+
+	var r io.Reader = br
+	magic, _ := br.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return errors.Wrap(err, "invalid gzip file")
+		}
+		defer gr.Close()
+		r = gr
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return errors.Wrap(err, "invalid zstd file")
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	if err := readDump(r, output, fn); err != nil || entry == nil {
+		return err
+	}
+
+	// Bytes after the last item are checked too
+	if _, err := io.Copy(ioutil.Discard, br); err != nil {
+		return errors.Wrap(err, "failed to read file")
+	}
+	if size.n != entry.Size || hex.EncodeToString(h.Sum(nil)) != entry.SHA256 {
+		return errors.New("file doesn't match the size and the checksum of the manifest")
+	}
+	return nil
+}
+
+// readDump calls fn with every raw item of a file written with the given output.
+// line is the line number for newline-delimited files, and the index of the item for JSON arrays.
+// The first error returned by fn stops reading.
+func readDump(r io.Reader, output config.Output, fn func(line int, raw []byte) error) error {
+	switch output {
+	case config.OutputJSON:
+		dec := json.NewDecoder(bufio.NewReader(r))
+		t, err := dec.Token()
+		if err != nil {
+			return errors.Wrap(err, "failed to read the beginning of JSON array")
+		}
+		if d, ok := t.(json.Delim); !ok || d != '[' {
+			return errors.Errorf("expected the beginning of JSON array but got %v", t)
+		}
+
+		for i := 1; dec.More(); i++ {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return errors.Wrapf(err, "failed to read item %d", i)
+			}
+			if err := fn(i, raw); err != nil {
+				return err
+			}
+		}
+		return nil
+	case config.OutputJSONRaw, config.OutputDynamoDBJSON, config.OutputAttributeValue:
+		br := bufio.NewReader(r)
+		for i := 1; ; i++ {
+			line, err := br.ReadBytes('\n')
+			if len(bytes.TrimSpace(line)) > 0 {
+				if err := fn(i, line); err != nil {
+					return err
+				}
+			}
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return errors.Wrapf(err, "failed to read line %d", i)
+			}
+		}
+	default:
+		return errors.Errorf("unsupported input format: %s", output)
+	}
+}
+
+// loadItem converts an item of the input to dynamodb item.
+// Typed items are restored as they are, and flattened items are restored with opts.
+func loadItem(raw []byte, input config.Output, opts *util.UnmarshalOptions) (map[string]*dynamodb.AttributeValue, error) {
+	if input.Typed() {
+		var item map[string]*dynamodb.AttributeValue
+		if input == config.OutputDynamoDBJSON {
+			var wrapped struct {
+				Item map[string]*dynamodb.AttributeValue
+			}
+			if err := json.Unmarshal(raw, &wrapped); err != nil {
+				return nil, errors.Wrap(err, "invalid json")
+			}
+			item = wrapped.Item
+		} else if err := json.Unmarshal(raw, &item); err != nil {
+			return nil, errors.Wrap(err, "invalid json")
+		}
+
+		if len(item) == 0 {
+			return nil, errors.Errorf("item must be a non-empty object. item=%s", raw)
+		}
+		return item, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, errors.Wrap(err, "invalid json")
+	}
+
+	if m, ok := v.(map[string]interface{}); !ok || len(m) == 0 {
+		return nil, errors.Errorf("item must be a non-empty object. item=%s", raw)
+	}
+	return util.UnmarshalDynamo(v, opts)
+}
@@ -0,0 +1,88 @@
+package db_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/daangn/dynamoutil/pkg/config"
+	"github.com/daangn/dynamoutil/pkg/db"
+)
+
+func TestLoadDump(t *testing.T) {
+	tests := []struct {
+		compression     config.Compression
+		maxItemsPerFile int
+		file            string
+	}{
+		{config.CompressionNone, 0, "items.jsonl"},
+		{config.CompressionGzip, 0, "items.jsonl.gz"},
+		{config.CompressionZstd, 0, "items.jsonl.zst"},
+		{config.CompressionNone, 7, "items-manifest.json"},
+		{config.CompressionGzip, 7, "items-manifest.json"},
+		{config.CompressionZstd, 7, "items-manifest.json"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%d", tt.compression, tt.maxItemsPerFile), func(t *testing.T) {
+			chdirTemp(t)
+			items := newItems(20)
+			d := newTable("origin", items)
+			d.AddTable("target", "pk", "")
+
+			_, err := db.Dump(context.Background(), &config.DynamoDBDumpConfig{
+				DynamoDB:        config.DynamoDBConfig{TableName: "origin"},
+				FileName:        "items.jsonl",
+				Output:          config.OutputAttributeValue,
+				Compression:     tt.compression,
+				MaxItemsPerFile: tt.maxItemsPerFile,
+			}, &db.Options{Connect: d.Connect})
+			if err != nil {
+				t.Fatalf("Dump() error = %v", err)
+			}
+
+			result, err := db.Load(context.Background(), &config.DynamoDBLoadConfig{
+				DynamoDB: config.DynamoDBConfig{TableName: "target"},
+				FileName: tt.file,
+				Input:    config.OutputAttributeValue,
+			}, &db.Options{Connect: d.Connect})
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if result.Read != 20 || result.Written != 20 || result.Failed != 0 {
+				t.Errorf("Load() read %d, written %d, failed %d", result.Read, result.Written, result.Failed)
+			}
+			assertItems(t, d, "target", items)
+		})
+	}
+}
+
+func TestLoadManifestChecksum(t *testing.T) {
+	chdirTemp(t)
+	d := newTable("origin", newItems(10))
+	d.AddTable("target", "pk", "")
+
+	_, err := db.Dump(context.Background(), &config.DynamoDBDumpConfig{
+		DynamoDB:        config.DynamoDBConfig{TableName: "origin"},
+		FileName:        "items.jsonl",
+		MaxItemsPerFile: 5,
+	}, &db.Options{Connect: d.Connect})
+	if err != nil {
+		t.Fatalf("Dump() error = %v", err)
+	}
+
+	f, err := os.OpenFile("items-00002.jsonl", os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("{\"pk\": \"extra\"}\n")
+	f.Close()
+
+	_, err = db.Load(context.Background(), &config.DynamoDBLoadConfig{
+		DynamoDB: config.DynamoDBConfig{TableName: "target"},
+		FileName: "items-manifest.json",
+	}, &db.Options{Connect: d.Connect})
+	if err == nil {
+		t.Error("Load() of a changed part succeeded")
+	}
+}
@@ -0,0 +1,148 @@
+package db
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/daangn/dynamoutil/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// Errors returned by commands. They are wrapped with details, so use errors.Is.
+var (
+	// ErrTableNotFound is returned when a table doesn't exist.
+	ErrTableNotFound = errors.New("table does not exist")
+	// ErrTargetNotFound is returned by Copy when the target table doesn't exist without CreateTarget.
+	ErrTargetNotFound = fmt.Errorf("target %w", ErrTableNotFound)
+	// ErrResumeUnsupported is returned when the output can't be resumed from a checkpoint.
+	ErrResumeUnsupported = errors.New("output can't be resumed")
+	// ErrCheckpointMismatch is returned when a checkpoint was written by another command or table.
+	ErrCheckpointMismatch = errors.New("checkpoint doesn't match")
+	// ErrMaxAttempts is returned when a write is still throttled or unprocessed after MaxAttempts of the retry config.
+	ErrMaxAttempts = errors.New("max attempts exceeded")
+	// ErrItemChanged is a failure of an item whose conditional update failed, since it was changed after it was read.
+	ErrItemChanged = errors.New("item was changed after it was read")
+	// ErrKeyExists is a failure of an item which can't be moved to a new key, since an item with the key exists.
+	ErrKeyExists = errors.New("an item with the key exists")
+)
+
+// maxFailures is the number of failures kept in Result.
+const maxFailures = 100
+
+// Options are options of commands which are not in the config file.
+type Options struct {
+	// Progress is notified of processed items. It may be nil.
+	Progress Progress
+	// Connect returns a client of the table config.
+	// Default connects to DynamoDB with the region, endpoint and credentials of the config.
+	Connect func(cfg *config.DynamoDBConfig) (Client, error)
+}
+
+func (o *Options) connect(cfg *config.DynamoDBConfig) (Client, error) {
+	if o != nil && o.Connect != nil {
+		return o.Connect(cfg)
+	}
+	return new(cfg)
+}
+
+// Progress is notified of items processed by a command.
+// It is called from multiple goroutines, so it must be safe for concurrent use.
+type Progress interface {
+	// Read is called with the number of items read from a table or a file.
+	Read(n int)
+	// Written is called with the number of items written to a table or a file.
+	Written(n int)
+	// Failed is called with an item which can't be processed.
+	// The command goes on with the next items.
+	Failed(err error)
+}
+
+// Result is the result of a command.
+// With a resumed checkpoint, counts include items of the previous runs.
+type Result struct {
+	Read    int64
+	Written int64
+	Failed  int64
+	// Failures are errors of the first 100 failed items.
+	Failures []error
+	// Retries is the number of backed off BatchWriteItem, BatchGetItem and UpdateItem calls,
+	// and Throttles is the number of them which were throttled.
+	Retries   int64
+	Throttles int64
+	Duration  time.Duration
+}
+
+// tracker counts processed items for Result, and notifies Progress of them.
+type tracker struct {
+	progress Progress
+	start    time.Time
+
+	read    int64
+	written int64
+	failed  int64
+
+	retries   int64
+	throttles int64
+
+	mu       sync.Mutex
+	failures []error
+}
+
+func newTracker(opts *Options) *tracker {
+	t := &tracker{start: time.Now()}
+	if opts != nil {
+		t.progress = opts.Progress
+	}
+	return t
+}
+
+func (t *tracker) addRead(n int) {
+	atomic.AddInt64(&t.read, int64(n))
+	if t.progress != nil {
+		t.progress.Read(n)
+	}
+}
+
+func (t *tracker) addWritten(n int) {
+	atomic.AddInt64(&t.written, int64(n))
+	if t.progress != nil {
+		t.progress.Written(n)
+	}
+}
+
+func (t *tracker) fail(err error) {
+	atomic.AddInt64(&t.failed, 1)
+	t.mu.Lock()
+	if len(t.failures) < maxFailures {
+		t.failures = append(t.failures, err)
+	}
+	t.mu.Unlock()
+
+	if t.progress != nil {
+		t.progress.Failed(err)
+	}
+}
+
+func (t *tracker) addRetry(throttled bool) {
+	atomic.AddInt64(&t.retries, 1)
+	if throttled {
+		atomic.AddInt64(&t.throttles, 1)
+	}
+}
+
+func (t *tracker) result() *Result {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return &Result{
+		Read:      atomic.LoadInt64(&t.read),
+		Written:   atomic.LoadInt64(&t.written),
+		Failed:    atomic.LoadInt64(&t.failed),
+		Failures:  append([]error{}, t.failures...),
+		Retries:   atomic.LoadInt64(&t.retries),
+		Throttles: atomic.LoadInt64(&t.throttles),
+		Duration:  time.Since(t.start),
+	}
+}
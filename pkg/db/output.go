@@ -0,0 +1,364 @@
+package db
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/config"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// manifest lists part files of a dump.
+type manifest struct {
+	Table       string             `json:"table"`
+	Output      config.Output      `json:"output"`
+	Compression config.Compression `json:"compression,omitempty"`
+	Items       int                `json:"items"`
+	Parts       []manifestPart     `json:"parts"`
+}
+
+// manifestPart represents a part file in manifest.
+type manifestPart struct {
+	File   string `json:"file"`
+	Items  int    `json:"items"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// outputState is the position of the dump output saved in a checkpoint.
+type outputState struct {
+	Output      config.Output      `json:"output"`
+	Compression config.Compression `json:"compression,omitempty"`
+	// Columns are the columns of csv and tsv outputs
+	Columns []string `json:"columns,omitempty"`
+	// Parts are finished part files
+	Parts []manifestPart `json:"parts,omitempty"`
+	// File is the file being written, and Offset and Items are its size and items at the checkpoint
+	File   string `json:"file,omitempty"`
+	Offset int64  `json:"offset"`
+	Items  int    `json:"items"`
+}
+
+// resumableWriter is an itemWriter which can be resumed from a checkpoint.
+type resumableWriter interface {
+	itemWriter
+	// Sync completes written items on disk, and fills the position of the output.
+	// It returns false if items can't be synced yet.
+	Sync(state *outputState) (bool, error)
+}
+
+// partOpener returns an itemWriter of a part file.
+// resume is nil for a new file, and has the number of items for a file being appended.
+type partOpener func(w io.Writer, resume *outputState) (itemWriter, error)
+
+// newOutputWriter returns a writer of the dump output.
+// Without MaxFileSize and MaxItemsPerFile, items are written to a single file.
+// Otherwise, items are written to part files and a manifest.
+// Parquet output is always split by RowGroupSize unless other limits are given.
+// With resume, files are truncated to the checkpoint and appended.
+func newOutputWriter(cfg *config.DynamoDBDumpConfig, newPart partOpener, resume *outputState) (resumableWriter, error) {
+	switch cfg.Compression {
+	case config.CompressionNone, config.CompressionGzip, config.CompressionZstd:
+	default:
+		return nil, errors.Errorf("unsupported compression: %s", cfg.Compression)
+	}
+
+	maxItems := cfg.MaxItemsPerFile
+	if cfg.Output == config.OutputParquet && maxItems == 0 && cfg.MaxFileSize == 0 {
+		maxItems = parquetRowGroupSize(&cfg.Parquet)
+	}
+
+	if maxItems == 0 && cfg.MaxFileSize == 0 {
+		name := cfg.FileName
+		if cfg.Output != config.OutputParquet && !strings.HasSuffix(name, cfg.Compression.Ext()) {
+			name += cfg.Compression.Ext()
+		}
+		if resume != nil && resume.File == name {
+			return openPartFile(name, cfg, newPart, resume)
+		}
+		return openPartFile(name, cfg, newPart, nil)
+	}
+
+	base := strings.TrimSuffix(cfg.FileName, cfg.Compression.Ext())
+	base = strings.TrimSuffix(base, cfg.Output.Ext())
+	w := &rollingWriter{
+		cfg:      cfg,
+		base:     base,
+		maxItems: maxItems,
+		maxBytes: cfg.MaxFileSize,
+		newPart:  newPart,
+		manifest: &manifest{
+			Table:       cfg.DynamoDB.TableName,
+			Output:      cfg.Output,
+			Compression: cfg.Compression,
+		},
+	}
+	if resume != nil {
+		w.manifest.Parts = append(w.manifest.Parts, resume.Parts...)
+		for _, part := range resume.Parts {
+			w.manifest.Items += part.Items
+		}
+		if resume.File != "" {
+			part, err := openPartFile(resume.File, cfg, newPart, resume)
+			if err != nil {
+				return nil, err
+			}
+			w.current = part
+		}
+	}
+	return w, nil
+}
+
+// partExt returns an extension of part files.
+func partExt(cfg *config.DynamoDBDumpConfig) string {
+	if cfg.Output == config.OutputParquet {
+		return cfg.Output.Ext()
+	}
+	return cfg.Output.Ext() + cfg.Compression.Ext()
+}
+
+// rollingWriter writes items to part files of maxItems items or maxBytes bytes.
+// Parts are named <filename>-00001<ext>, and listed in <filename>-manifest.json.
+type rollingWriter struct {
+	cfg      *config.DynamoDBDumpConfig
+	base     string
+	maxItems int
+	maxBytes int64
+	newPart  partOpener
+	manifest *manifest
+
+	current *partFile
+}
+
+func (w *rollingWriter) Write(item map[string]*dynamodb.AttributeValue) error {
+	if w.current == nil || w.full() {
+		if err := w.closePart(); err != nil {
+			return err
+		}
+
+		name := fmt.Sprintf("%s-%05d%s", w.base, len(w.manifest.Parts)+1, partExt(w.cfg))
+		part, err := openPartFile(name, w.cfg, w.newPart, nil)
+		if err != nil {
+			return err
+		}
+		w.current = part
+	}
+	return w.current.Write(item)
+}
+
+func (w *rollingWriter) full() bool {
+	return (w.maxItems > 0 && w.current.items >= w.maxItems) ||
+		(w.maxBytes > 0 && w.current.size.n >= w.maxBytes)
+}
+
+func (w *rollingWriter) Sync(state *outputState) (bool, error) {
+	state.Parts = append([]manifestPart{}, w.manifest.Parts...)
+	if w.current == nil {
+		return true, nil
+	}
+	return w.current.Sync(state)
+}
+
+func (w *rollingWriter) Close() error {
+	if err := w.closePart(); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(w.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(w.base+"-manifest.json", b, 0644)
+}
+
+func (w *rollingWriter) closePart() error {
+	if w.current == nil {
+		return nil
+	}
+	if err := w.current.Close(); err != nil {
+		return err
+	}
+
+	w.manifest.Parts = append(w.manifest.Parts, w.current.entry)
+	w.manifest.Items += w.current.entry.Items
+	w.current = nil
+	return nil
+}
+
+// partFile writes items to a file through compression.
+type partFile struct {
+	name  string
+	cfg   *config.DynamoDBDumpConfig
+	file  *os.File
+	buf   *bufio.Writer
+	size  *countingWriter
+	comp  compressor
+	w     itemWriter
+	items int
+	entry manifestPart
+}
+
+// compressor is a compression writer which can start a new stream.
+// gzip members and zstd frames can be concatenated, so a file is appended with a new stream.
+type compressor interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// openPartFile creates a part file, or appends to the part file from resume.
+func openPartFile(name string, cfg *config.DynamoDBDumpConfig, newPart partOpener, resume *outputState) (*partFile, error) {
+	var (
+		file *os.File
+		err  error
+	)
+	if resume == nil {
+		file, err = os.Create(name)
+	} else {
+		file, err = appendFile(name, resume.Offset)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create file")
+	}
+
+	p := &partFile{name: name, cfg: cfg, file: file}
+	p.buf = bufio.NewWriter(file)
+	p.size = &countingWriter{w: p.buf}
+	if resume != nil {
+		p.size.n = resume.Offset
+		p.items = resume.Items
+	}
+
+	var w io.Writer = p.size
+	if cfg.Output != config.OutputParquet {
+		switch cfg.Compression {
+		case config.CompressionGzip:
+			p.comp = gzip.NewWriter(p.size)
+		case config.CompressionZstd:
+			p.comp, err = zstd.NewWriter(p.size)
+			if err != nil {
+				file.Close()
+				return nil, err
+			}
+		}
+		if p.comp != nil {
+			w = p.comp
+		}
+	}
+
+	p.w, err = newPart(w, resume)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// appendFile opens the file truncated to offset, dropping items written after the checkpoint.
+func appendFile(name string, offset int64) (*os.File, error) {
+	file, err := os.OpenFile(name, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Truncate(offset); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func (p *partFile) Write(item map[string]*dynamodb.AttributeValue) error {
+	if err := p.w.Write(item); err != nil {
+		return err
+	}
+	p.items++
+	return nil
+}
+
+// Sync flushes written items to the file, ending the compression stream.
+// Parquet files can't be appended, so they are never synced.
+func (p *partFile) Sync(state *outputState) (bool, error) {
+	if p.cfg.Output == config.OutputParquet {
+		return false, nil
+	}
+
+	if f, ok := p.w.(interface{ Flush() error }); ok {
+		if err := f.Flush(); err != nil {
+			return false, err
+		}
+	}
+	if p.comp != nil {
+		if err := p.comp.Close(); err != nil {
+			return false, err
+		}
+		p.comp.Reset(p.size)
+	}
+	if err := p.buf.Flush(); err != nil {
+		return false, err
+	}
+
+	state.File = p.name
+	state.Offset = p.size.n
+	state.Items = p.items
+	return true, nil
+}
+
+// Close closes the file, and fills the manifest entry with its checksum.
+func (p *partFile) Close() error {
+	defer p.file.Close()
+
+	if err := p.w.Close(); err != nil {
+		return err
+	}
+	if p.comp != nil {
+		if err := p.comp.Close(); err != nil {
+			return err
+		}
+	}
+	if err := p.buf.Flush(); err != nil {
+		return err
+	}
+	if _, err := p.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	size, err := io.Copy(h, p.file)
+	if err != nil {
+		return errors.Wrap(err, "failed to compute checksum")
+	}
+
+	p.entry = manifestPart{
+		File:   filepath.Base(p.name),
+		Items:  p.items,
+		Size:   size,
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+	}
+	return nil
+}
+
+// countingWriter counts bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
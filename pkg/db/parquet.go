@@ -0,0 +1,374 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/config"
+	"github.com/pkg/errors"
+	"github.com/xitongsys/parquet-go/common"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// Number and map rules for parquet output
+const (
+	parquetNumberDouble  = "double"
+	parquetNumberInt64   = "int64"
+	parquetNumberDecimal = "decimal"
+
+	parquetMapStruct = "struct"
+	parquetMapJSON   = "json"
+
+	defaultRowGroupSize     = 100000
+	defaultDecimalPrecision = 18
+)
+
+// Kinds of parquet fields inferred from attributes
+const (
+	kindString    = "string"
+	kindNumber    = "number"
+	kindBool      = "bool"
+	kindStringSet = "stringSet"
+	kindNumberSet = "numberSet"
+	kindJSON      = "json"
+	kindStruct    = "struct"
+)
+
+// parquetField is a field of parquet schema inferred from attributes.
+// Attributes which have different types over items fall back to kindJSON.
+type parquetField struct {
+	kind   string
+	fields map[string]*parquetField
+	names  []string
+	// vars maps variable names of fields to their attributes
+	vars map[string]string
+}
+
+func newParquetStruct() *parquetField {
+	return &parquetField{kind: kindStruct, fields: make(map[string]*parquetField), vars: make(map[string]string)}
+}
+
+// invalidName returns true if the attribute can't be a field of the struct. Tags can't have , or =,
+// and parquet-go matches fields by variable names, so they must be unique.
+func (f *parquetField) invalidName(name string) bool {
+	if strings.ContainsAny(name, ",=") {
+		return true
+	}
+	other, ok := f.vars[common.StringToVariableName(name)]
+	return ok && other != name
+}
+
+// add merges attributes of a map to the struct field.
+// Attributes are added in sorted order, so that the same attribute of names with the same variable name is a field.
+func (f *parquetField) add(m map[string]*dynamodb.AttributeValue, cfg *config.ParquetConfig) {
+	for _, name := range attributeNames(m) {
+		av := m[name]
+		kind := parquetKind(av, cfg)
+		if kind == "" {
+			continue
+		}
+
+		child, ok := f.fields[name]
+		if !ok && f.invalidName(name) {
+			// Items with the attribute are failures of record
+			continue
+		}
+		if !ok {
+			f.vars[common.StringToVariableName(name)] = name
+			child = &parquetField{kind: kind}
+			if kind == kindStruct {
+				child = newParquetStruct()
+			}
+			f.fields[name] = child
+			f.names = append(f.names, name)
+		} else if child.kind != kind {
+			child.kind = kindJSON
+			child.fields = nil
+		}
+
+		if child.kind == kindStruct {
+			child.add(av.M, cfg)
+		}
+	}
+}
+
+// kindOf returns the kind of the field, and a struct without fields is written as JSON.
+func (f *parquetField) kindOf() string {
+	if f.kind == kindStruct && len(f.fields) == 0 {
+		return kindJSON
+	}
+	return f.kind
+}
+
+// parquetKind returns a kind of the attribute, or an empty string for NULL.
+func parquetKind(av *dynamodb.AttributeValue, cfg *config.ParquetConfig) string {
+	switch {
+	case av == nil, av.NULL != nil:
+		return ""
+	case av.S != nil, av.B != nil:
+		return kindString
+	case av.N != nil:
+		return kindNumber
+	case av.BOOL != nil:
+		return kindBool
+	case av.SS != nil, av.BS != nil:
+		return kindStringSet
+	case av.NS != nil:
+		return kindNumberSet
+	case av.M != nil && cfg.Map == parquetMapStruct:
+		return kindStruct
+	default:
+		return kindJSON
+	}
+}
+
+// schema returns JSON schema of parquet-go.
+func (f *parquetField) schema(cfg *config.ParquetConfig) (string, error) {
+	root := map[string]interface{}{
+		"Tag":    "name=parquet_go_root, repetitiontype=REQUIRED",
+		"Fields": f.schemaFields(cfg),
+	}
+	b, err := json.Marshal(root)
+	return string(b), err
+}
+
+func (f *parquetField) schemaFields(cfg *config.ParquetConfig) []interface{} {
+	names := append([]string{}, f.names...)
+	sort.Strings(names)
+
+	var fields []interface{}
+	for _, name := range names {
+		child := f.fields[name]
+		switch child.kindOf() {
+		case kindStruct:
+			fields = append(fields, map[string]interface{}{
+				"Tag":    fmt.Sprintf("name=%s, repetitiontype=OPTIONAL", name),
+				"Fields": child.schemaFields(cfg),
+			})
+		case kindStringSet:
+			fields = append(fields, map[string]interface{}{
+				"Tag": fmt.Sprintf("name=%s, type=UTF8, repetitiontype=REPEATED", name),
+			})
+		case kindNumberSet:
+			fields = append(fields, map[string]interface{}{
+				"Tag": fmt.Sprintf("name=%s, %s, repetitiontype=REPEATED", name, numberTag(cfg)),
+			})
+		case kindNumber:
+			fields = append(fields, map[string]interface{}{
+				"Tag": fmt.Sprintf("name=%s, %s, repetitiontype=OPTIONAL", name, numberTag(cfg)),
+			})
+		case kindBool:
+			fields = append(fields, map[string]interface{}{
+				"Tag": fmt.Sprintf("name=%s, type=BOOLEAN, repetitiontype=OPTIONAL", name),
+			})
+		default:
+			fields = append(fields, map[string]interface{}{
+				"Tag": fmt.Sprintf("name=%s, type=UTF8, repetitiontype=OPTIONAL", name),
+			})
+		}
+	}
+	return fields
+}
+
+func numberTag(cfg *config.ParquetConfig) string {
+	switch cfg.Number {
+	case parquetNumberInt64:
+		return "type=INT64"
+	case parquetNumberDecimal:
+		precision := cfg.DecimalPrecision
+		if precision < 1 {
+			precision = defaultDecimalPrecision
+		}
+		return fmt.Sprintf("type=DECIMAL, basetype=INT64, scale=%d, precision=%d", cfg.DecimalScale, precision)
+	default:
+		return "type=DOUBLE"
+	}
+}
+
+// record returns a JSON record of the item for parquet-go.
+// It returns an error if the item has an attribute which isn't in the schema, or doesn't match its field.
+func (f *parquetField) record(m map[string]*dynamodb.AttributeValue, cfg *config.DynamoDBDumpConfig) (map[string]interface{}, error) {
+	record := make(map[string]interface{}, len(m))
+	for name, av := range m {
+		if av.NULL != nil {
+			continue
+		}
+		child, ok := f.fields[name]
+		if !ok && f.invalidName(name) {
+			return nil, errors.Errorf("attribute %s can't be a parquet field", name)
+		}
+		if !ok {
+			return nil, errors.Errorf("attribute %s is not in the inferred schema", name)
+		}
+
+		v, err := child.value(av, cfg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid attribute %s", name)
+		}
+		if v != nil {
+			record[name] = v
+		}
+	}
+	return record, nil
+}
+
+// value returns a value of the attribute for the field. It returns an error if the attribute doesn't have the kind
+// of the field, which happens when the schema is inferred from a sample, so that the item isn't written without it.
+func (f *parquetField) value(av *dynamodb.AttributeValue, cfg *config.DynamoDBDumpConfig) (interface{}, error) {
+	kind := f.kindOf()
+	if kind != kindJSON && kind != kindString && parquetKind(av, &cfg.Parquet) != kind {
+		return nil, errors.Errorf("value doesn't match the inferred %s field", kind)
+	}
+
+	switch kind {
+	case kindStruct:
+		return f.record(av.M, cfg)
+	case kindNumber:
+		return parquetNumber(*av.N, &cfg.Parquet)
+	case kindBool:
+		return *av.BOOL, nil
+	case kindNumberSet:
+		l := make([]interface{}, 0, len(av.NS))
+		for _, n := range av.NS {
+			v, err := parquetNumber(*n, &cfg.Parquet)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, v)
+		}
+		return l, nil
+	case kindStringSet:
+		v, err := flattenValue(av, cfg.MarshalOptions())
+		if err != nil {
+			return nil, err
+		}
+		l, _ := v.([]interface{})
+		return l, nil
+	default:
+		switch {
+		case av.S != nil:
+			return *av.S, nil
+		case av.N != nil:
+			return *av.N, nil
+		case av.BOOL != nil:
+			return strconv.FormatBool(*av.BOOL), nil
+		}
+
+		v, err := flattenValue(av, cfg.MarshalOptions())
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		b, err := json.Marshal(v)
+		return string(b), err
+	}
+}
+
+// parquetNumber converts N to a value of the number rule.
+func parquetNumber(n string, cfg *config.ParquetConfig) (interface{}, error) {
+	switch cfg.Number {
+	case parquetNumberInt64:
+		f, _, err := big.ParseFloat(n, 10, 256, big.ToNearestEven)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid number: %s", n)
+		}
+		i, acc := f.Int64()
+		if acc != big.Exact {
+			return nil, errors.Errorf("%s is not an int64", n)
+		}
+		return json.Number(strconv.FormatInt(i, 10)), nil
+	case parquetNumberDecimal:
+		return json.Number(n), nil
+	default:
+		f, err := strconv.ParseFloat(n, 64)
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+	}
+}
+
+// parquetWriter writes items to a parquet file, flushing a row group every RowGroupSize items.
+type parquetWriter struct {
+	w    *writer.JSONWriter
+	root *parquetField
+	cfg  *config.DynamoDBDumpConfig
+	rows int
+}
+
+func newParquetWriter(w io.Writer, cfg *config.DynamoDBDumpConfig, root *parquetField) (*parquetWriter, error) {
+	schema, err := root.schema(&cfg.Parquet)
+	if err != nil {
+		return nil, err
+	}
+
+	pw, err := writer.NewJSONWriterFromWriter(schema, w, 4)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create parquet writer")
+	}
+	// Row groups are flushed by the number of items instead of the size
+	pw.RowGroupSize = 1 << 62
+	switch cfg.Compression {
+	case config.CompressionGzip:
+		pw.CompressionType = parquet.CompressionCodec_GZIP
+	case config.CompressionZstd:
+		pw.CompressionType = parquet.CompressionCodec_ZSTD
+	}
+
+	return &parquetWriter{w: pw, root: root, cfg: cfg}, nil
+}
+
+func (w *parquetWriter) Write(item map[string]*dynamodb.AttributeValue) error {
+	record, err := w.root.record(item, w.cfg)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := w.w.Write(string(b)); err != nil {
+		return err
+	}
+
+	w.rows++
+	if w.rows%parquetRowGroupSize(&w.cfg.Parquet) == 0 {
+		return w.w.Flush(true)
+	}
+	return nil
+}
+
+func (w *parquetWriter) Close() error {
+	return w.w.WriteStop()
+}
+
+func parquetRowGroupSize(cfg *config.ParquetConfig) int {
+	if cfg.RowGroupSize < 1 {
+		return defaultRowGroupSize
+	}
+	return cfg.RowGroupSize
+}
+
+func validateParquetConfig(cfg *config.ParquetConfig) error {
+	switch cfg.Number {
+	case "", parquetNumberDouble, parquetNumberInt64, parquetNumberDecimal:
+	default:
+		return errors.Errorf("unsupported number rule: %s", cfg.Number)
+	}
+	switch cfg.Map {
+	case "", parquetMapJSON, parquetMapStruct:
+	default:
+		return errors.Errorf("unsupported map rule: %s", cfg.Map)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/config"
+)
+
+func TestParquetRecordMismatch(t *testing.T) {
+	cfg := &config.DynamoDBDumpConfig{Parquet: config.ParquetConfig{Map: parquetMapStruct}}
+	root := newParquetStruct()
+	root.add(map[string]*dynamodb.AttributeValue{
+		"pk":    {S: aws.String("1")},
+		"count": {N: aws.String("1")},
+		"ok":    {BOOL: aws.Bool(true)},
+		"tags":  {SS: aws.StringSlice([]string{"a"})},
+		"profile": {M: map[string]*dynamodb.AttributeValue{
+			"age": {N: aws.String("3")},
+		}},
+		// a,b can't be a field, and a46b has the variable name of a.b, so items with them fail instead of dropping them
+		"a,b":  {S: aws.String("x")},
+		"a.b":  {S: aws.String("x")},
+		"a46b": {S: aws.String("x")},
+	}, &cfg.Parquet)
+
+	tests := []struct {
+		name    string
+		item    map[string]*dynamodb.AttributeValue
+		wantErr bool
+	}{
+		{"matching", map[string]*dynamodb.AttributeValue{"pk": {S: aws.String("2")}, "count": {N: aws.String("2")}}, false},
+		{"NULL", map[string]*dynamodb.AttributeValue{"pk": {S: aws.String("2")}, "count": {NULL: aws.Bool(true)}}, false},
+		{"number to string field", map[string]*dynamodb.AttributeValue{"pk": {N: aws.String("2")}}, false},
+		{"string to number field", map[string]*dynamodb.AttributeValue{"count": {S: aws.String("2")}}, true},
+		{"string to bool field", map[string]*dynamodb.AttributeValue{"ok": {S: aws.String("true")}}, true},
+		{"list to set field", map[string]*dynamodb.AttributeValue{"tags": {L: []*dynamodb.AttributeValue{}}}, true},
+		{"string to struct field", map[string]*dynamodb.AttributeValue{"profile": {S: aws.String("{}")}}, true},
+		{"nested mismatch", map[string]*dynamodb.AttributeValue{"profile": {M: map[string]*dynamodb.AttributeValue{
+			"age": {S: aws.String("three")},
+		}}}, true},
+		{"unknown attribute", map[string]*dynamodb.AttributeValue{"extra": {S: aws.String("x")}}, true},
+		{"invalid name", map[string]*dynamodb.AttributeValue{"a,b": {S: aws.String("x")}}, true},
+		{"first of variable name", map[string]*dynamodb.AttributeValue{"a.b": {S: aws.String("x")}}, false},
+		{"duplicated variable name", map[string]*dynamodb.AttributeValue{"a46b": {S: aws.String("x")}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := root.record(tt.item, cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("record() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package db
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/pkg/errors"
+)
+
+// pathElement is an element of a document path. It is a map key,
+// or a list index if name is empty. A wildcard matches every index of a list.
+type pathElement struct {
+	name     string
+	index    int
+	wildcard bool
+}
+
+// documentPath is a path to a value of an item, such as profile.address.zip or items[*].price.
+type documentPath []pathElement
+
+// parsePath parses a document path. Elements are separated by dots, and a list index is [N], or [*] for every index.
+// A dot, a bracket or a backslash of a map key is escaped with a backslash, like a\.b.
+func parsePath(s string) (documentPath, error) {
+	var (
+		path    documentPath
+		name    strings.Builder
+		named   bool
+		escaped bool
+	)
+	// flush adds the name before a dot or a bracket
+	flush := func() {
+		if named {
+			path = append(path, pathElement{name: name.String()})
+		}
+		name.Reset()
+		named = false
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case escaped:
+			name.WriteByte(c)
+			named = true
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '.':
+			// A dot follows a name or an index, and is followed by a name
+			if !named && (len(path) == 0 || path[len(path)-1].name != "") || i == len(s)-1 {
+				return nil, errors.Errorf("invalid path %q: empty attribute name", s)
+			}
+			flush()
+		case c == '[':
+			if !named && (len(path) == 0 || s[i-1] == '.') {
+				return nil, errors.Errorf("invalid path %q: index without attribute", s)
+			}
+			flush()
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return nil, errors.Errorf("invalid path %q: unclosed bracket", s)
+			}
+			index := s[i+1 : i+end]
+			if index == "*" {
+				path = append(path, pathElement{wildcard: true})
+			} else {
+				n, err := strconv.Atoi(index)
+				if err != nil || n < 0 {
+					return nil, errors.Errorf("invalid path %q: %s is not an index", s, index)
+				}
+				path = append(path, pathElement{index: n})
+			}
+			i += end
+			if i+1 < len(s) && s[i+1] != '.' && s[i+1] != '[' {
+				return nil, errors.Errorf("invalid path %q: unexpected %q after index", s, s[i+1])
+			}
+		default:
+			if i > 0 && s[i-1] == ']' {
+				return nil, errors.Errorf("invalid path %q: unexpected %q after index", s, c)
+			}
+			name.WriteByte(c)
+			named = true
+		}
+	}
+	if escaped {
+		return nil, errors.Errorf("invalid path %q: trailing backslash", s)
+	}
+	flush()
+	if len(path) == 0 {
+		return nil, errors.Errorf("invalid path %q: empty attribute name", s)
+	}
+	return path, nil
+}
+
+// topLevel returns true if the path is an attribute of the item.
+func (p documentPath) topLevel() bool {
+	return len(p) == 1
+}
+
+// wildcards returns the number of wildcards.
+func (p documentPath) wildcards() int {
+	n := 0
+	for _, e := range p {
+		if e.wildcard {
+			n++
+		}
+	}
+	return n
+}
+
+// expand returns concrete paths of the item which match the path, and the indexes of wildcards of every path.
+func (p documentPath) expand(item map[string]*dynamodb.AttributeValue) ([]documentPath, [][]int) {
+	var (
+		paths   []documentPath
+		indexes [][]int
+	)
+	var walk func(v *dynamodb.AttributeValue, i int, path documentPath, idx []int)
+	walk = func(v *dynamodb.AttributeValue, i int, path documentPath, idx []int) {
+		if v == nil {
+			return
+		}
+		if i == len(p) {
+			paths = append(paths, append(documentPath{}, path...))
+			indexes = append(indexes, append([]int{}, idx...))
+			return
+		}
+
+		e := p[i]
+		switch {
+		case e.name != "":
+			if v.M != nil {
+				walk(v.M[e.name], i+1, append(path, e), idx)
+			}
+		case e.wildcard:
+			for j, elem := range v.L {
+				walk(elem, i+1, append(path, pathElement{index: j}), append(idx, j))
+			}
+		default:
+			if e.index < len(v.L) {
+				walk(v.L[e.index], i+1, append(path, e), idx)
+			}
+		}
+	}
+	walk(&dynamodb.AttributeValue{M: item}, 0, nil, nil)
+	return paths, indexes
+}
+
+// fill returns a concrete path with wildcards replaced by indexes.
+func (p documentPath) fill(indexes []int) documentPath {
+	c := make(documentPath, len(p))
+	n := 0
+	for i, e := range p {
+		if e.wildcard {
+			e = pathElement{index: indexes[n]}
+			n++
+		}
+		c[i] = e
+	}
+	return c
+}
+
+// targets returns concrete paths of the item to write the path to. [*] matches every index of existing lists,
+// and the rest of the path doesn't need to exist.
+func (p documentPath) targets(item map[string]*dynamodb.AttributeValue) []documentPath {
+	last := -1
+	for i, e := range p {
+		if e.wildcard {
+			last = i
+		}
+	}
+	if last < 0 {
+		return []documentPath{p}
+	}
+
+	_, indexes := p[:last+1].expand(item)
+	paths := make([]documentPath, len(indexes))
+	for i, idx := range indexes {
+		paths[i] = p.fill(idx)
+	}
+	return paths
+}
+
+// get returns the value at the concrete path, or nil if it doesn't exist.
+func (p documentPath) get(item map[string]*dynamodb.AttributeValue) *dynamodb.AttributeValue {
+	v := &dynamodb.AttributeValue{M: item}
+	for _, e := range p {
+		switch {
+		case e.name != "" && v.M != nil:
+			v = v.M[e.name]
+		case e.name == "" && e.index < len(v.L):
+			v = v.L[e.index]
+		default:
+			return nil
+		}
+		if v == nil {
+			return nil
+		}
+	}
+	return v
+}
+
+// set sets the value at the concrete path. Missing maps of the path are created,
+// and an index over the length of a list appends the value like UpdateItem.
+func (p documentPath) set(item map[string]*dynamodb.AttributeValue, value *dynamodb.AttributeValue) error {
+	parent := &dynamodb.AttributeValue{M: item}
+	for i, e := range p {
+		last := i == len(p)-1
+		var next *dynamodb.AttributeValue
+		if !last {
+			// A missing parent is a map, or a list if the next element is an index
+			next = &dynamodb.AttributeValue{M: make(map[string]*dynamodb.AttributeValue)}
+			if p[i+1].name == "" {
+				next = &dynamodb.AttributeValue{L: []*dynamodb.AttributeValue{}}
+			}
+		} else {
+			next = value
+		}
+
+		switch {
+		case e.name != "" && parent.M != nil:
+			if cur, ok := parent.M[e.name]; ok && !last {
+				next = cur
+			} else {
+				parent.M[e.name] = next
+			}
+		case e.name == "" && parent.L != nil:
+			if e.index < len(parent.L) {
+				if last {
+					parent.L[e.index] = next
+				} else {
+					next = parent.L[e.index]
+				}
+			} else {
+				parent.L = append(parent.L, next)
+			}
+		default:
+			return errors.Errorf("%s is not a map or a list", p[:i].String())
+		}
+		parent = next
+	}
+	return nil
+}
+
+// remove removes the value at the concrete path. Later elements of a list are shifted.
+func (p documentPath) remove(item map[string]*dynamodb.AttributeValue) {
+	parent := p[:len(p)-1].get(item)
+	if parent == nil {
+		return
+	}
+	e := p[len(p)-1]
+	switch {
+	case e.name != "" && parent.M != nil:
+		delete(parent.M, e.name)
+	case e.name == "" && e.index < len(parent.L):
+		parent.L = append(parent.L[:e.index], parent.L[e.index+1:]...)
+	}
+}
+
+// String returns the path with escaped names.
+func (p documentPath) String() string {
+	var b strings.Builder
+	for i, e := range p {
+		switch {
+		case e.wildcard:
+			b.WriteString("[*]")
+		case e.name == "":
+			b.WriteString("[" + strconv.Itoa(e.index) + "]")
+		default:
+			if i > 0 {
+				b.WriteByte('.')
+			}
+			for _, c := range e.name {
+				if c == '.' || c == '[' || c == ']' || c == '\\' {
+					b.WriteByte('\\')
+				}
+				b.WriteRune(c)
+			}
+		}
+	}
+	return b.String()
+}
+
+// cloneValue copies the value deeply, so that changes of the copy don't change the value.
+func cloneValue(v *dynamodb.AttributeValue) *dynamodb.AttributeValue {
+	if v == nil {
+		return nil
+	}
+	c := *v
+	if v.M != nil {
+		c.M = make(map[string]*dynamodb.AttributeValue, len(v.M))
+		for k, e := range v.M {
+			c.M[k] = cloneValue(e)
+		}
+	}
+	if v.L != nil {
+		c.L = make([]*dynamodb.AttributeValue, len(v.L))
+		for i, e := range v.L {
+			c.L[i] = cloneValue(e)
+		}
+	}
+	return &c
+}
@@ -0,0 +1,178 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// testItem returns an item of DynamoDB JSON.
+func testItem(t *testing.T, s string) map[string]*dynamodb.AttributeValue {
+	t.Helper()
+
+	var item map[string]*dynamodb.AttributeValue
+	if err := json.Unmarshal([]byte(s), &item); err != nil {
+		t.Fatal(err)
+	}
+	return item
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    documentPath
+		wantErr bool
+	}{
+		{path: "a", want: documentPath{{name: "a"}}},
+		{path: "a.b", want: documentPath{{name: "a"}, {name: "b"}}},
+		{path: `a\.b`, want: documentPath{{name: "a.b"}}},
+		{path: `a\[0\]\\`, want: documentPath{{name: `a[0]\`}}},
+		{path: "a[0]", want: documentPath{{name: "a"}, {index: 0}}},
+		{path: "a[*].b", want: documentPath{{name: "a"}, {wildcard: true}, {name: "b"}}},
+		{path: "a[1][2]", want: documentPath{{name: "a"}, {index: 1}, {index: 2}}},
+		{path: "", wantErr: true},
+		{path: ".a", wantErr: true},
+		{path: "a.", wantErr: true},
+		{path: "a..b", wantErr: true},
+		{path: "[0]", wantErr: true},
+		{path: "a.[0]", wantErr: true},
+		{path: "a[", wantErr: true},
+		{path: "a[x]", wantErr: true},
+		{path: "a[-1]", wantErr: true},
+		{path: "a[0]b", wantErr: true},
+		{path: `a\`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := parsePath(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("parsePath() = %v, want %v", got, tt.want)
+			}
+			// String escapes names, so that it is parsed to the same path
+			if again, err := parsePath(got.String()); err != nil || fmt.Sprint(again) != fmt.Sprint(got) {
+				t.Errorf("parsePath(%q) = %v, %v, want %v", got.String(), again, err, got)
+			}
+		})
+	}
+}
+
+func TestPathExpandAndTargets(t *testing.T) {
+	item := testItem(t, `{
+		"items": {"L": [{"M": {"p": {"N": "1"}}}, {"M": {}}, {"M": {"p": {"N": "3"}}}]},
+		"profile": {"M": {"zip": {"S": "123"}}}
+	}`)
+	tests := []struct {
+		path        string
+		wantExpand  string
+		wantIndexes string
+		wantTargets string
+	}{
+		{"items[*].p", "[items[0].p items[2].p]", "[[0] [2]]", "[items[0].p items[1].p items[2].p]"},
+		{"items[1]", "[items[1]]", "[[]]", "[items[1]]"},
+		{"items[3]", "[]", "[]", "[items[3]]"},
+		{"profile.zip", "[profile.zip]", "[[]]", "[profile.zip]"},
+		{"profile.city", "[]", "[]", "[profile.city]"},
+		{"profile[*].zip", "[]", "[]", "[]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			p, err := parsePath(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			paths, indexes := p.expand(item)
+			if got := pathStrings(paths); got != tt.wantExpand {
+				t.Errorf("expand() = %s, want %s", got, tt.wantExpand)
+			}
+			if got := fmt.Sprint(indexes); got != tt.wantIndexes {
+				t.Errorf("expand() indexes = %s, want %s", got, tt.wantIndexes)
+			}
+			if got := pathStrings(p.targets(item)); got != tt.wantTargets {
+				t.Errorf("targets() = %s, want %s", got, tt.wantTargets)
+			}
+		})
+	}
+}
+
+func pathStrings(paths []documentPath) string {
+	s := make([]string, len(paths))
+	for i, p := range paths {
+		s[i] = p.String()
+	}
+	return fmt.Sprint(s)
+}
+
+func TestPathSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{"attribute", "v", `{"l":{"L":[{"S":"a"},{"S":"b"}]},"s":{"S":"x"},"v":{"N":"1"}}`, false},
+		{"missing maps", "m.a.b", `{"l":{"L":[{"S":"a"},{"S":"b"}]},"m":{"M":{"a":{"M":{"b":{"N":"1"}}}}},"s":{"S":"x"}}`, false},
+		{"missing list", "n[3]", `{"l":{"L":[{"S":"a"},{"S":"b"}]},"n":{"L":[{"N":"1"}]},"s":{"S":"x"}}`, false},
+		{"index", "l[1]", `{"l":{"L":[{"S":"a"},{"N":"1"}]},"s":{"S":"x"}}`, false},
+		{"index out of range", "l[5]", `{"l":{"L":[{"S":"a"},{"S":"b"},{"N":"1"}]},"s":{"S":"x"}}`, false},
+		{"map in list", "l[2].a", `{"l":{"L":[{"S":"a"},{"S":"b"},{"M":{"a":{"N":"1"}}}]},"s":{"S":"x"}}`, false},
+		{"under string", "s.a", "", true},
+		{"index of string", "s[0]", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := testItem(t, `{"l":{"L":[{"S":"a"},{"S":"b"}]},"s":{"S":"x"}}`)
+			p, err := parsePath(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = p.set(item, &dynamodb.AttributeValue{N: aws.String("1")})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("set() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !equalValue(&dynamodb.AttributeValue{M: item}, &dynamodb.AttributeValue{M: testItem(t, tt.want)}) {
+				b, _ := json.Marshal(item)
+				t.Errorf("set() = %s, want %s", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathRemove(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"l[0]", `{"l":{"L":[{"S":"b"},{"S":"c"}]},"m":{"M":{"a":{"S":"x"}}}}`},
+		{"l[1]", `{"l":{"L":[{"S":"a"},{"S":"c"}]},"m":{"M":{"a":{"S":"x"}}}}`},
+		{"l[3]", `{"l":{"L":[{"S":"a"},{"S":"b"},{"S":"c"}]},"m":{"M":{"a":{"S":"x"}}}}`},
+		{"m.a", `{"l":{"L":[{"S":"a"},{"S":"b"},{"S":"c"}]},"m":{"M":{}}}`},
+		{"m.b.c", `{"l":{"L":[{"S":"a"},{"S":"b"},{"S":"c"}]},"m":{"M":{"a":{"S":"x"}}}}`},
+		{"m", `{"l":{"L":[{"S":"a"},{"S":"b"},{"S":"c"}]}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			item := testItem(t, `{"l":{"L":[{"S":"a"},{"S":"b"},{"S":"c"}]},"m":{"M":{"a":{"S":"x"}}}}`)
+			p, err := parsePath(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.remove(item)
+			if !equalValue(&dynamodb.AttributeValue{M: item}, &dynamodb.AttributeValue{M: testItem(t, tt.want)}) {
+				b, _ := json.Marshal(item)
+				t.Errorf("remove() = %s, want %s", b, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package db
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// defaultQueryWorkers is the number of partitions queried at the same time
+const defaultQueryWorkers = 4
+
+// reader reads items of a table with Scan, or with Query of every partition key.
+type reader struct {
+	db      Client
+	cfg     config.ScanConfig
+	scan    *dynamodb.ScanInput
+	queries []*dynamodb.QueryInput
+	// partitions are partition keys of queries
+	partitions []interface{}
+}
+
+// newReader returns a reader of the table with the select config, which reads pages of limit items.
+// keys are added to the projection like newExpression.
+func newReader(db Client, table *dynamodb.TableDescription, sel config.SelectConfig, scan config.ScanConfig, limit int64, keys ...string) (*reader, error) {
+	expr, err := newExpression(sel, keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &reader{db: db, cfg: scan}
+	if !sel.Query.Enabled() {
+		r.scan = &dynamodb.ScanInput{
+			TableName: table.TableName,
+			Limit:     aws.Int64(limit),
+		}
+		expr.applyScan(r.scan)
+		return r, nil
+	}
+
+	partitionKey, sortKey, err := indexKeys(table, sel.Index)
+	if err != nil {
+		return nil, err
+	}
+	partitions, err := partitionKeys(sel.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Placeholders of keys are added to the placeholders of the filter and projection
+	names := map[string]*string{"#qpk": aws.String(partitionKey)}
+	for k, v := range expr.names {
+		names[k] = v
+	}
+	cond := "#qpk = :qpk"
+	sortCond, sortValues, err := sortKeyCondition(sel.Query.SortKey)
+	if err != nil {
+		return nil, err
+	}
+	if sortCond != "" {
+		if sortKey == "" {
+			return nil, errors.New("sort key condition is set, but the key schema has no sort key")
+		}
+		names["#qsk"] = aws.String(sortKey)
+		cond += " AND " + sortCond
+	}
+
+	values := make(map[string]*dynamodb.AttributeValue, len(expr.values)+len(sortValues)+1)
+	for k, v := range expr.values {
+		values[k] = v
+	}
+	for i, v := range sortValues {
+		av, err := keyValue(table, sortKey, v)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid sort key condition")
+		}
+		values[fmt.Sprintf(":qsk%d", i)] = av
+	}
+
+	for _, p := range partitions {
+		av, err := keyValue(table, partitionKey, p)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid partition key")
+		}
+
+		in := &dynamodb.QueryInput{
+			TableName:                 table.TableName,
+			IndexName:                 expr.index,
+			KeyConditionExpression:    aws.String(cond),
+			FilterExpression:          expr.filter,
+			ProjectionExpression:      expr.projection,
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":qpk": av},
+			Limit:                     aws.Int64(limit),
+		}
+		for k, v := range values {
+			in.ExpressionAttributeValues[k] = v
+		}
+		r.queries = append(r.queries, in)
+		r.partitions = append(r.partitions, p)
+	}
+	return r, nil
+}
+
+// segments returns the number of segments of the checkpoint.
+// Every partition key is a segment of Query.
+func (r *reader) segments() int {
+	if r.scan == nil {
+		return len(r.queries)
+	}
+	if r.cfg.TotalSegments < 1 {
+		return 1
+	}
+	return r.cfg.TotalSegments
+}
+
+// read reads all pages like parallelScan.
+func (r *reader) read(ctx context.Context, cp *checkpoint, rl *limiter, fn func(segment int, items []map[string]*dynamodb.AttributeValue, lastKey map[string]*dynamodb.AttributeValue) error) error {
+	if r.scan != nil {
+		return parallelScan(ctx, r.db, r.scan, r.cfg, cp, rl, fn)
+	}
+
+	workers := r.cfg.Workers
+	if workers < 1 {
+		workers = defaultQueryWorkers
+	}
+	return parallelPages(ctx, len(r.queries), workers, cp, rl, func(ctx context.Context, segment int, startKey map[string]*dynamodb.AttributeValue, opts ...request.Option) (*readPage, error) {
+		in := *r.queries[segment]
+		if rl != nil {
+			in.ReturnConsumedCapacity = aws.String(dynamodb.ReturnConsumedCapacityTotal)
+		}
+		in.ExclusiveStartKey = startKey
+
+		o, err := r.db.QueryWithContext(ctx, &in, opts...)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to query partition %v", r.partitions[segment])
+		}
+		return &readPage{items: o.Items, lastKey: o.LastEvaluatedKey, consumed: o.ConsumedCapacity}, nil
+	}, fn)
+}
+
+// indexKeys returns names of the partition key and the sort key of the index, or the table without index.
+func indexKeys(table *dynamodb.TableDescription, index string) (string, string, error) {
+	schema := table.KeySchema
+	if index != "" {
+		schema = nil
+		for _, idx := range table.GlobalSecondaryIndexes {
+			if aws.StringValue(idx.IndexName) == index {
+				schema = idx.KeySchema
+			}
+		}
+		for _, idx := range table.LocalSecondaryIndexes {
+			if aws.StringValue(idx.IndexName) == index {
+				schema = idx.KeySchema
+			}
+		}
+		if schema == nil {
+			return "", "", errors.Errorf("%s table has no %s index", aws.StringValue(table.TableName), index)
+		}
+	}
+
+	var partitionKey, sortKey string
+	for _, k := range schema {
+		switch aws.StringValue(k.KeyType) {
+		case dynamodb.KeyTypeHash:
+			partitionKey = aws.StringValue(k.AttributeName)
+		case dynamodb.KeyTypeRange:
+			sortKey = aws.StringValue(k.AttributeName)
+		}
+	}
+	return partitionKey, sortKey, nil
+}
+
+// partitionKeys returns partition key values of the config and the file.
+func partitionKeys(cfg config.QueryConfig) ([]interface{}, error) {
+	keys := append([]interface{}{}, cfg.PartitionKeys...)
+	if cfg.PartitionKeysFile == "" {
+		return keys, nil
+	}
+
+	f, err := os.Open(cfg.PartitionKeysFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open partition keys file")
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if line := strings.TrimSpace(s.Text()); line != "" {
+			keys = append(keys, line)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read partition keys file")
+	}
+	if len(keys) == 0 {
+		return nil, errors.Errorf("%s has no partition keys", cfg.PartitionKeysFile)
+	}
+	return keys, nil
+}
+
+// sortKeyCondition returns the expression of the condition with placeholders :qsk0 and :qsk1 of values.
+func sortKeyCondition(c config.SortKeyCondition) (string, []interface{}, error) {
+	var (
+		cond   string
+		values []interface{}
+		n      int
+	)
+	set := func(expr string, vs ...interface{}) {
+		cond, values = expr, vs
+		n++
+	}
+	if c.Eq != nil {
+		set("#qsk = :qsk0", c.Eq)
+	}
+	if c.Lt != nil {
+		set("#qsk < :qsk0", c.Lt)
+	}
+	if c.Lte != nil {
+		set("#qsk <= :qsk0", c.Lte)
+	}
+	if c.Gt != nil {
+		set("#qsk > :qsk0", c.Gt)
+	}
+	if c.Gte != nil {
+		set("#qsk >= :qsk0", c.Gte)
+	}
+	if c.Between != nil {
+		if len(c.Between) != 2 {
+			return "", nil, errors.Errorf("between needs 2 values, but got %d", len(c.Between))
+		}
+		set("#qsk BETWEEN :qsk0 AND :qsk1", c.Between...)
+	}
+	if c.BeginsWith != nil {
+		set("begins_with(#qsk, :qsk0)", c.BeginsWith)
+	}
+
+	if n > 1 {
+		return "", nil, errors.New("only one sort key condition can be set")
+	}
+	return cond, values, nil
+}
+
+// keyValue converts v to the type of the key attribute in AttributeDefinitions.
+// B is decoded from base64.
+func keyValue(table *dynamodb.TableDescription, attr string, v interface{}) (*dynamodb.AttributeValue, error) {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case int, int64, uint64, float64:
+		s = fmt.Sprint(v)
+	default:
+		return nil, errors.Errorf("%v is not a key value of %s", v, attr)
+	}
+
+	for _, def := range table.AttributeDefinitions {
+		if aws.StringValue(def.AttributeName) != attr {
+			continue
+		}
+
+		switch aws.StringValue(def.AttributeType) {
+		case dynamodb.ScalarAttributeTypeN:
+			if _, err := strconv.ParseFloat(s, 64); err != nil {
+				return nil, errors.Errorf("%s is not a number of %s", s, attr)
+			}
+			return &dynamodb.AttributeValue{N: aws.String(s)}, nil
+		case dynamodb.ScalarAttributeTypeB:
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s is not base64 of %s", s, attr)
+			}
+			return &dynamodb.AttributeValue{B: b}, nil
+		default:
+			return &dynamodb.AttributeValue{S: aws.String(s)}, nil
+		}
+	}
+	return nil, errors.Errorf("%s is not defined in attributes of %s table", attr, aws.StringValue(table.TableName))
+}
@@ -0,0 +1,216 @@
+package db_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/config"
+	"github.com/daangn/dynamoutil/pkg/db"
+	"github.com/daangn/dynamoutil/pkg/db/dbtest"
+)
+
+// newEvents returns a DB with events of users u0 to u2 at 1 to 6, whose sort key is a number,
+// and a by-kind index of kind a or b.
+func newEvents(t *testing.T) *dbtest.DB {
+	t.Helper()
+
+	d := dbtest.New()
+	key := func(name, keyType string) *dynamodb.KeySchemaElement {
+		return &dynamodb.KeySchemaElement{AttributeName: aws.String(name), KeyType: aws.String(keyType)}
+	}
+	_, err := d.CreateTableWithContext(context.Background(), &dynamodb.CreateTableInput{
+		TableName: aws.String("events"),
+		KeySchema: []*dynamodb.KeySchemaElement{key("user", dynamodb.KeyTypeHash), key("at", dynamodb.KeyTypeRange)},
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String("user"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+			{AttributeName: aws.String("at"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeN)},
+			{AttributeName: aws.String("kind"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+		},
+		GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{{
+			IndexName: aws.String("by-kind"),
+			KeySchema: []*dynamodb.KeySchemaElement{key("kind", dynamodb.KeyTypeHash), key("at", dynamodb.KeyTypeRange)},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for u := 0; u < 3; u++ {
+		for at := 1; at <= 6; at++ {
+			d.Put("events", map[string]*dynamodb.AttributeValue{
+				"user": {S: aws.String(fmt.Sprintf("u%d", u))},
+				"at":   {N: aws.String(fmt.Sprint(at))},
+				"kind": {S: aws.String(string(rune('a' + at%2)))},
+			})
+		}
+	}
+	return d
+}
+
+// dumpEvents dumps events with the select config, and returns user/at of dumped items in order.
+func dumpEvents(t *testing.T, d *dbtest.DB, sel config.SelectConfig) ([]string, error) {
+	t.Helper()
+
+	_, err := db.Dump(context.Background(), &config.DynamoDBDumpConfig{
+		DynamoDB: config.DynamoDBConfig{TableName: "events"},
+		FileName: "events.jsonl",
+		Output:   config.OutputAttributeValue,
+		Select:   sel,
+	}, &db.Options{Connect: d.Connect})
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile("events.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		if line == "" {
+			continue
+		}
+		var item map[string]*dynamodb.AttributeValue
+		if err := json.Unmarshal([]byte(line), &item); err != nil {
+			t.Fatalf("invalid line %q: %v", line, err)
+		}
+		keys = append(keys, *item["user"].S+"/"+*item["at"].N)
+	}
+	// Partitions are queried at the same time
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func TestQueryKeyRanges(t *testing.T) {
+	tests := []struct {
+		name string
+		sel  config.SelectConfig
+		want string
+	}{
+		{
+			name: "partitions",
+			sel:  config.SelectConfig{Query: config.QueryConfig{PartitionKeys: []interface{}{"u0", "u2", "none"}}},
+			want: "u0/1 u0/2 u0/3 u0/4 u0/5 u0/6 u2/1 u2/2 u2/3 u2/4 u2/5 u2/6",
+		},
+		{
+			name: "between",
+			sel: config.SelectConfig{Query: config.QueryConfig{
+				PartitionKeys: []interface{}{"u1"},
+				SortKey:       config.SortKeyCondition{Between: []interface{}{2, "4"}},
+			}},
+			want: "u1/2 u1/3 u1/4",
+		},
+		{
+			name: "gt",
+			sel: config.SelectConfig{Query: config.QueryConfig{
+				PartitionKeys: []interface{}{"u1"},
+				SortKey:       config.SortKeyCondition{Gt: 4},
+			}},
+			want: "u1/5 u1/6",
+		},
+		{
+			name: "lte",
+			sel: config.SelectConfig{Query: config.QueryConfig{
+				PartitionKeys: []interface{}{"u0", "u1"},
+				SortKey:       config.SortKeyCondition{Lte: 1.5},
+			}},
+			want: "u0/1 u1/1",
+		},
+		{
+			name: "index",
+			sel: config.SelectConfig{
+				Index: "by-kind",
+				Query: config.QueryConfig{
+					PartitionKeys: []interface{}{"a"},
+					SortKey:       config.SortKeyCondition{Gte: 5},
+				},
+			},
+			want: "u0/6 u1/6 u2/6",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chdirTemp(t)
+			d := newEvents(t)
+			d.MaxPageSize = 2
+
+			got, err := dumpEvents(t, d, tt.sel)
+			if err != nil {
+				t.Fatalf("Dump() error = %v", err)
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("Dump() = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryPartitionKeysFile(t *testing.T) {
+	chdirTemp(t)
+	d := newEvents(t)
+	if err := ioutil.WriteFile("users.txt", []byte("u1\n\n  u2 \n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := dumpEvents(t, d, config.SelectConfig{Query: config.QueryConfig{
+		PartitionKeysFile: "users.txt",
+		SortKey:           config.SortKeyCondition{Eq: 3},
+	}})
+	if err != nil {
+		t.Fatalf("Dump() error = %v", err)
+	}
+	if strings.Join(got, " ") != "u1/3 u2/3" {
+		t.Errorf("Dump() = %v, want [u1/3 u2/3]", got)
+	}
+}
+
+func TestQueryInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		sel  config.SelectConfig
+	}{
+		{
+			name: "two conditions",
+			sel: config.SelectConfig{Query: config.QueryConfig{
+				PartitionKeys: []interface{}{"u0"},
+				SortKey:       config.SortKeyCondition{Gt: 1, Lt: 3},
+			}},
+		},
+		{
+			name: "between with a value",
+			sel: config.SelectConfig{Query: config.QueryConfig{
+				PartitionKeys: []interface{}{"u0"},
+				SortKey:       config.SortKeyCondition{Between: []interface{}{1}},
+			}},
+		},
+		{
+			name: "sort key of a string",
+			sel: config.SelectConfig{Query: config.QueryConfig{
+				PartitionKeys: []interface{}{"u0"},
+				SortKey:       config.SortKeyCondition{Eq: "first"},
+			}},
+		},
+		{
+			name: "unknown index",
+			sel:  config.SelectConfig{Index: "none", Query: config.QueryConfig{PartitionKeys: []interface{}{"u0"}}},
+		},
+		{
+			name: "missing file",
+			sel:  config.SelectConfig{Query: config.QueryConfig{PartitionKeysFile: "none.txt"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chdirTemp(t)
+			if _, err := dumpEvents(t, newEvents(t), tt.sel); err == nil {
+				t.Error("Dump() error = nil")
+			}
+		})
+	}
+}
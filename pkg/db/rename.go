@@ -1,236 +1,441 @@
 package db
 
 import (
-	"bufio"
+	"context"
 	"fmt"
-	"os"
+	"sort"
 	"strings"
-	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/daangn/dynamoutil/pkg/config"
-	"github.com/rs/zerolog/log"
-
-	. "github.com/logrusorgru/aurora"
+	"github.com/pkg/errors"
 )
 
+// RenameResult is the result of Rename.
+type RenameResult struct {
+	Result
+	// Renames has metrics of each rename in the order of the config.
+	Renames []RenameMetrics
+	// Journal is the file of the journal. The journal of a completed run is rotated to a file with the time.
+	Journal string
+}
+
 // RenameMetrics holds metrics for each rename operation.
-type renameMetrics struct {
-	Count    int32
+type RenameMetrics struct {
+	Before   string
+	After    string
+	Count    int64
 	Duration time.Duration
 }
 
-// Rename reads before-after pairs from the YAML file and renames attributes in a DynamoDB table.
-func Rename(cfg *config.DynamoDBRenameConfig) error {
-	fmt.Println(
-		Bold(Green("Target")),
-		BrightBlue("region: ").String()+cfg.Target.Region+" ",
-		BrightBlue("table: ").String()+cfg.Target.TableName+" ",
-		BrightBlue("endpoint: ").String()+cfg.Target.Endpoint,
-	)
-
-	fmt.Printf("\nAre you sure about renaming attributes in %s? [Y/n] ", BrightBlue(cfg.Target.TableName))
-	yn, _ := bufio.NewReader(os.Stdin).ReadString('\n')
-	if strings.Trim(yn, "\n") != "Y" {
-		fmt.Println(Green("Goodbye👋"))
-		return nil
+// Rename renames attributes in a DynamoDB table with before-after pairs of the config.
+// Read is the number of scanned items, and Written is the number of renamed items.
+// Items are renamed with conditional UpdateItem calls, so other attributes written concurrently are kept,
+// and items changed after they were read are failures of the result.
+// Nested paths are renamed by replacing the changed attributes, on the condition that they have the read values.
+// Keys and changed attributes of renamed items are written to the journal before the items are renamed,
+// so that they can be restored by RollbackRename.
+// Key attributes of the table and indexes can't be renamed, or be renamed to, so keys of items are never changed.
+func Rename(ctx context.Context, cfg *config.DynamoDBRenameConfig, opts *Options) (*RenameResult, error) {
+	cfg = renameDefaults(cfg)
+	targetDB, err := opts.connect(cfg.Target)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to target database")
 	}
-	fmt.Print("\n")
 
-	targetDB, err := new(cfg.Target)
+	table, err := describeTable(ctx, targetDB, cfg.Target.TableName)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to connect to target database. Check .dynamoutil.yaml or target database status")
+		return nil, err
 	}
 
-	oo, err := targetDB.DescribeTable(&dynamodb.DescribeTableInput{
-		TableName: &cfg.Target.TableName,
-	})
+	renames, err := parseRenames(table, cfg.Rename)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Target table does not exist")
+		return nil, err
 	}
+	keys := keyAttributes(table)
 
-	// Extract the primary key attributes from the KeySchema
-	var partitionKey, sortKey string
-	for _, keyElement := range oo.Table.KeySchema {
-		if *keyElement.KeyType == "HASH" {
-			partitionKey = *keyElement.AttributeName
-		} else if *keyElement.KeyType == "RANGE" {
-			sortKey = *keyElement.AttributeName
-		}
+	cp, err := openCheckpoint(cfg.Scan.Checkpoint, cfg.Target.TableName, cfg.Scan.TotalSegments, cfg.Scan.Resume)
+	if err != nil {
+		return nil, err
 	}
-	fmt.Printf("Partition Key: %s, Sort Key: %s\n", partitionKey, sortKey)
-
-	fmt.Println()
-	var lastKey map[string]*dynamodb.AttributeValue
-
-	// Metrics for each rename operation
-	metrics := make(map[string]*renameMetrics)
-	for _, rename := range cfg.Rename {
-		metrics[fmt.Sprintf("%s -> %s", rename.Before, rename.After)] = &renameMetrics{}
-	}
-
-	wg := sync.WaitGroup{}
-	now := time.Now()
-	var ops int32
-	var readOps int32
-
-	// Display progress
-	go func() {
-		for {
-			time.Sleep(time.Millisecond * 100)
-			fmt.Printf("\r\tTime spent: %.1f. Read %d items, Processed %d items. %.2f items/s", time.Since(now).Seconds(), Blue(readOps), Blue(ops), Blue(float64(ops)/(time.Since(now).Seconds())))
-		}
-	}()
-
-	// Scan and process items
-	for {
-		o, err := targetDB.Scan(&dynamodb.ScanInput{
-			TableName:         &cfg.Target.TableName,
-			Limit:             aws.Int64(2500),
-			ExclusiveStartKey: lastKey,
-		})
-		if err != nil {
-			log.Fatal().Err(err).Msg("Failed to scan target dynamodb")
-		}
-		atomic.AddInt32(&readOps, int32(len(o.Items)))
-
-		var (
-			deleteChunks [][]*dynamodb.WriteRequest
-			putChunks    [][]*dynamodb.WriteRequest
-			deleteWrs    []*dynamodb.WriteRequest
-			putWrs       []*dynamodb.WriteRequest
-		)
-
-		for _, item := range o.Items {
-			// Track time spent on each rename operation
-			itemStart := time.Now()
-			renamed := false
-			// Rename attributes based on the configuration
-			for _, rename := range cfg.Rename {
-				if val, exists := item[rename.Before]; exists {
-					item[rename.After] = val
-					delete(item, rename.Before)
-					metricsKey := fmt.Sprintf("%s -> %s", rename.Before, rename.After)
-					atomic.AddInt32(&metrics[metricsKey].Count, 1)
-					metrics[metricsKey].Duration += time.Since(itemStart)
-					renamed = true
-				}
-			}
 
-			if !renamed {
-				continue
+	j, err := openJournal(cfg.Journal, cfg.Scan.Resume)
+	if err != nil {
+		return nil, err
+	}
+	defer j.Close()
+
+	rw := &rewrite{
+		table:      table,
+		scan:       cfg.Scan,
+		throughput: cfg.Throughput,
+		retry:      cfg.Retry,
+		journal:    j,
+		ops:        len(renames),
+		change: func(item map[string]*dynamodb.AttributeValue, applied func(i int)) (map[string]*dynamodb.AttributeValue, error) {
+			return applyRenames(item, renames, applied)
+		},
+		update: func(item, renamed map[string]*dynamodb.AttributeValue) *dynamodb.UpdateItemInput {
+			if sources := renameSources(item, renames); sources != nil {
+				return renameUpdate(cfg.Target.TableName, keys, item, sources)
 			}
+			return replaceUpdate(cfg.Target.TableName, keys, item, renamed)
+		},
+	}
+	t := newTracker(opts)
+	ops, err := rw.run(ctx, targetDB, cp, t)
 
-			atomic.AddInt32(&ops, 1)
+	// Metrics for each rename operation
+	metrics := make([]RenameMetrics, len(cfg.Rename))
+	for i, rename := range cfg.Rename {
+		metrics[i] = RenameMetrics{Before: rename.Before, After: rename.After, Count: ops[i].count, Duration: ops[i].duration}
+	}
+	result := &RenameResult{Result: *t.result(), Renames: metrics, Journal: cfg.Journal}
+	if err != nil {
+		return result, errors.Wrap(err, "failed to rename attributes")
+	}
 
-			key := map[string]*dynamodb.AttributeValue{
-				partitionKey: item[partitionKey],
-				sortKey:      item[sortKey],
-			}
+	if err := cp.remove(); err != nil {
+		return result, errors.Wrap(err, "failed to remove checkpoint")
+	}
+	if result.Journal, err = j.rotate(); err != nil {
+		return result, err
+	}
+	return result, nil
+}
 
-			// Prepare DeleteRequest and PutRequest
-			deleteRequest := &dynamodb.DeleteRequest{Key: key}
-			putRequest := &dynamodb.PutRequest{Item: item}
+// renameDefaults returns a copy of the config with the default checkpoint and journal.
+func renameDefaults(cfg *config.DynamoDBRenameConfig) *config.DynamoDBRenameConfig {
+	c := *cfg
+	if c.Scan.Checkpoint == "" {
+		c.Scan.Checkpoint = c.Service + "-rename.checkpoint.json"
+	}
+	if c.Journal == "" {
+		c.Journal = c.Service + "-rename.journal.jsonl"
+	}
+	return &c
+}
 
-			// Add the Delete request to deleteWrs
-			deleteWrs = append(deleteWrs, &dynamodb.WriteRequest{
-				DeleteRequest: deleteRequest,
-			})
+// renamePath is a pair of the config with parsed paths.
+type renamePath struct {
+	before documentPath
+	after  documentPath
+}
 
-			// Add the Put request to putWrs
-			putWrs = append(putWrs, &dynamodb.WriteRequest{
-				PutRequest: putRequest,
-			})
+// parseRenames parses paths of pairs, and returns an error if they can't be applied to the table.
+// before can't be in a key attribute of the table or an index, since items can't lose key attributes of the table,
+// and they would silently drop out of the index. after can't be in a key attribute either,
+// since it would overwrite the key of items, or move them into the index.
+func parseRenames(table *dynamodb.TableDescription, renames []config.RenameAttribute) ([]renamePath, error) {
+	owners := keyOwners(table)
+	paths := make([]renamePath, len(renames))
+	for i, rename := range renames {
+		if rename.Before == "" || rename.After == "" {
+			return nil, errors.Errorf("both before and after are required, but got %q -> %q", rename.Before, rename.After)
+		}
+		before, err := parsePath(rename.Before)
+		if err != nil {
+			return nil, err
+		}
+		after, err := parsePath(rename.After)
+		if err != nil {
+			return nil, err
+		}
+		if before.wildcards() != after.wildcards() {
+			return nil, errors.Errorf("%s -> %s: before and after must have the same number of [*]", rename.Before, rename.After)
+		}
+		if owner, ok := owners[before[0].name]; ok {
+			return nil, errors.Errorf("%s can't be renamed, since it is a key attribute of %s", rename.Before, owner)
+		}
+		if owner, ok := owners[after[0].name]; ok {
+			return nil, errors.Errorf("%s can't be renamed to %s, since it is a key attribute of %s", rename.Before, rename.After, owner)
+		}
+		paths[i] = renamePath{before: before, after: after}
+	}
+	return paths, nil
+}
 
-			// Batch in chunks of 25 requests as DynamoDB limits
-			if len(deleteWrs) >= 25 {
-				deleteChunks = append(deleteChunks, deleteWrs)
-				deleteWrs = []*dynamodb.WriteRequest{}
-			}
-			if len(putWrs) >= 25 {
-				putChunks = append(putChunks, putWrs)
-				putWrs = []*dynamodb.WriteRequest{}
+// keyOwners returns the table or the first index of every key attribute.
+func keyOwners(table *dynamodb.TableDescription) map[string]string {
+	owners := make(map[string]string)
+	add := func(owner string, schema []*dynamodb.KeySchemaElement) {
+		for _, k := range schema {
+			if _, ok := owners[aws.StringValue(k.AttributeName)]; !ok {
+				owners[aws.StringValue(k.AttributeName)] = owner
 			}
+		}
+	}
+	add(aws.StringValue(table.TableName)+" table", table.KeySchema)
+	for _, idx := range table.GlobalSecondaryIndexes {
+		add(aws.StringValue(idx.IndexName)+" index", idx.KeySchema)
+	}
+	for _, idx := range table.LocalSecondaryIndexes {
+		add(aws.StringValue(idx.IndexName)+" index", idx.KeySchema)
+	}
+	return owners
+}
+
+// applyRenames applies renames to a copy of the item in order, and returns the copy.
+// Every value matched by before is moved to after, and [*] of after is the index matched by [*] of before.
+// renamed is called with the index of every applied rename. It returns nil if the item isn't changed.
+func applyRenames(item map[string]*dynamodb.AttributeValue, renames []renamePath, renamed func(i int)) (map[string]*dynamodb.AttributeValue, error) {
+	var c map[string]*dynamodb.AttributeValue
+	for i, rename := range renames {
+		current := c
+		if current == nil {
+			current = item
+		}
+		paths, indexes := rename.before.expand(current)
+		if len(paths) == 0 {
+			continue
+		}
+		if c == nil {
+			c = cloneValue(&dynamodb.AttributeValue{M: item}).M
+		}
 
-			// Record time taken for renaming this item
-			for _, rename := range cfg.Rename {
-				metricsKey := fmt.Sprintf("%s -> %s", rename.Before, rename.After)
-				metrics[metricsKey].Duration += time.Since(itemStart)
+		values := make([]*dynamodb.AttributeValue, len(paths))
+		for j, p := range paths {
+			values[j] = p.get(c)
+		}
+		// Values are removed from the last, so that indexes of lists don't shift
+		for j := len(paths) - 1; j >= 0; j-- {
+			paths[j].remove(c)
+		}
+		for j := range paths {
+			after := rename.after.fill(indexes[j])
+			if err := after.set(c, values[j]); err != nil {
+				return nil, errors.Wrapf(err, "failed to rename %s to %s", paths[j], after)
 			}
 		}
+		renamed(i)
+	}
+
+	// Renames may move values back to their paths
+	if c == nil || equalValue(&dynamodb.AttributeValue{M: item}, &dynamodb.AttributeValue{M: c}) {
+		return nil, nil
+	}
+	return c, nil
+}
 
-		// Add remaining requests to chunks
-		if len(deleteWrs) > 0 {
-			deleteChunks = append(deleteChunks, deleteWrs)
+// renameSources returns the original name of every attribute after renames,
+// if every rename is an attribute of the item, and any attribute is moved. Otherwise it returns nil.
+// e.g. a swap of a to b and b to a only removes b, which renameUpdate can't express without a SET.
+func renameSources(item map[string]*dynamodb.AttributeValue, renames []renamePath) map[string]string {
+	sources := make(map[string]string, len(item))
+	for name := range item {
+		sources[name] = name
+	}
+	for _, rename := range renames {
+		if !rename.before.topLevel() || !rename.after.topLevel() {
+			return nil
+		}
+		before, exists := sources[rename.before[0].name]
+		if !exists || rename.before[0].name == rename.after[0].name {
+			continue
 		}
-		if len(putWrs) > 0 {
-			putChunks = append(putChunks, putWrs)
+		sources[rename.after[0].name] = before
+		delete(sources, rename.before[0].name)
+	}
+	for after, before := range sources {
+		if after != before {
+			return sources
 		}
+	}
+	return nil
+}
 
-		// Process delete requests
-		for _, chunk := range deleteChunks {
-			wg.Add(1)
-			go func(chunk []*dynamodb.WriteRequest) {
-				defer wg.Done()
-				batchWrite(targetDB, map[string][]*dynamodb.WriteRequest{
-					cfg.Target.TableName: chunk,
-				})
-			}(chunk)
+// checkKeyTypes returns an error if a changed attribute is a key attribute of the table or an index,
+// and its value isn't the type of the key in AttributeDefinitions. DynamoDB rejects such items.
+func checkKeyTypes(table *dynamodb.TableDescription, item, renamed map[string]*dynamodb.AttributeValue) error {
+	for _, def := range table.AttributeDefinitions {
+		name := aws.StringValue(def.AttributeName)
+		if equalValue(item[name], renamed[name]) {
+			continue
 		}
 
-		// Wait for all delete requests to complete before proceeding with put requests
-		wg.Wait()
+		v := renamed[name]
+		var valid bool
+		switch aws.StringValue(def.AttributeType) {
+		case dynamodb.ScalarAttributeTypeS:
+			valid = v != nil && v.S != nil && *v.S != ""
+		case dynamodb.ScalarAttributeTypeN:
+			valid = v != nil && v.N != nil
+		case dynamodb.ScalarAttributeTypeB:
+			valid = v != nil && len(v.B) > 0
+		}
+		if !valid {
+			return errors.Errorf("%s can't be changed, since the value is not a key of type %s", name, aws.StringValue(def.AttributeType))
+		}
+	}
+	return nil
+}
 
-		// Process put requests
-		for _, chunk := range putChunks {
-			wg.Add(1)
-			go func(chunk []*dynamodb.WriteRequest) {
-				defer wg.Done()
-				batchWrite(targetDB, map[string][]*dynamodb.WriteRequest{
-					cfg.Target.TableName: chunk,
-				})
-			}(chunk)
+// keyChanged returns true if any key attribute of the item is changed.
+func keyChanged(item, renamed map[string]*dynamodb.AttributeValue, keys []string) bool {
+	for _, k := range keys {
+		if !equalValue(item[k], renamed[k]) {
+			return true
 		}
-		wg.Wait()
+	}
+	return false
+}
 
-		if o.LastEvaluatedKey != nil {
-			lastKey = o.LastEvaluatedKey
-			continue
+// renameUpdate returns an update of the item which sets renamed attributes from their original attributes,
+// and removes attributes which are renamed or overwritten. The update is conditional on the original attributes,
+// so that an item changed by another writer after it was read is not renamed with stale names.
+func renameUpdate(table string, keys []string, item map[string]*dynamodb.AttributeValue, sources map[string]string) *dynamodb.UpdateItemInput {
+	names := make(map[string]*string)
+	placeholders := make(map[string]string)
+	placeholder := func(name string) string {
+		if p, ok := placeholders[name]; ok {
+			return p
 		}
+		p := fmt.Sprintf("#r%d", len(placeholders))
+		placeholders[name] = p
+		names[p] = aws.String(name)
+		return p
+	}
 
-		break
+	var afters, removes, conds []string
+	used := make(map[string]bool)
+	for after, before := range sources {
+		if after != before {
+			afters = append(afters, after)
+			used[before] = true
+		}
+	}
+	sort.Strings(afters)
+	var sets []string
+	for _, after := range afters {
+		sets = append(sets, fmt.Sprintf("%s = %s", placeholder(after), placeholder(sources[after])))
+	}
+
+	befores := make([]string, 0, len(used))
+	for before := range used {
+		befores = append(befores, before)
+	}
+	sort.Strings(befores)
+	for _, before := range befores {
+		conds = append(conds, fmt.Sprintf("attribute_exists(%s)", placeholder(before)))
+	}
+	for _, name := range attributeNames(item) {
+		if _, kept := sources[name]; !kept {
+			removes = append(removes, placeholder(name))
+		}
 	}
-	wg.Wait()
-	since := time.Since(now)
-	time.Sleep(time.Millisecond * 110)
 
-	fmt.Print("\n\n")
-	fmt.Printf("Renamed %d items of %s table.\nExecution Time: %.2f seconds\nAvg: %.2f ops/s\n",
-		Green(ops),
-		BrightBlue(cfg.Target.TableName),
-		Green(since.Seconds()),
-		Green(float64(ops)/since.Seconds()),
-	)
+	var clauses []string
+	if len(sets) > 0 {
+		clauses = append(clauses, "SET "+strings.Join(sets, ", "))
+	}
+	if len(removes) > 0 {
+		clauses = append(clauses, "REMOVE "+strings.Join(removes, ", "))
+	}
+	in := &dynamodb.UpdateItemInput{
+		TableName:                aws.String(table),
+		Key:                      keyOf(item, keys),
+		UpdateExpression:         aws.String(strings.Join(clauses, " ")),
+		ExpressionAttributeNames: names,
+	}
+	if len(conds) > 0 {
+		in.ConditionExpression = aws.String(strings.Join(conds, " AND "))
+	}
+	return in
+}
 
-	// Print metrics for each rename operation
-	fmt.Println("\nDetailed Rename Metrics:")
-	for key, metric := range metrics {
-		if metric.Count == 0 {
-			fmt.Printf("%s: No items changed\n", BrightBlue(key))
+// replaceUpdate returns an update which sets changed attributes of the item to their new values, and removes missing ones.
+// It is used when values are moved within attributes, which can't be expressed with paths of a single update.
+// The update is conditional on the read values of the changed attributes, so that items changed after they were read
+// are failures instead of being overwritten.
+func replaceUpdate(table string, keys []string, item, changed map[string]*dynamodb.AttributeValue) *dynamodb.UpdateItemInput {
+	names := make(map[string]*string)
+	values := make(map[string]*dynamodb.AttributeValue)
+
+	var sets, removes, conds []string
+	attrs := append(attributeNames(item), attributeNames(changed)...)
+	sort.Strings(attrs)
+	for i, name := range attrs {
+		if i > 0 && attrs[i-1] == name || equalValue(item[name], changed[name]) {
 			continue
 		}
 
-		avgTime := metric.Duration.Seconds() / float64(metric.Count)
-		fmt.Printf("%s: %d items changed, Total Time: %.2f seconds, Avg Time per item: %.4f seconds\n",
-			BrightBlue(key),
-			Green(metric.Count),
-			Green(metric.Duration.Seconds()),
-			Green(avgTime),
-		)
+		n := fmt.Sprintf("#r%d", len(names))
+		names[n] = aws.String(name)
+		if v := item[name]; v != nil {
+			o := fmt.Sprintf(":o%d", len(conds))
+			values[o] = v
+			conds = append(conds, fmt.Sprintf("%s = %s", n, o))
+		} else {
+			conds = append(conds, fmt.Sprintf("attribute_not_exists(%s)", n))
+		}
+		if v := changed[name]; v != nil {
+			p := fmt.Sprintf(":v%d", len(sets))
+			values[p] = v
+			sets = append(sets, fmt.Sprintf("%s = %s", n, p))
+		} else {
+			removes = append(removes, n)
+		}
 	}
 
-	return nil
+	var clauses []string
+	if len(sets) > 0 {
+		clauses = append(clauses, "SET "+strings.Join(sets, ", "))
+	}
+	if len(removes) > 0 {
+		clauses = append(clauses, "REMOVE "+strings.Join(removes, ", "))
+	}
+	in := &dynamodb.UpdateItemInput{
+		TableName:                aws.String(table),
+		Key:                      keyOf(item, keys),
+		UpdateExpression:         aws.String(strings.Join(clauses, " ")),
+		ConditionExpression:      aws.String(strings.Join(conds, " AND ")),
+		ExpressionAttributeNames: names,
+	}
+	if len(values) > 0 {
+		in.ExpressionAttributeValues = values
+	}
+	return in
+}
+
+// moveTransaction returns a transaction which puts the item unless an item with its key exists,
+// and deletes current, which has another key, on condition that every attribute of current is still the same.
+// Keys can't be updated, so items are moved to new keys with it. An item at the new key, or an item changed after
+// it was read, cancels the transaction instead of being overwritten.
+func moveTransaction(table string, keys []string, item, current map[string]*dynamodb.AttributeValue) *dynamodb.TransactWriteItemsInput {
+	names := make(map[string]*string)
+	values := make(map[string]*dynamodb.AttributeValue)
+	var conds []string
+	attrs := attributeNames(current)
+	sort.Strings(attrs)
+	for _, name := range attrs {
+		n := fmt.Sprintf("#c%d", len(names))
+		v := fmt.Sprintf(":c%d", len(values))
+		names[n] = aws.String(name)
+		values[v] = current[name]
+		conds = append(conds, fmt.Sprintf("%s = %s", n, v))
+	}
+
+	return &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Put: &dynamodb.Put{
+					TableName:                aws.String(table),
+					Item:                     item,
+					ConditionExpression:      aws.String("attribute_not_exists(#k)"),
+					ExpressionAttributeNames: map[string]*string{"#k": aws.String(keys[0])},
+				},
+			},
+			{
+				Delete: &dynamodb.Delete{
+					TableName:                 aws.String(table),
+					Key:                       keyOf(current, keys),
+					ConditionExpression:       aws.String(strings.Join(conds, " AND ")),
+					ExpressionAttributeNames:  names,
+					ExpressionAttributeValues: values,
+				},
+			},
+		},
+	}
 }
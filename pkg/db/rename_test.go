@@ -0,0 +1,390 @@
+package db_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/config"
+	"github.com/daangn/dynamoutil/pkg/db"
+	"github.com/daangn/dynamoutil/pkg/db/dbtest"
+	"github.com/daangn/dynamoutil/pkg/util"
+)
+
+func renameConfig(renames ...config.RenameAttribute) *config.DynamoDBRenameConfig {
+	return &config.DynamoDBRenameConfig{
+		Service: "test",
+		Target:  &config.DynamoDBConfig{TableName: "items"},
+		Rename:  renames,
+		Retry:   fastRetry,
+	}
+}
+
+// renamed returns copies of items whose before attribute is renamed to after.
+func renamed(items []map[string]*dynamodb.AttributeValue, before, after string) []map[string]*dynamodb.AttributeValue {
+	r := make([]map[string]*dynamodb.AttributeValue, len(items))
+	for i, item := range items {
+		r[i] = make(map[string]*dynamodb.AttributeValue, len(item))
+		for k, v := range item {
+			if k == before {
+				k = after
+			}
+			r[i][k] = v
+		}
+	}
+	return r
+}
+
+func TestRenamePages(t *testing.T) {
+	for _, segments := range []int{1, 3} {
+		chdirTemp(t)
+		items := newItems(30)
+		d := newTable("items", items)
+		d.MaxPageSize = 4
+		d.Throttle = 3
+
+		cfg := renameConfig(config.RenameAttribute{Before: "name", After: "title"})
+		cfg.Scan.TotalSegments = segments
+		result, err := db.Rename(context.Background(), cfg, &db.Options{Connect: d.Connect})
+		if err != nil {
+			t.Fatalf("Rename() error = %v", err)
+		}
+		if result.Read != 30 || result.Written != 30 || result.Failed != 0 {
+			t.Errorf("Rename() read %d, renamed %d and failed %d items, want 30, 30 and 0", result.Read, result.Written, result.Failed)
+		}
+		if result.Throttles != 3 {
+			t.Errorf("Rename() retried %d throttled updates, want 3", result.Throttles)
+		}
+		if result.Renames[0].Count != 30 {
+			t.Errorf("rename metrics count %d items, want 30", result.Renames[0].Count)
+		}
+		assertItems(t, d, "items", renamed(items, "name", "title"))
+	}
+}
+
+func TestRenameResume(t *testing.T) {
+	chdirTemp(t)
+	items := newItems(20)
+	d := newTable("items", items)
+	d.MaxPageSize = 5
+
+	cfg := renameConfig(config.RenameAttribute{Before: "name", After: "title"})
+	if _, err := db.Rename(context.Background(), cfg, &db.Options{Connect: failAfterScans(d, 2).Connect}); err == nil {
+		t.Fatal("Rename() succeeded with a lost connection")
+	}
+
+	cfg.Scan.Resume = true
+	result, err := db.Rename(context.Background(), cfg, &db.Options{Connect: d.Connect})
+	if err != nil {
+		t.Fatalf("resumed Rename() error = %v", err)
+	}
+	if result.Read != 20 || result.Written != 20 {
+		t.Errorf("resumed Rename() read %d and renamed %d items, want 20", result.Read, result.Written)
+	}
+	assertItems(t, d, "items", renamed(items, "name", "title"))
+
+	// The journal has entries of both runs
+	rollback, err := db.RollbackRename(context.Background(), cfg, result.Journal, &db.Options{Connect: d.Connect})
+	if err != nil {
+		t.Fatalf("RollbackRename() error = %v", err)
+	}
+	if rollback.Read != 20 || rollback.Written != 20 || rollback.Failed != 0 {
+		t.Errorf("RollbackRename() read %d, restored %d and failed %d entries, want 20, 20 and 0", rollback.Read, rollback.Written, rollback.Failed)
+	}
+	assertItems(t, d, "items", items)
+}
+
+func TestRenameItemChanged(t *testing.T) {
+	chdirTemp(t)
+	items := newItems(5)
+	d := newTable("items", items)
+
+	// An item changed after the scan is a failure instead of being overwritten
+	changed := &changingDB{DB: d, table: "items", item: renamed(items[:1], "name", "label")[0]}
+	cfg := renameConfig(config.RenameAttribute{Before: "name", After: "title"})
+	result, err := db.Rename(context.Background(), cfg, &db.Options{Connect: changed.Connect})
+	if err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	if result.Written != 4 || result.Failed != 1 || !errors.Is(result.Failures[0], db.ErrItemChanged) {
+		t.Errorf("Rename() renamed %d and failed %d items with %v, want 4 and 1 changed item", result.Written, result.Failed, result.Failures)
+	}
+	want := append([]map[string]*dynamodb.AttributeValue{changed.item}, renamed(items[1:], "name", "title")...)
+	assertItems(t, d, "items", want)
+}
+
+func TestRenameKeyAttributes(t *testing.T) {
+	keySchema := func(hash, rang string) []*dynamodb.KeySchemaElement {
+		schema := []*dynamodb.KeySchemaElement{{AttributeName: aws.String(hash), KeyType: aws.String(dynamodb.KeyTypeHash)}}
+		if rang != "" {
+			schema = append(schema, &dynamodb.KeySchemaElement{AttributeName: aws.String(rang), KeyType: aws.String(dynamodb.KeyTypeRange)})
+		}
+		return schema
+	}
+	tests := []struct {
+		name  string
+		table *dynamodb.CreateTableInput
+		keys  []string
+	}{
+		{
+			name:  "hash key",
+			table: &dynamodb.CreateTableInput{KeySchema: keySchema("pk", "")},
+			keys:  []string{"pk"},
+		},
+		{
+			name:  "hash and range keys",
+			table: &dynamodb.CreateTableInput{KeySchema: keySchema("pk", "sk")},
+			keys:  []string{"pk", "sk"},
+		},
+		{
+			name: "global secondary index",
+			table: &dynamodb.CreateTableInput{
+				KeySchema: keySchema("pk", ""),
+				GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
+					{IndexName: aws.String("by-email"), KeySchema: keySchema("email", "createdAt")},
+				},
+			},
+			keys: []string{"pk", "email", "createdAt"},
+		},
+		{
+			name: "local secondary index",
+			table: &dynamodb.CreateTableInput{
+				KeySchema: keySchema("pk", "sk"),
+				LocalSecondaryIndexes: []*dynamodb.LocalSecondaryIndex{
+					{IndexName: aws.String("by-rank"), KeySchema: keySchema("pk", "rank")},
+				},
+			},
+			keys: []string{"pk", "sk", "rank"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chdirTemp(t)
+			d := dbtest.New()
+			tt.table.TableName = aws.String("items")
+			if _, err := d.CreateTableWithContext(context.Background(), tt.table); err != nil {
+				t.Fatal(err)
+			}
+			items := newItems(3)
+			for i, item := range items {
+				item["sk"] = &dynamodb.AttributeValue{S: aws.String(fmt.Sprint(i))}
+			}
+			d.Put("items", items...)
+
+			for _, key := range tt.keys {
+				for _, rename := range []config.RenameAttribute{
+					{Before: key, After: "renamed"},
+					{Before: "name", After: key},
+					{Before: "name", After: key + ".nested"},
+				} {
+					if _, err := db.Rename(context.Background(), renameConfig(rename), &db.Options{Connect: d.Connect}); err == nil {
+						t.Errorf("Rename() of %s to %s succeeded", rename.Before, rename.After)
+					}
+				}
+			}
+			assertItems(t, d, "items", items)
+
+			result, err := db.Rename(context.Background(), renameConfig(config.RenameAttribute{Before: "name", After: "title"}), &db.Options{Connect: d.Connect})
+			if err != nil {
+				t.Fatalf("Rename() error = %v", err)
+			}
+			if result.Written != 3 || result.Failed != 0 {
+				t.Errorf("Rename() renamed %d and failed %d items, want 3 and 0", result.Written, result.Failed)
+			}
+			assertItems(t, d, "items", renamed(items, "name", "title"))
+		})
+	}
+}
+
+func TestRollbackMovedItems(t *testing.T) {
+	chdirTemp(t)
+	items := newItems(3)
+	// Items were moved to new keys by a transform
+	moved := renamed(items, "pk", "oldPk")
+	for i, item := range moved {
+		item["pk"] = &dynamodb.AttributeValue{S: aws.String(fmt.Sprintf("moved-%03d", i))}
+	}
+
+	// The first item is moved back, the second was changed after it was moved,
+	// and the original key of the third was put again
+	changed := renamed(moved[1:2], "name", "label")[0]
+	d := newTable("items", []map[string]*dynamodb.AttributeValue{moved[0], changed, moved[2], items[2]})
+
+	var journal []byte
+	for i := range items {
+		b, err := json.Marshal(map[string]interface{}{
+			"key":    util.TypedDynamo(map[string]*dynamodb.AttributeValue{"pk": items[i]["pk"]}),
+			"newKey": util.TypedDynamo(map[string]*dynamodb.AttributeValue{"pk": moved[i]["pk"]}),
+			"before": util.TypedDynamo(items[i]),
+			"after":  util.TypedDynamo(moved[i]),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		journal = append(append(journal, b...), '\n')
+	}
+	if err := ioutil.WriteFile("moved.journal.jsonl", journal, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := db.RollbackRename(context.Background(), renameConfig(), "moved.journal.jsonl", &db.Options{Connect: d.Connect})
+	if err != nil {
+		t.Fatalf("RollbackRename() error = %v", err)
+	}
+	if result.Written != 1 || result.Failed != 2 {
+		t.Fatalf("RollbackRename() restored %d and failed %d items, want 1 and 2", result.Written, result.Failed)
+	}
+	var itemChanged, keyExists bool
+	for _, err := range result.Failures {
+		itemChanged = itemChanged || errors.Is(err, db.ErrItemChanged)
+		keyExists = keyExists || errors.Is(err, db.ErrKeyExists)
+	}
+	if !itemChanged || !keyExists {
+		t.Errorf("RollbackRename() failures = %v, want a changed item and an existing key", result.Failures)
+	}
+	assertItems(t, d, "items", []map[string]*dynamodb.AttributeValue{items[0], items[2], changed, moved[2]})
+}
+
+func TestRenameSwap(t *testing.T) {
+	chdirTemp(t)
+	items := newItems(3)
+	for _, item := range items[:2] {
+		item["title"] = &dynamodb.AttributeValue{S: aws.String("title")}
+	}
+	d := newTable("items", items)
+
+	// name is moved back after it overwrote title, so only title is removed from items which have both,
+	// and items without title are unchanged
+	cfg := renameConfig(
+		config.RenameAttribute{Before: "name", After: "title"},
+		config.RenameAttribute{Before: "title", After: "name"},
+	)
+	result, err := db.Rename(context.Background(), cfg, &db.Options{Connect: d.Connect})
+	if err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	if result.Written != 2 || result.Failed != 0 {
+		t.Errorf("Rename() renamed %d and failed %d items, want 2 and 0", result.Written, result.Failed)
+	}
+	assertItems(t, d, "items", newItems(3))
+}
+
+func TestRenameRotatesJournal(t *testing.T) {
+	chdirTemp(t)
+	items := newItems(3)
+	d := newTable("items", items)
+
+	// Each completed run has its own journal, so renames run one after another
+	var journals []string
+	for _, rename := range []config.RenameAttribute{{Before: "name", After: "title"}, {Before: "title", After: "label"}} {
+		result, err := db.Rename(context.Background(), renameConfig(rename), &db.Options{Connect: d.Connect})
+		if err != nil {
+			t.Fatalf("Rename() error = %v", err)
+		}
+		if result.Journal == "test-rename.journal.jsonl" {
+			t.Errorf("journal %s isn't rotated", result.Journal)
+		}
+		journals = append(journals, result.Journal)
+	}
+	if _, err := os.Stat("test-rename.journal.jsonl"); !os.IsNotExist(err) {
+		t.Errorf("journal of completed runs exists: %v", err)
+	}
+
+	for i := len(journals) - 1; i >= 0; i-- {
+		if _, err := db.RollbackRename(context.Background(), renameConfig(), journals[i], &db.Options{Connect: d.Connect}); err != nil {
+			t.Fatalf("RollbackRename() error = %v", err)
+		}
+	}
+	assertItems(t, d, "items", items)
+}
+
+func TestRenameNestedPaths(t *testing.T) {
+	const original = `{
+		"pk": {"S": "item-000"},
+		"a.b": {"S": "dotted"},
+		"profile": {"M": {"zip": {"S": "123"}, "city": {"S": "Seoul"}}},
+		"items": {"L": [{"M": {"p": {"N": "1"}}}, {"M": {"q": {"N": "2"}}}, {"M": {"p": {"N": "3"}}}]}
+	}`
+	tests := []struct {
+		name   string
+		rename config.RenameAttribute
+		want   string
+	}{
+		{
+			name:   "escaped dot",
+			rename: config.RenameAttribute{Before: `a\.b`, After: "ab"},
+			want: `{
+				"pk": {"S": "item-000"},
+				"ab": {"S": "dotted"},
+				"profile": {"M": {"zip": {"S": "123"}, "city": {"S": "Seoul"}}},
+				"items": {"L": [{"M": {"p": {"N": "1"}}}, {"M": {"q": {"N": "2"}}}, {"M": {"p": {"N": "3"}}}]}
+			}`,
+		},
+		{
+			name:   "map to another map",
+			rename: config.RenameAttribute{Before: "profile.zip", After: "address.zip"},
+			want: `{
+				"pk": {"S": "item-000"},
+				"a.b": {"S": "dotted"},
+				"profile": {"M": {"city": {"S": "Seoul"}}},
+				"address": {"M": {"zip": {"S": "123"}}},
+				"items": {"L": [{"M": {"p": {"N": "1"}}}, {"M": {"q": {"N": "2"}}}, {"M": {"p": {"N": "3"}}}]}
+			}`,
+		},
+		{
+			name:   "nested to top level",
+			rename: config.RenameAttribute{Before: "profile.city", After: "city"},
+			want: `{
+				"pk": {"S": "item-000"},
+				"a.b": {"S": "dotted"},
+				"city": {"S": "Seoul"},
+				"profile": {"M": {"zip": {"S": "123"}}},
+				"items": {"L": [{"M": {"p": {"N": "1"}}}, {"M": {"q": {"N": "2"}}}, {"M": {"p": {"N": "3"}}}]}
+			}`,
+		},
+		{
+			name:   "every element of a list",
+			rename: config.RenameAttribute{Before: "items[*].p", After: "items[*].price"},
+			want: `{
+				"pk": {"S": "item-000"},
+				"a.b": {"S": "dotted"},
+				"profile": {"M": {"zip": {"S": "123"}, "city": {"S": "Seoul"}}},
+				"items": {"L": [{"M": {"price": {"N": "1"}}}, {"M": {"q": {"N": "2"}}}, {"M": {"price": {"N": "3"}}}]}
+			}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chdirTemp(t)
+			var item, want map[string]*dynamodb.AttributeValue
+			if err := json.Unmarshal([]byte(original), &item); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			d := newTable("items", []map[string]*dynamodb.AttributeValue{item})
+
+			result, err := db.Rename(context.Background(), renameConfig(tt.rename), &db.Options{Connect: d.Connect})
+			if err != nil {
+				t.Fatalf("Rename() error = %v", err)
+			}
+			if result.Written != 1 || result.Failed != 0 {
+				t.Errorf("Rename() renamed %d and failed %d items with %v, want 1 and 0", result.Written, result.Failed, result.Failures)
+			}
+			assertItems(t, d, "items", []map[string]*dynamodb.AttributeValue{want})
+
+			// Journal restores the original item
+			if _, err := db.RollbackRename(context.Background(), renameConfig(), result.Journal, &db.Options{Connect: d.Connect}); err != nil {
+				t.Fatalf("RollbackRename() error = %v", err)
+			}
+			assertItems(t, d, "items", []map[string]*dynamodb.AttributeValue{item})
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// opMetrics counts items changed by an operation of a rewrite, and the time spent on them.
+type opMetrics struct {
+	count    int64
+	duration time.Duration
+}
+
+// rewrite changes items of a table in place. Rename and Transform are rewrites with different operations.
+type rewrite struct {
+	table      *dynamodb.TableDescription
+	scan       config.ScanConfig
+	throughput config.ThroughputConfig
+	retry      config.RetryConfig
+	journal    *journal
+	// ops is the number of operations, which are counted by the indexes passed to applied
+	ops int
+	// change returns a changed copy of the item, or nil if the item isn't changed.
+	// applied is called with the index of every operation which changed the item.
+	change func(item map[string]*dynamodb.AttributeValue, applied func(i int)) (map[string]*dynamodb.AttributeValue, error)
+	// update returns a conditional update of the item to changed. The key of both is the same.
+	update func(item, changed map[string]*dynamodb.AttributeValue) *dynamodb.UpdateItemInput
+}
+
+// run scans the table, and writes every changed item. Items whose changed values don't match the types of key attributes,
+// or which fail to change, are failures of the tracker. Items whose key is changed are moved to the new key with
+// moveTransaction, so items whose new key is taken by another item, or which were changed after they were read,
+// are failures of the tracker instead of being overwritten.
+// Entries of changed items are written to the journal before the items are written.
+func (rw *rewrite) run(ctx context.Context, db Client, cp *checkpoint, t *tracker) ([]opMetrics, error) {
+	tableName := aws.StringValue(rw.table.TableName)
+	// Keys are only the attributes of the key schema, since tables may have no sort key
+	keys := keyAttributes(rw.table)
+
+	var metricsMu sync.Mutex
+	metrics := make([]opMetrics, rw.ops)
+
+	u := newUpdater(db, rw.retry, writeLimiter(rw.throughput, rw.table), t)
+	read, written := cp.counts()
+	t.addRead(int(read))
+	t.addWritten(int(written))
+
+	err := parallelScan(ctx, db, &dynamodb.ScanInput{
+		TableName: &tableName,
+		Limit:     aws.Int64(2500),
+	}, rw.scan, cp, readLimiter(rw.throughput, rw.table), func(segment int, items []map[string]*dynamodb.AttributeValue, lastKey map[string]*dynamodb.AttributeValue) error {
+		t.addRead(len(items))
+
+		var (
+			updates []*dynamodb.UpdateItemInput
+			moves   []*dynamodb.TransactWriteItemsInput
+			entries []journalEntry
+		)
+		for _, item := range items {
+			// Track time spent on each operation
+			itemStart := time.Now()
+			var (
+				applied []int
+				elapsed []time.Duration
+			)
+			changed, err := rw.change(item, func(i int) {
+				applied = append(applied, i)
+				elapsed = append(elapsed, time.Since(itemStart))
+			})
+			if err == nil && changed != nil {
+				err = checkKeyTypes(rw.table, item, changed)
+			}
+			if err != nil {
+				t.fail(errors.Wrapf(err, "key %s", itemKey(item, keys)))
+				continue
+			}
+			if changed == nil {
+				continue
+			}
+			// Metrics are counted only for items which can be changed
+			metricsMu.Lock()
+			for j, i := range applied {
+				metrics[i].count++
+				metrics[i].duration += elapsed[j]
+			}
+			metricsMu.Unlock()
+
+			entries = append(entries, newJournalEntry(item, changed, keys))
+			if keyChanged(item, changed, keys) {
+				moves = append(moves, moveTransaction(tableName, keys, changed, item))
+				continue
+			}
+			updates = append(updates, rw.update(item, changed))
+		}
+
+		if err := rw.journal.write(entries); err != nil {
+			return err
+		}
+
+		var n int64
+		count := func(c int) {
+			atomic.AddInt64(&n, int64(c))
+			t.addWritten(c)
+		}
+		if err := u.updateAll(ctx, updates, count); err != nil {
+			return err
+		}
+		if err := u.transactAll(ctx, moves, keys, count); err != nil {
+			return err
+		}
+		return cp.commit(segment, lastKey, len(items), int(n))
+	})
+	return metrics, err
+}
@@ -0,0 +1,145 @@
+package db
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// readPage is a page of items read by Scan or Query.
+type readPage struct {
+	items    []map[string]*dynamodb.AttributeValue
+	lastKey  map[string]*dynamodb.AttributeValue
+	consumed *dynamodb.ConsumedCapacity
+}
+
+// pageFunc reads a page of the segment from startKey.
+type pageFunc func(ctx context.Context, segment int, startKey map[string]*dynamodb.AttributeValue, opts ...request.Option) (*readPage, error)
+
+// parallelScan scans all pages of the table and calls fn with items of every page.
+// With TotalSegments, segments are scanned by workers at the same time,
+// so fn must be safe to be called concurrently.
+// The first error returned by Scan or fn stops the other segments.
+// With a checkpoint, segments continue from their last keys, and finished segments are skipped.
+// lastKey is nil for the last page of a segment.
+// With a limiter, pages wait for read capacity, and their consumed capacity is taken from it.
+// Throttled pages are scanned again after slowing down the limiter.
+func parallelScan(ctx context.Context, db Client, input *dynamodb.ScanInput, cfg config.ScanConfig, cp *checkpoint, rl *limiter, fn func(segment int, items []map[string]*dynamodb.AttributeValue, lastKey map[string]*dynamodb.AttributeValue) error) error {
+	total := cfg.TotalSegments
+	if total < 1 {
+		total = 1
+	}
+
+	return parallelPages(ctx, total, cfg.Workers, cp, rl, func(ctx context.Context, segment int, startKey map[string]*dynamodb.AttributeValue, opts ...request.Option) (*readPage, error) {
+		in := *input
+		if rl != nil {
+			in.ReturnConsumedCapacity = aws.String(dynamodb.ReturnConsumedCapacityTotal)
+		}
+		if total > 1 {
+			in.Segment = aws.Int64(int64(segment))
+			in.TotalSegments = aws.Int64(int64(total))
+		}
+		in.ExclusiveStartKey = startKey
+
+		o, err := db.ScanWithContext(ctx, &in, opts...)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to scan segment %d", segment)
+		}
+		return &readPage{items: o.Items, lastKey: o.LastEvaluatedKey, consumed: o.ConsumedCapacity}, nil
+	}, fn)
+}
+
+// parallelPages reads pages of total segments by workers, and calls fn with items of every page.
+// Segments are read from their keys of the checkpoint, and pages wait for the limiter like parallelScan.
+func parallelPages(ctx context.Context, total, workers int, cp *checkpoint, rl *limiter, page pageFunc, fn func(segment int, items []map[string]*dynamodb.AttributeValue, lastKey map[string]*dynamodb.AttributeValue) error) error {
+	if workers < 1 || workers > total {
+		workers = total
+	}
+
+	segments := make(chan int, total)
+	for i := 0; i < total; i++ {
+		segments <- i
+	}
+	close(segments)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	// With a limiter, a throttled page slows it down and is read again,
+	// instead of being retried by the SDK
+	var opts []request.Option
+	if rl != nil {
+		opts = append(opts, func(req *request.Request) {
+			req.Retryer = client.NoOpRetryer{}
+		})
+	}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for segment := range segments {
+				var startKey map[string]*dynamodb.AttributeValue
+				if cp != nil {
+					key, done := cp.start(segment)
+					if done {
+						continue
+					}
+					startKey = key
+				}
+
+				for !failed() {
+					if err := rl.wait(ctx); err != nil {
+						fail(err)
+						return
+					}
+					p, err := page(ctx, segment, startKey, opts...)
+					if rl != nil && err != nil && ctx.Err() == nil && request.IsErrorThrottle(errors.Cause(err)) {
+						rl.throttled()
+						continue
+					}
+					if err != nil {
+						fail(err)
+						return
+					}
+					rl.take(consumedUnits(p.consumed))
+
+					if err := fn(segment, p.items, p.lastKey); err != nil {
+						fail(err)
+						return
+					}
+
+					if p.lastKey == nil {
+						break
+					}
+					startKey = p.lastKey
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return firstErr
+}
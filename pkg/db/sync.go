@@ -0,0 +1,153 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// CopyResult is the result of Copy.
+type CopyResult struct {
+	Result
+	// Puts and Deletes are the number of items to put and to delete with Sync.
+	// They are counted with DryRun as well. Without Sync, Puts is the number of read items.
+	Puts    int64
+	Deletes int64
+	// Unchanged is the number of items skipped with Sync, since they are the same in the target.
+	Unchanged int64
+	// Deleted is the number of target-only items deleted with Delete.
+	Deleted int64
+	// Verify is the result of the verification with Verify. It is nil without it.
+	Verify *VerifyResult
+}
+
+// syncEntry is an item of the target table.
+type syncEntry struct {
+	key  map[string]*dynamodb.AttributeValue
+	hash [sha256.Size]byte
+	seen bool
+}
+
+// validateSync returns an error if the config can't be synced.
+// A part of the origin would make every other item of the target a target-only item.
+func validateSync(cfg *config.DynamoDBCopyConfig) error {
+	if cfg.Select.Index != "" || cfg.Select.Filter.Expression != "" || len(cfg.Select.Projection) > 0 || cfg.Select.Query.Enabled() {
+		return errors.New("sync can't be used with index, filter, projection or query")
+	}
+	if cfg.Scan.Resume {
+		// Unchanged items are skipped, so sync again instead of resuming
+		return errors.Wrap(ErrResumeUnsupported, "sync compares whole tables")
+	}
+	return nil
+}
+
+// syncCopy makes the target a mirror of the origin.
+// Items of the target are hashed first, and then items of the origin are put only if they are different.
+// Items of the target which are not in the origin are deleted with Delete.
+// target is nil if the target table doesn't exist with DryRun.
+func syncCopy(ctx context.Context, cfg *config.DynamoDBCopyConfig, originDB, targetDB Client, origin, target *dynamodb.TableDescription, t *tracker) (*CopyResult, error) {
+	keys := keyAttributes(origin)
+	result := &CopyResult{}
+
+	var (
+		mu      sync.Mutex
+		entries = make(map[string]*syncEntry)
+	)
+	if target != nil {
+		err := parallelScan(ctx, targetDB, &dynamodb.ScanInput{
+			TableName: &cfg.Target.TableName,
+			Limit:     aws.Int64(2500),
+		}, cfg.Scan, nil, readLimiter(cfg.Throughput, target), func(segment int, items []map[string]*dynamodb.AttributeValue, lastKey map[string]*dynamodb.AttributeValue) error {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, item := range items {
+				entries[itemKey(item, keys)] = &syncEntry{key: keyOf(item, keys), hash: itemHash(item)}
+			}
+			return nil
+		})
+		if err != nil {
+			result.Result = *t.result()
+			return result, errors.Wrap(err, "failed to read target")
+		}
+	}
+
+	var (
+		bw     *batchWriter
+		sample *verifySample
+	)
+	if !cfg.DryRun {
+		bw = newBatchWriter(targetDB, cfg.Target.TableName, cfg.Retry, writeLimiter(cfg.Throughput, target), t)
+		sample = newVerifySample(cfg.Verify, keys)
+	}
+
+	err := parallelScan(ctx, originDB, &dynamodb.ScanInput{
+		TableName: &cfg.Origin.TableName,
+		Limit:     aws.Int64(2500),
+	}, cfg.Scan, nil, readLimiter(cfg.Throughput, origin), func(segment int, items []map[string]*dynamodb.AttributeValue, lastKey map[string]*dynamodb.AttributeValue) error {
+		t.addRead(len(items))
+		sample.add(items)
+
+		var wrs []*dynamodb.WriteRequest
+		mu.Lock()
+		for _, item := range items {
+			e, ok := entries[itemKey(item, keys)]
+			if ok {
+				e.seen = true
+				if e.hash == itemHash(item) {
+					result.Unchanged++
+					continue
+				}
+			}
+			wrs = append(wrs, &dynamodb.WriteRequest{
+				PutRequest: &dynamodb.PutRequest{Item: item},
+			})
+		}
+		result.Puts += int64(len(wrs))
+		mu.Unlock()
+
+		if bw == nil {
+			return nil
+		}
+		return bw.writeChunks(ctx, wrs, t.addWritten)
+	})
+	if err != nil {
+		result.Result = *t.result()
+		return result, errors.Wrap(err, "failed to sync items")
+	}
+
+	var deletes []*dynamodb.WriteRequest
+	for _, e := range entries {
+		if !e.seen {
+			deletes = append(deletes, &dynamodb.WriteRequest{
+				DeleteRequest: &dynamodb.DeleteRequest{Key: e.key},
+			})
+		}
+	}
+	result.Deletes = int64(len(deletes))
+	if bw != nil && cfg.Delete {
+		err := bw.writeChunks(ctx, deletes, func(n int) {
+			atomic.AddInt64(&result.Deleted, int64(n))
+		})
+		if err != nil {
+			result.Result = *t.result()
+			return result, errors.Wrap(err, "failed to delete target-only items")
+		}
+	}
+
+	result.Result = *t.result()
+	if sample != nil {
+		result.Verify, err = verifyCopy(ctx, cfg, targetDB, target, sample, result.Read, t)
+		r := t.result()
+		result.Retries, result.Throttles = r.Retries, r.Throttles
+		if err != nil {
+			return result, errors.Wrap(err, "failed to verify")
+		}
+	}
+	return result, nil
+}
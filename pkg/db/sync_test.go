@@ -0,0 +1,130 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/db"
+	"github.com/daangn/dynamoutil/pkg/db/dbtest"
+)
+
+// syncTables returns a DB whose target has items 0-7 of the origin's 10 items with item-005 changed,
+// and two target-only items.
+func syncTables() (*dbtest.DB, []map[string]*dynamodb.AttributeValue) {
+	items := newItems(10)
+	d := newTable("origin", items)
+	d.AddTable("target", "pk", "")
+
+	target := newItems(8)
+	target[5] = map[string]*dynamodb.AttributeValue{
+		"pk":    {S: aws.String("item-005")},
+		"count": {N: aws.String("50")},
+	}
+	d.Put("target", target...)
+	d.Put("target",
+		map[string]*dynamodb.AttributeValue{"pk": {S: aws.String("only-1")}},
+		map[string]*dynamodb.AttributeValue{"pk": {S: aws.String("only-2")}},
+	)
+	return d, items
+}
+
+func TestSync(t *testing.T) {
+	chdirTemp(t)
+	d, items := syncTables()
+
+	cfg := copyConfig()
+	cfg.Sync = true
+	cfg.Delete = true
+	result, err := db.Copy(context.Background(), cfg, &db.Options{Connect: d.Connect})
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	// item-005 is changed, and item-008 and item-009 are new
+	if result.Puts != 3 || result.Written != 3 || result.Unchanged != 7 {
+		t.Errorf("Copy() put %d, wrote %d and skipped %d items, want 3, 3 and 7", result.Puts, result.Written, result.Unchanged)
+	}
+	if result.Deletes != 2 || result.Deleted != 2 {
+		t.Errorf("Copy() planned %d and deleted %d target-only items, want 2 and 2", result.Deletes, result.Deleted)
+	}
+	assertItems(t, d, "target", items)
+}
+
+func TestSyncKeepsTargetOnlyItems(t *testing.T) {
+	chdirTemp(t)
+	d, _ := syncTables()
+
+	cfg := copyConfig()
+	cfg.Sync = true
+	result, err := db.Copy(context.Background(), cfg, &db.Options{Connect: d.Connect})
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if result.Deletes != 2 || result.Deleted != 0 {
+		t.Errorf("Copy() planned %d and deleted %d target-only items, want 2 and 0", result.Deletes, result.Deleted)
+	}
+	if n := len(d.Items("target")); n != 12 {
+		t.Errorf("target has %d items, want 12", n)
+	}
+}
+
+func TestSyncDryRun(t *testing.T) {
+	chdirTemp(t)
+	d, _ := syncTables()
+	before := d.Items("target")
+
+	cfg := copyConfig()
+	cfg.Sync = true
+	cfg.Delete = true
+	cfg.DryRun = true
+	cfg.Verify.Enabled = true
+	result, err := db.Copy(context.Background(), cfg, &db.Options{Connect: d.Connect})
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if result.Puts != 3 || result.Unchanged != 7 || result.Deletes != 2 {
+		t.Errorf("Copy() planned %d puts, %d unchanged and %d deletes, want 3, 7 and 2", result.Puts, result.Unchanged, result.Deletes)
+	}
+	if result.Written != 0 || result.Deleted != 0 || result.Verify != nil {
+		t.Errorf("Copy() with DryRun wrote %d, deleted %d and verified %v", result.Written, result.Deleted, result.Verify)
+	}
+	assertItems(t, d, "target", before)
+}
+
+func TestSyncDryRunWithoutTarget(t *testing.T) {
+	chdirTemp(t)
+	d := newTable("origin", newItems(5))
+
+	cfg := copyConfig()
+	cfg.Sync = true
+	cfg.DryRun = true
+	cfg.CreateTarget = true
+	result, err := db.Copy(context.Background(), cfg, &db.Options{Connect: d.Connect})
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if result.Puts != 5 || result.Written != 0 {
+		t.Errorf("Copy() planned %d puts and wrote %d items, want 5 and 0", result.Puts, result.Written)
+	}
+	if _, err := d.DescribeTableWithContext(context.Background(), &dynamodb.DescribeTableInput{TableName: aws.String("target")}); err == nil {
+		t.Error("Copy() with DryRun created the target table")
+	}
+}
+
+func TestSyncInvalidConfig(t *testing.T) {
+	cfg := copyConfig()
+	cfg.Sync = true
+	cfg.Scan.Resume = true
+	if _, err := db.Copy(context.Background(), cfg, &db.Options{}); !errors.Is(err, db.ErrResumeUnsupported) {
+		t.Errorf("Copy() with Resume error = %v, want ErrResumeUnsupported", err)
+	}
+
+	cfg = copyConfig()
+	cfg.Sync = true
+	cfg.Select.Filter.Expression = "#n > :n"
+	if _, err := db.Copy(context.Background(), cfg, &db.Options{}); err == nil {
+		t.Error("Copy() with a filter error = nil")
+	}
+}
@@ -0,0 +1,482 @@
+package db
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/config"
+	"github.com/daangn/dynamoutil/pkg/util"
+	"github.com/pkg/errors"
+)
+
+// Transform operations
+const (
+	transformRemove       = "remove"
+	transformSet          = "set"
+	transformSetIfMissing = "setIfMissing"
+	transformConvert      = "convert"
+	transformCopyAttr     = "copyAttr"
+	transformRename       = "rename"
+)
+
+// Formats of convert
+const (
+	formatLower   = "lower"
+	formatUpper   = "upper"
+	formatISO8601 = "iso8601"
+)
+
+// TransformResult is the result of Transform.
+type TransformResult struct {
+	Result
+	// Operations has metrics of each operation in the order of the config.
+	Operations []TransformMetrics
+	// Journal is the file of the journal. The journal of a completed run is rotated to a file with the time.
+	Journal string
+}
+
+// TransformMetrics holds metrics for each transform operation.
+type TransformMetrics struct {
+	Op       string
+	Path     string
+	Count    int64
+	Duration time.Duration
+}
+
+// Transform applies operations of the config to every item of the target table in order.
+// Read is the number of scanned items, and Written is the number of changed items.
+// Changed attributes are replaced with conditional UpdateItem calls like Rename, so items changed after they were read
+// are failures of the result, and keys and original attributes are written to the journal to be restored by RollbackTransform.
+// Items whose values can't be converted are failures of the result, and values which already have the type are unchanged,
+// so that an interrupted transform can run again.
+// Items whose key attribute is changed are moved to the new key in a transaction, which fails if an item with the key exists,
+// so items which would overwrite other items are failures of the result.
+func Transform(ctx context.Context, cfg *config.DynamoDBTransformConfig, opts *Options) (*TransformResult, error) {
+	cfg = transformDefaults(cfg)
+	targetDB, err := opts.connect(cfg.Target)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to target database")
+	}
+
+	table, err := describeTable(ctx, targetDB, cfg.Target.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	ops, err := parseTransform(table, cfg.Operations)
+	if err != nil {
+		return nil, err
+	}
+	keys := keyAttributes(table)
+
+	cp, err := openCheckpoint(cfg.Scan.Checkpoint, cfg.Target.TableName, cfg.Scan.TotalSegments, cfg.Scan.Resume)
+	if err != nil {
+		return nil, err
+	}
+
+	j, err := openJournal(cfg.Journal, cfg.Scan.Resume)
+	if err != nil {
+		return nil, err
+	}
+	defer j.Close()
+
+	rw := &rewrite{
+		table:      table,
+		scan:       cfg.Scan,
+		throughput: cfg.Throughput,
+		retry:      cfg.Retry,
+		journal:    j,
+		ops:        len(ops),
+		change: func(item map[string]*dynamodb.AttributeValue, applied func(i int)) (map[string]*dynamodb.AttributeValue, error) {
+			return applyTransform(item, ops, applied)
+		},
+		update: func(item, changed map[string]*dynamodb.AttributeValue) *dynamodb.UpdateItemInput {
+			return replaceUpdate(cfg.Target.TableName, keys, item, changed)
+		},
+	}
+	t := newTracker(opts)
+	counts, err := rw.run(ctx, targetDB, cp, t)
+
+	metrics := make([]TransformMetrics, len(cfg.Operations))
+	for i, op := range cfg.Operations {
+		metrics[i] = TransformMetrics{Op: op.Op, Path: op.Path, Count: counts[i].count, Duration: counts[i].duration}
+	}
+	result := &TransformResult{Result: *t.result(), Operations: metrics, Journal: cfg.Journal}
+	if err != nil {
+		return result, errors.Wrap(err, "failed to transform items")
+	}
+
+	if err := cp.remove(); err != nil {
+		return result, errors.Wrap(err, "failed to remove checkpoint")
+	}
+	if result.Journal, err = j.rotate(); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// transformDefaults returns a copy of the config with the default checkpoint and journal.
+func transformDefaults(cfg *config.DynamoDBTransformConfig) *config.DynamoDBTransformConfig {
+	c := *cfg
+	if c.Scan.Checkpoint == "" {
+		c.Scan.Checkpoint = c.Service + "-transform.checkpoint.json"
+	}
+	if c.Journal == "" {
+		c.Journal = c.Service + "-transform.journal.jsonl"
+	}
+	return &c
+}
+
+// RollbackTransform restores items of the target table from a journal written by Transform like RollbackRename.
+func RollbackTransform(ctx context.Context, cfg *config.DynamoDBTransformConfig, path string, opts *Options) (*Result, error) {
+	result, err := rollback(ctx, cfg.Target, cfg.Throughput, cfg.Retry, path, opts)
+	return result, errors.Wrap(err, "failed to roll back transform")
+}
+
+// transformOp is an operation of the config with parsed paths and values.
+type transformOp struct {
+	op        string
+	path      documentPath
+	from      []documentPath
+	value     *dynamodb.AttributeValue
+	to        string
+	format    string
+	separator string
+	// rename is the parsed pair of rename
+	rename renamePath
+}
+
+// parseTransform parses operations, and returns an error if they can't be applied to the table.
+// Like renames, key attributes of the table and indexes can't be removed or renamed.
+func parseTransform(table *dynamodb.TableDescription, operations []config.TransformOperation) ([]transformOp, error) {
+	owners := keyOwners(table)
+	ops := make([]transformOp, len(operations))
+	for i, o := range operations {
+		if o.Path == "" {
+			return nil, errors.Errorf("operation %d (%s): path is required", i+1, o.Op)
+		}
+		path, err := parsePath(o.Path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "operation %d (%s)", i+1, o.Op)
+		}
+		op := transformOp{op: o.Op, path: path, to: o.To, format: o.Format, separator: o.Separator}
+
+		switch o.Op {
+		case transformRemove:
+			if owner, ok := owners[path[0].name]; ok {
+				return nil, errors.Errorf("%s can't be removed, since it is a key attribute of %s", o.Path, owner)
+			}
+		case transformSet, transformSetIfMissing:
+			if o.Value == nil {
+				return nil, errors.Errorf("operation %d (%s %s): value is required", i+1, o.Op, o.Path)
+			}
+			op.value, err = expressionValue(config.ExpressionValue{Name: o.Path, Value: o.Value, Type: o.Type})
+			if err != nil {
+				return nil, errors.Wrapf(err, "operation %d (%s %s): invalid value", i+1, o.Op, o.Path)
+			}
+		case transformConvert:
+			if err := validateConvert(o); err != nil {
+				return nil, errors.Wrapf(err, "operation %d (%s %s)", i+1, o.Op, o.Path)
+			}
+			if op.to == "" {
+				op.to = dynamodb.ScalarAttributeTypeS
+			}
+		case transformCopyAttr:
+			if len(o.From) == 0 {
+				return nil, errors.Errorf("operation %d (%s %s): from is required", i+1, o.Op, o.Path)
+			}
+			for _, f := range o.From {
+				from, err := parsePath(f)
+				if err != nil {
+					return nil, errors.Wrapf(err, "operation %d (%s %s)", i+1, o.Op, o.Path)
+				}
+				op.from = append(op.from, from)
+			}
+			switch {
+			case len(op.from) == 1 && op.from[0].wildcards() != path.wildcards():
+				return nil, errors.Errorf("%s -> %s: from and path must have the same number of [*]", o.From[0], o.Path)
+			case len(op.from) > 1 && path.wildcards() > 0:
+				return nil, errors.Errorf("operation %d (%s %s): joined paths can't have [*]", i+1, o.Op, o.Path)
+			}
+			for j, from := range op.from {
+				if len(op.from) > 1 && from.wildcards() > 0 {
+					return nil, errors.Errorf("operation %d (%s %s): joined path %s can't have [*]", i+1, o.Op, o.Path, o.From[j])
+				}
+			}
+		case transformRename:
+			if len(o.From) != 1 {
+				return nil, errors.Errorf("operation %d (%s %s): rename needs a path in from", i+1, o.Op, o.Path)
+			}
+			renames, err := parseRenames(table, []config.RenameAttribute{{Before: o.From[0], After: o.Path}})
+			if err != nil {
+				return nil, err
+			}
+			op.rename = renames[0]
+		default:
+			return nil, errors.Errorf("operation %d: unknown op %q", i+1, o.Op)
+		}
+		ops[i] = op
+	}
+	return ops, nil
+}
+
+// validateConvert returns an error if the type and the format of convert can't be used together.
+func validateConvert(o config.TransformOperation) error {
+	switch o.Format {
+	case "":
+		switch o.To {
+		case dynamodb.ScalarAttributeTypeS, dynamodb.ScalarAttributeTypeN:
+		case "SS", "L":
+			if o.Separator == "" {
+				return errors.Errorf("separator is required to convert to %s", o.To)
+			}
+		case "":
+			return errors.New("to is required")
+		default:
+			return errors.Errorf("can't convert to %s. (S, N, SS or L)", o.To)
+		}
+	case formatLower, formatUpper:
+		if o.To != "" && o.To != dynamodb.ScalarAttributeTypeS {
+			return errors.Errorf("%s format converts to S, but got %s", o.Format, o.To)
+		}
+	case formatISO8601:
+		if o.To != dynamodb.ScalarAttributeTypeS && o.To != dynamodb.ScalarAttributeTypeN {
+			return errors.Errorf("%s format converts to S or N, but got %q", o.Format, o.To)
+		}
+	default:
+		return errors.Errorf("unknown format %q. (lower, upper or iso8601)", o.Format)
+	}
+	return nil
+}
+
+// applyTransform applies operations to a copy of the item in order, and returns the copy.
+// applied is called with the index of every operation which changed the item. It returns nil if the item isn't changed.
+func applyTransform(item map[string]*dynamodb.AttributeValue, ops []transformOp, applied func(i int)) (map[string]*dynamodb.AttributeValue, error) {
+	c := cloneValue(&dynamodb.AttributeValue{M: item}).M
+	for i, op := range ops {
+		var (
+			changed bool
+			err     error
+		)
+		switch op.op {
+		case transformRemove:
+			paths, _ := op.path.expand(c)
+			// Values are removed from the last, so that indexes of lists don't shift
+			for j := len(paths) - 1; j >= 0; j-- {
+				paths[j].remove(c)
+			}
+			changed = len(paths) > 0
+		case transformSet, transformSetIfMissing:
+			for _, p := range op.path.targets(c) {
+				cur := p.get(c)
+				if op.op == transformSetIfMissing && cur != nil || equalValue(cur, op.value) {
+					continue
+				}
+				if err = p.set(c, cloneValue(op.value)); err != nil {
+					break
+				}
+				changed = true
+			}
+		case transformConvert:
+			paths, _ := op.path.expand(c)
+			for _, p := range paths {
+				var v *dynamodb.AttributeValue
+				if v, err = convertValue(p.get(c), op.to, op.format, op.separator); err != nil {
+					break
+				}
+				if v == nil {
+					continue
+				}
+				if err = p.set(c, v); err != nil {
+					break
+				}
+				changed = true
+			}
+		case transformCopyAttr:
+			changed, err = copyValues(c, op)
+		case transformRename:
+			var renamed map[string]*dynamodb.AttributeValue
+			renamed, err = applyRenames(c, []renamePath{op.rename}, func(int) {})
+			if renamed != nil {
+				c, changed = renamed, true
+			}
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to %s %s", op.op, op.path)
+		}
+		if changed {
+			applied(i)
+		}
+	}
+
+	// Operations may change values back
+	if equalValue(&dynamodb.AttributeValue{M: item}, &dynamodb.AttributeValue{M: c}) {
+		return nil, nil
+	}
+	return c, nil
+}
+
+// copyValues copies the value of the single from path to the path, and [*] of the path is the index matched by [*] of from.
+// Values of many from paths are strings or numbers joined with the separator, and they aren't joined if any of them is missing.
+func copyValues(item map[string]*dynamodb.AttributeValue, op transformOp) (bool, error) {
+	var changed bool
+	set := func(p documentPath, v *dynamodb.AttributeValue) error {
+		if equalValue(p.get(item), v) {
+			return nil
+		}
+		changed = true
+		return p.set(item, v)
+	}
+
+	if len(op.from) == 1 {
+		paths, indexes := op.from[0].expand(item)
+		for j, p := range paths {
+			if err := set(op.path.fill(indexes[j]), cloneValue(p.get(item))); err != nil {
+				return false, err
+			}
+		}
+		return changed, nil
+	}
+
+	parts := make([]string, len(op.from))
+	for j, from := range op.from {
+		v := from.get(item)
+		switch {
+		case v == nil:
+			return false, nil
+		case v.S != nil:
+			parts[j] = *v.S
+		case v.N != nil:
+			parts[j] = *v.N
+		default:
+			return false, errors.Errorf("%s is not a string or a number", from)
+		}
+	}
+	err := set(op.path, &dynamodb.AttributeValue{S: aws.String(strings.Join(parts, op.separator))})
+	return changed, err
+}
+
+// convertValue returns the value converted to the type with the format, or nil if the value doesn't need to be converted.
+// It returns an error if the value can't be converted.
+func convertValue(v *dynamodb.AttributeValue, to, format, separator string) (*dynamodb.AttributeValue, error) {
+	switch format {
+	case formatLower, formatUpper:
+		if v.S == nil {
+			return nil, errors.New("value is not a string")
+		}
+		s := strings.ToLower(*v.S)
+		if format == formatUpper {
+			s = strings.ToUpper(*v.S)
+		}
+		if s == *v.S {
+			return nil, nil
+		}
+		return &dynamodb.AttributeValue{S: aws.String(s)}, nil
+	case formatISO8601:
+		if to == dynamodb.ScalarAttributeTypeS {
+			if v.S != nil {
+				return nil, nil
+			}
+			if v.N == nil {
+				return nil, errors.New("value is not epoch seconds")
+			}
+			f, err := strconv.ParseFloat(*v.N, 64)
+			if err != nil {
+				return nil, errors.Errorf("%s is not epoch seconds", *v.N)
+			}
+			sec, frac := math.Modf(f)
+			s := time.Unix(int64(sec), int64(math.Round(frac*1e3))*int64(time.Millisecond)).UTC().Format(time.RFC3339Nano)
+			return &dynamodb.AttributeValue{S: aws.String(s)}, nil
+		}
+		if v.N != nil {
+			return nil, nil
+		}
+		if v.S == nil {
+			return nil, errors.New("value is not an ISO-8601 string")
+		}
+		ts, err := time.Parse(time.RFC3339Nano, *v.S)
+		if err != nil {
+			return nil, errors.Errorf("%q is not an ISO-8601 time", *v.S)
+		}
+		n := strconv.FormatInt(ts.Unix(), 10)
+		if ms := ts.Nanosecond() / int(time.Millisecond); ms > 0 {
+			n = strconv.FormatFloat(float64(ts.Unix())+float64(ms)/1e3, 'f', -1, 64)
+		}
+		return &dynamodb.AttributeValue{N: aws.String(n)}, nil
+	}
+
+	switch to {
+	case dynamodb.ScalarAttributeTypeN:
+		if v.N != nil {
+			return nil, nil
+		}
+		if v.S == nil {
+			return nil, errors.New("value is not a string")
+		}
+		s := strings.TrimSpace(*v.S)
+		if !util.IsNumber(s) {
+			return nil, errors.Errorf("%q is not a number", *v.S)
+		}
+		return &dynamodb.AttributeValue{N: aws.String(s)}, nil
+	case dynamodb.ScalarAttributeTypeS:
+		switch {
+		case v.S != nil:
+			return nil, nil
+		case v.N != nil:
+			return &dynamodb.AttributeValue{S: aws.String(*v.N)}, nil
+		case v.BOOL != nil:
+			return &dynamodb.AttributeValue{S: aws.String(strconv.FormatBool(*v.BOOL))}, nil
+		case v.SS != nil && separator != "":
+			return &dynamodb.AttributeValue{S: aws.String(strings.Join(aws.StringValueSlice(v.SS), separator))}, nil
+		case v.L != nil && separator != "":
+			parts := make([]string, len(v.L))
+			for i, e := range v.L {
+				if e.S == nil {
+					return nil, errors.Errorf("element %d is not a string", i)
+				}
+				parts[i] = *e.S
+			}
+			return &dynamodb.AttributeValue{S: aws.String(strings.Join(parts, separator))}, nil
+		}
+		return nil, errors.New("value can't be converted to a string")
+	case "SS":
+		if v.SS != nil {
+			return nil, nil
+		}
+		if v.S == nil {
+			return nil, errors.New("value is not a string")
+		}
+		// A string set can't have empty or duplicated strings
+		var ss []*string
+		seen := make(map[string]bool)
+		for _, s := range strings.Split(*v.S, separator) {
+			if s == "" || seen[s] {
+				continue
+			}
+			seen[s] = true
+			ss = append(ss, aws.String(s))
+		}
+		if len(ss) == 0 {
+			return nil, errors.Errorf("%q has no strings to split", *v.S)
+		}
+		return &dynamodb.AttributeValue{SS: ss}, nil
+	default:
+		if v.L != nil {
+			return nil, nil
+		}
+		if v.S == nil {
+			return nil, errors.New("value is not a string")
+		}
+		parts := strings.Split(*v.S, separator)
+		l := make([]*dynamodb.AttributeValue, len(parts))
+		for i, s := range parts {
+			l[i] = &dynamodb.AttributeValue{S: aws.String(s)}
+		}
+		return &dynamodb.AttributeValue{L: l}, nil
+	}
+}
@@ -0,0 +1,116 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/config"
+	"github.com/daangn/dynamoutil/pkg/db"
+	"github.com/daangn/dynamoutil/pkg/db/dbtest"
+)
+
+func transformConfig(ops ...config.TransformOperation) *config.DynamoDBTransformConfig {
+	return &config.DynamoDBTransformConfig{
+		Service:    "test",
+		Target:     &config.DynamoDBConfig{TableName: "items"},
+		Operations: ops,
+		Retry:      fastRetry,
+	}
+}
+
+func TestTransformKeyCollision(t *testing.T) {
+	chdirTemp(t)
+	items := newItems(10)
+	d := newTable("items", items)
+
+	// Only the first item can be moved to the key, and the others are failures instead of overwriting it
+	result, err := db.Transform(context.Background(), transformConfig(config.TransformOperation{Op: "set", Path: "pk", Value: "same"}), &db.Options{Connect: d.Connect})
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	if result.Written != 1 || result.Failed != 9 {
+		t.Fatalf("Transform() changed %d and failed %d items, want 1 and 9", result.Written, result.Failed)
+	}
+	for _, err := range result.Failures {
+		if !errors.Is(err, db.ErrKeyExists) {
+			t.Errorf("Transform() failure = %v, want an existing key", err)
+		}
+	}
+	if n := len(d.Items("items")); n != 10 {
+		t.Errorf("table has %d items, want 10", n)
+	}
+
+	// Entries of the failed items are journaled too, and they are failures of the rollback without changes
+	rollback, err := db.RollbackTransform(context.Background(), transformConfig(), result.Journal, &db.Options{Connect: d.Connect})
+	if err != nil {
+		t.Fatalf("RollbackTransform() error = %v", err)
+	}
+	if rollback.Written != 1 || rollback.Failed != 9 {
+		t.Errorf("RollbackTransform() restored %d and failed %d items, want 1 and 9", rollback.Written, rollback.Failed)
+	}
+	assertItems(t, d, "items", items)
+}
+
+func TestTransformLowerKey(t *testing.T) {
+	chdirTemp(t)
+	d := dbtest.New()
+	d.AddTable("items", "email", "")
+	user := func(email string, n int) map[string]*dynamodb.AttributeValue {
+		return map[string]*dynamodb.AttributeValue{
+			"email": {S: aws.String(email)},
+			"name":  {S: aws.String(fmt.Sprint(n))},
+		}
+	}
+	d.Put("items", user("a@x", 1), user("A@x", 2), user("B@x", 3))
+
+	cfg := transformConfig(config.TransformOperation{Op: "convert", Path: "email", To: "S", Format: "lower"})
+	result, err := db.Transform(context.Background(), cfg, &db.Options{Connect: d.Connect})
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	if result.Written != 1 || result.Failed != 1 || !errors.Is(result.Failures[0], db.ErrKeyExists) {
+		t.Errorf("Transform() changed %d and failed %d items with %v, want 1 and an existing key", result.Written, result.Failed, result.Failures)
+	}
+	// a@x isn't overwritten by A@x
+	assertItems(t, d, "items", []map[string]*dynamodb.AttributeValue{user("A@x", 2), user("a@x", 1), user("b@x", 3)})
+}
+
+func TestTransformConvertNumber(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantErr bool
+	}{
+		{"12", false},
+		{" -1.5e3 ", false},
+		{"NaN", true},
+		{"Inf", true},
+		{"+Inf", true},
+		{"0x1p-2", true},
+		{"1_000", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			chdirTemp(t)
+			items := newItems(1)
+			items[0]["count"] = &dynamodb.AttributeValue{S: aws.String(tt.value)}
+			d := newTable("items", items)
+
+			cfg := transformConfig(config.TransformOperation{Op: "convert", Path: "count", To: "N"})
+			result, err := db.Transform(context.Background(), cfg, &db.Options{Connect: d.Connect})
+			if err != nil {
+				t.Fatalf("Transform() error = %v", err)
+			}
+			if (result.Failed == 1) != tt.wantErr {
+				t.Fatalf("Transform() failed %d items, wantErr %v", result.Failed, tt.wantErr)
+			}
+			got := d.Items("items")[0]["count"]
+			if tt.wantErr && got.S == nil || !tt.wantErr && got.N == nil {
+				t.Errorf("count = %v", got)
+			}
+		})
+	}
+}
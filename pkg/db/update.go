@@ -0,0 +1,206 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// defaultUpdateWorkers is the number of UpdateItem calls at the same time
+const defaultUpdateWorkers = 25
+
+// updater updates items one by one, and retries throttled updates with backoff like batchWriter.
+// With a limiter, every call waits for write capacity.
+type updater struct {
+	db    Client
+	retry config.RetryConfig
+	limit *limiter
+	t     *tracker
+}
+
+func newUpdater(db Client, retry config.RetryConfig, limit *limiter, t *tracker) *updater {
+	return &updater{db: db, retry: retryDefaults(retry), limit: limit, t: t}
+}
+
+// update calls UpdateItem until it succeeds, or MaxAttempts calls are made.
+// A failed condition is returned as ErrItemChanged without retries.
+func (u *updater) update(ctx context.Context, in *dynamodb.UpdateItemInput) error {
+	if u.limit != nil {
+		in.ReturnConsumedCapacity = aws.String(dynamodb.ReturnConsumedCapacityTotal)
+	}
+	return u.call(ctx, "update item", func() ([]*dynamodb.ConsumedCapacity, error) {
+		o, err := u.db.UpdateItemWithContext(ctx, in, func(req *request.Request) {
+			req.Retryer = client.NoOpRetryer{}
+		})
+		if err != nil {
+			return nil, err
+		}
+		return []*dynamodb.ConsumedCapacity{o.ConsumedCapacity}, nil
+	}, func(err error) error {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return errors.Wrapf(ErrItemChanged, "key %s", itemKey(in.Key, attributeNames(in.Key)))
+		}
+		return nil
+	})
+}
+
+// transact calls TransactWriteItems until it succeeds, or MaxAttempts calls are made.
+// Transactions cancelled by conflicts with other writes are retried like throttled calls.
+// A failed condition of a Put is returned as ErrKeyExists, and the others as ErrItemChanged, without retries.
+func (u *updater) transact(ctx context.Context, in *dynamodb.TransactWriteItemsInput, keys []string) error {
+	if u.limit != nil {
+		in.ReturnConsumedCapacity = aws.String(dynamodb.ReturnConsumedCapacityTotal)
+	}
+	return u.call(ctx, "write transaction", func() ([]*dynamodb.ConsumedCapacity, error) {
+		o, err := u.db.TransactWriteItemsWithContext(ctx, in, func(req *request.Request) {
+			req.Retryer = client.NoOpRetryer{}
+		})
+		if err != nil {
+			return nil, err
+		}
+		return o.ConsumedCapacity, nil
+	}, func(err error) error {
+		canceled, ok := err.(*dynamodb.TransactionCanceledException)
+		if !ok {
+			return nil
+		}
+		for i, r := range canceled.CancellationReasons {
+			if aws.StringValue(r.Code) != "ConditionalCheckFailed" || i >= len(in.TransactItems) {
+				continue
+			}
+			switch item := in.TransactItems[i]; {
+			case item.Put != nil:
+				return errors.Wrapf(ErrKeyExists, "key %s", itemKey(item.Put.Item, keys))
+			case item.Delete != nil:
+				return errors.Wrapf(ErrItemChanged, "key %s", itemKey(item.Delete.Key, keys))
+			case item.ConditionCheck != nil:
+				return errors.Wrapf(ErrItemChanged, "key %s", itemKey(item.ConditionCheck.Key, keys))
+			}
+			return errors.Wrapf(ErrItemChanged, "item %d of transaction", i)
+		}
+		return nil
+	})
+}
+
+// call calls fn until it succeeds, or MaxAttempts calls are made. op names the call in errors.
+// failure returns the failure of an item for an error of fn, which is returned without retries, or nil.
+func (u *updater) call(ctx context.Context, op string, fn func() ([]*dynamodb.ConsumedCapacity, error), failure func(err error) error) error {
+	for attempt := 1; ; attempt++ {
+		if err := u.limit.wait(ctx); err != nil {
+			return err
+		}
+
+		consumed, err := fn()
+		if err == nil {
+			u.limit.take(consumedUnits(consumed...))
+			return nil
+		}
+		if ferr := failure(err); ferr != nil {
+			return ferr
+		}
+		if ctx.Err() != nil || !request.IsErrorRetryable(err) && !request.IsErrorThrottle(err) && !transactionConflict(err) {
+			return errors.Wrap(err, "failed to "+op)
+		}
+
+		if attempt == u.retry.MaxAttempts {
+			return errors.Wrap(fmt.Errorf("%w after %d attempts: %v", ErrMaxAttempts, attempt, err), "failed to "+op)
+		}
+		u.limit.throttled()
+		u.t.addRetry(request.IsErrorThrottle(err))
+
+		select {
+		case <-time.After(backoff(u.retry, attempt)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// transactionConflict returns true if a transaction was cancelled by other writes or throttling, so that it can be retried.
+func transactionConflict(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	switch aerr.Code() {
+	case dynamodb.ErrCodeTransactionConflictException, dynamodb.ErrCodeTransactionInProgressException:
+		return true
+	}
+	canceled, ok := err.(*dynamodb.TransactionCanceledException)
+	if !ok {
+		return false
+	}
+	for _, r := range canceled.CancellationReasons {
+		switch aws.StringValue(r.Code) {
+		case "TransactionConflict", "ThrottlingError", "ProvisionedThroughputExceeded":
+			return true
+		}
+	}
+	return false
+}
+
+// updateAll updates items by defaultUpdateWorkers at the same time, and calls updated with the number of updated items.
+// Items which were changed after they were read are failures of the tracker, and the others are updated.
+func (u *updater) updateAll(ctx context.Context, ins []*dynamodb.UpdateItemInput, updated func(n int)) error {
+	return u.all(ctx, len(ins), func(i int) error {
+		return u.update(ctx, ins[i])
+	}, updated)
+}
+
+// transactAll writes transactions like updateAll. Transactions whose conditions fail are failures of the tracker.
+func (u *updater) transactAll(ctx context.Context, ins []*dynamodb.TransactWriteItemsInput, keys []string, updated func(n int)) error {
+	return u.all(ctx, len(ins), func(i int) error {
+		return u.transact(ctx, ins[i], keys)
+	}, updated)
+}
+
+// all calls write with indexes from 0 to n by defaultUpdateWorkers at the same time.
+// ErrItemChanged and ErrKeyExists are failures of the tracker, and the first other error stops writes.
+func (u *updater) all(ctx context.Context, n int, write func(i int) error, updated func(n int)) error {
+	workers := defaultUpdateWorkers
+	if workers > n {
+		workers = n
+	}
+
+	ch := make(chan int, n)
+	for i := 0; i < n; i++ {
+		ch <- i
+	}
+	close(ch)
+
+	var (
+		wg   sync.WaitGroup
+		errs = make(chan error, workers)
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ch {
+				err := write(i)
+				if errors.Is(err, ErrItemChanged) || errors.Is(err, ErrKeyExists) {
+					u.t.fail(err)
+					continue
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+				updated(1)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	return <-errs
+}
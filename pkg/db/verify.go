@@ -0,0 +1,267 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// defaultMaxMismatches is the default number of mismatched items in VerifyResult.
+const defaultMaxMismatches = 100
+
+// defaultVerifyWorkers is the number of BatchGetItem calls at the same time
+const defaultVerifyWorkers = 4
+
+// VerifyResult is the result of the verification of a copy.
+type VerifyResult struct {
+	// OriginItems is the number of copied items, and TargetItems is the number of items in the target.
+	OriginItems int64
+	TargetItems int64
+	// WholeTable is true if the whole origin is copied, so that the counts are compared.
+	WholeTable bool
+	// Checked is the number of items read from the target to compare their hashes.
+	Checked         int64
+	MismatchedItems int64
+	// Mismatches are the first MaxMismatches mismatched items in the order of keys.
+	// They are DiffMissing if they are not in the target, or DiffChanged without attributes.
+	Mismatches []ItemDiff
+	Duration   time.Duration
+}
+
+// OK returns true if checked items are the same in the target, and the counts match.
+func (r *VerifyResult) OK() bool {
+	return r.MismatchedItems == 0 && (!r.WholeTable || r.OriginItems == r.TargetItems)
+}
+
+// verifyEntry is the key and the hash of a copied item.
+type verifyEntry struct {
+	key  map[string]*dynamodb.AttributeValue
+	hash [sha256.Size]byte
+}
+
+// verifySample holds hashes of copied items. With size, it keeps a random sample of size items.
+// It is safe for concurrent use.
+type verifySample struct {
+	mu      sync.Mutex
+	keys    []string
+	size    int
+	seen    int64
+	entries []verifyEntry
+}
+
+// newVerifySample returns a sample for the config, or nil if verify is disabled.
+func newVerifySample(cfg config.VerifyConfig, keys []string) *verifySample {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &verifySample{keys: keys, size: cfg.SampleSize}
+}
+
+// add adds copied items to the sample with reservoir sampling.
+func (s *verifySample) add(items []map[string]*dynamodb.AttributeValue) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, item := range items {
+		s.seen++
+		e := verifyEntry{key: keyOf(item, s.keys), hash: itemHash(item)}
+		if s.size < 1 || len(s.entries) < s.size {
+			s.entries = append(s.entries, e)
+			continue
+		}
+		if i := rand.Int63n(s.seen); i < int64(s.size) {
+			s.entries[i] = e
+		}
+	}
+}
+
+// verifyCopy counts items of the target, and reads the sampled items from it to compare their hashes.
+// originItems is the number of copied items. Retries of reads are counted by t.
+func verifyCopy(ctx context.Context, cfg *config.DynamoDBCopyConfig, targetDB Client, target *dynamodb.TableDescription, sample *verifySample, originItems int64, t *tracker) (*VerifyResult, error) {
+	start := time.Now()
+	keys := keyAttributes(target)
+	maxMismatches := cfg.Verify.MaxMismatches
+	if maxMismatches < 1 {
+		maxMismatches = defaultMaxMismatches
+	}
+	result := &VerifyResult{
+		OriginItems: originItems,
+		WholeTable:  cfg.Select.Filter.Expression == "" && cfg.Select.Index == "" && !cfg.Select.Query.Enabled(),
+	}
+
+	// Only keys are read to count items
+	r, err := newReader(targetDB, target, config.SelectConfig{Projection: keys}, cfg.Scan, 2500)
+	if err != nil {
+		return nil, err
+	}
+	var mu sync.Mutex
+	rl := readLimiter(cfg.Throughput, target)
+	err = r.read(ctx, nil, rl, func(segment int, items []map[string]*dynamodb.AttributeValue, lastKey map[string]*dynamodb.AttributeValue) error {
+		mu.Lock()
+		defer mu.Unlock()
+		result.TargetItems += int64(len(items))
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to count target items")
+	}
+
+	entries := make(map[string]verifyEntry, len(sample.entries))
+	var batch []map[string]*dynamodb.AttributeValue
+	var batches [][]map[string]*dynamodb.AttributeValue
+	for _, e := range sample.entries {
+		entries[itemKey(e.key, keys)] = e
+		batch = append(batch, e.key)
+		if len(batch) == 100 {
+			batches = append(batches, batch)
+			batch = nil
+		}
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+
+	var mismatches []ItemDiff
+	check := func(items []map[string]*dynamodb.AttributeValue) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, item := range items {
+			k := itemKey(item, keys)
+			e, ok := entries[k]
+			if !ok {
+				continue
+			}
+			delete(entries, k)
+			result.Checked++
+			if itemHash(item) != e.hash {
+				result.MismatchedItems++
+				mismatches = append(mismatches, ItemDiff{Kind: DiffChanged, Key: e.key})
+			}
+		}
+	}
+
+	g := &batchGetter{db: targetDB, table: cfg.Target.TableName, retry: retryDefaults(cfg.Retry), limit: rl, t: t}
+	if err := g.getAll(ctx, batches, cfg.Scan.Workers, check); err != nil {
+		return nil, errors.Wrap(err, "failed to read copied items")
+	}
+
+	// Keys which are not returned are missing in the target
+	for _, e := range entries {
+		result.Checked++
+		result.MismatchedItems++
+		mismatches = append(mismatches, ItemDiff{Kind: DiffMissing, Key: e.key})
+	}
+	sort.Slice(mismatches, func(i, j int) bool {
+		return itemKey(mismatches[i].Key, keys) < itemKey(mismatches[j].Key, keys)
+	})
+	if len(mismatches) > maxMismatches {
+		mismatches = mismatches[:maxMismatches]
+	}
+	result.Mismatches = mismatches
+	result.Duration = time.Since(start)
+	return result, nil
+}
+
+// batchGetter reads items of a table by their keys, and retries unprocessed keys like batchWriter.
+type batchGetter struct {
+	db    Client
+	table string
+	retry config.RetryConfig
+	limit *limiter
+	t     *tracker
+}
+
+// getAll reads batches of at most 100 keys by workers, and calls fn with read items.
+// fn must be safe to be called concurrently.
+func (g *batchGetter) getAll(ctx context.Context, batches [][]map[string]*dynamodb.AttributeValue, workers int, fn func(items []map[string]*dynamodb.AttributeValue)) error {
+	if workers < 1 {
+		workers = defaultVerifyWorkers
+	}
+
+	ch := make(chan []map[string]*dynamodb.AttributeValue, len(batches))
+	for _, b := range batches {
+		ch <- b
+	}
+	close(ch)
+
+	var (
+		wg   sync.WaitGroup
+		errs = make(chan error, workers)
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for keys := range ch {
+				if err := g.batchGet(ctx, keys, fn); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	return <-errs
+}
+
+// batchGet reads items of keys until there are no unprocessed keys, or MaxAttempts calls are made.
+// Items are read with strongly consistent reads, so that items written just before are read.
+func (g *batchGetter) batchGet(ctx context.Context, keys []map[string]*dynamodb.AttributeValue, fn func(items []map[string]*dynamodb.AttributeValue)) error {
+	r := map[string]*dynamodb.KeysAndAttributes{g.table: {Keys: keys, ConsistentRead: aws.Bool(true)}}
+	for attempt := 1; ; attempt++ {
+		input := &dynamodb.BatchGetItemInput{RequestItems: r}
+		if g.limit != nil {
+			input.ReturnConsumedCapacity = aws.String(dynamodb.ReturnConsumedCapacityTotal)
+		}
+		if err := g.limit.wait(ctx); err != nil {
+			return err
+		}
+
+		o, err := g.db.BatchGetItemWithContext(ctx, input, func(req *request.Request) {
+			req.Retryer = client.NoOpRetryer{}
+		})
+		if err != nil {
+			if ctx.Err() != nil || !request.IsErrorRetryable(err) && !request.IsErrorThrottle(err) {
+				return errors.Wrap(err, "failed to batch get items")
+			}
+		} else {
+			g.limit.take(consumedUnits(o.ConsumedCapacity...))
+			fn(o.Responses[g.table])
+			r = o.UnprocessedKeys
+			if r[g.table] == nil || len(r[g.table].Keys) == 0 {
+				return nil
+			}
+		}
+
+		if attempt == g.retry.MaxAttempts {
+			if err == nil {
+				err = errors.Errorf("%d keys are unprocessed", len(r[g.table].Keys))
+			}
+			return errors.Wrap(fmt.Errorf("%w after %d attempts: %v", ErrMaxAttempts, attempt, err), "failed to batch get items")
+		}
+		g.limit.throttled()
+		g.t.addRetry(request.IsErrorThrottle(err))
+
+		select {
+		case <-time.After(backoff(g.retry, attempt)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/config"
+	"github.com/daangn/dynamoutil/pkg/db"
+	"github.com/daangn/dynamoutil/pkg/db/dbtest"
+)
+
+// corruptingDB changes the name of item-003 and drops item-004 in BatchWriteItem calls,
+// like a target changed by someone else during a copy.
+type corruptingDB struct {
+	*dbtest.DB
+}
+
+func (d *corruptingDB) Connect(cfg *config.DynamoDBConfig) (db.Client, error) {
+	return d, nil
+}
+
+func (d *corruptingDB) BatchWriteItemWithContext(ctx aws.Context, input *dynamodb.BatchWriteItemInput, opts ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+	for table, wrs := range input.RequestItems {
+		var kept []*dynamodb.WriteRequest
+		for _, wr := range wrs {
+			switch aws.StringValue(wr.PutRequest.Item["pk"].S) {
+			case "item-003":
+				item := make(map[string]*dynamodb.AttributeValue)
+				for k, v := range wr.PutRequest.Item {
+					item[k] = v
+				}
+				item["name"] = &dynamodb.AttributeValue{S: aws.String("changed")}
+				kept = append(kept, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: item}})
+			case "item-004":
+			default:
+				kept = append(kept, wr)
+			}
+		}
+		input.RequestItems[table] = kept
+	}
+	return d.DB.BatchWriteItemWithContext(ctx, input, opts...)
+}
+
+func verifyConfig() *config.DynamoDBCopyConfig {
+	cfg := copyConfig()
+	cfg.Verify.Enabled = true
+	return cfg
+}
+
+func TestVerify(t *testing.T) {
+	chdirTemp(t)
+	d := newTable("origin", newItems(10))
+	d.AddTable("target", "pk", "")
+
+	result, err := db.Copy(context.Background(), verifyConfig(), &db.Options{Connect: d.Connect})
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	v := result.Verify
+	if v == nil || !v.OK() || v.Checked != 10 || v.OriginItems != 10 || v.TargetItems != 10 {
+		t.Errorf("Copy() verified %+v, want 10 checked items and matching counts", v)
+	}
+}
+
+func TestVerifyCountMismatch(t *testing.T) {
+	chdirTemp(t)
+	d := newTable("origin", newItems(10))
+	d.AddTable("target", "pk", "")
+	d.Put("target", map[string]*dynamodb.AttributeValue{"pk": {S: aws.String("extra")}})
+
+	result, err := db.Copy(context.Background(), verifyConfig(), &db.Options{Connect: d.Connect})
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	v := result.Verify
+	if v.OK() || v.MismatchedItems != 0 || v.OriginItems != 10 || v.TargetItems != 11 {
+		t.Errorf("Copy() verified %+v, want 10 and 11 items without mismatched items", v)
+	}
+}
+
+func TestVerifyHashMismatch(t *testing.T) {
+	chdirTemp(t)
+	d := &corruptingDB{DB: newTable("origin", newItems(10))}
+	d.AddTable("target", "pk", "")
+
+	cfg := verifyConfig()
+	cfg.Verify.MaxMismatches = 1
+	result, err := db.Copy(context.Background(), cfg, &db.Options{Connect: d.Connect})
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	v := result.Verify
+	if v.OK() || v.Checked != 10 || v.MismatchedItems != 2 {
+		t.Fatalf("Copy() verified %+v, want 2 of 10 mismatched items", v)
+	}
+	// Mismatches are sorted by keys, and cut to MaxMismatches
+	if len(v.Mismatches) != 1 || v.Mismatches[0].Kind != db.DiffChanged || aws.StringValue(v.Mismatches[0].Key["pk"].S) != "item-003" {
+		t.Errorf("Copy() mismatches = %+v, want changed item-003", v.Mismatches)
+	}
+}
+
+func TestVerifyMissingItems(t *testing.T) {
+	chdirTemp(t)
+	d := &corruptingDB{DB: newTable("origin", newItems(10))}
+	d.AddTable("target", "pk", "")
+
+	result, err := db.Copy(context.Background(), verifyConfig(), &db.Options{Connect: d.Connect})
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	v := result.Verify
+	if len(v.Mismatches) != 2 || v.Mismatches[1].Kind != db.DiffMissing || aws.StringValue(v.Mismatches[1].Key["pk"].S) != "item-004" {
+		t.Errorf("Copy() mismatches = %+v, want missing item-004", v.Mismatches)
+	}
+	if v.OriginItems != 10 || v.TargetItems != 9 {
+		t.Errorf("Copy() counted %d and %d items, want 10 and 9", v.OriginItems, v.TargetItems)
+	}
+}
+
+func TestVerifyRetries(t *testing.T) {
+	chdirTemp(t)
+	d := newTable("origin", newItems(10))
+	d.AddTable("target", "pk", "")
+	d.MaxBatchGet = 3
+
+	result, err := db.Copy(context.Background(), verifyConfig(), &db.Options{Connect: d.Connect})
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	// Every BatchGetItem call but the last returns unprocessed keys
+	if result.Retries != 3 || result.Throttles != 0 {
+		t.Errorf("Copy() retried %d times with %d throttles, want 3 and 0", result.Retries, result.Throttles)
+	}
+	if !result.Verify.OK() || result.Verify.Checked != 10 {
+		t.Errorf("Copy() verified %+v, want 10 checked items", result.Verify)
+	}
+}
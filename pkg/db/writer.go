@@ -0,0 +1,292 @@
+package db
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/daangn/dynamoutil/pkg/config"
+	"github.com/daangn/dynamoutil/pkg/util"
+	"github.com/pkg/errors"
+)
+
+// Column inference and order constants for csv and tsv outputs
+const (
+	inferenceSample  = "sample"
+	inferenceTwoPass = "twoPass"
+
+	columnOrderSorted    = "sorted"
+	columnOrderFirstSeen = "firstSeen"
+
+	defaultSampleSize = 1000
+)
+
+// itemWriter writes dumped items in the output format.
+// It is not safe to be called concurrently.
+type itemWriter interface {
+	Write(item map[string]*dynamodb.AttributeValue) error
+	// Close writes the rest of the output, but doesn't close the underlying writer.
+	Close() error
+}
+
+// jsonWriter writes items separated by delimiter of the output.
+type jsonWriter struct {
+	w     io.Writer
+	cfg   *config.DynamoDBDumpConfig
+	first bool
+}
+
+// newJSONWriter writes the prefix of the output, or appends items with resume.
+func newJSONWriter(w io.Writer, cfg *config.DynamoDBDumpConfig, resume *outputState) (*jsonWriter, error) {
+	if resume != nil {
+		return &jsonWriter{w: w, cfg: cfg, first: resume.Items == 0}, nil
+	}
+
+	if _, err := w.Write(cfg.Output.DumpPrefix()); err != nil {
+		return nil, err
+	}
+	return &jsonWriter{w: w, cfg: cfg, first: true}, nil
+}
+
+func (w *jsonWriter) Write(item map[string]*dynamodb.AttributeValue) error {
+	b, err := encodeItem(item, w.cfg)
+	if err != nil {
+		return err
+	}
+
+	if !w.first {
+		if _, err := w.w.Write(w.cfg.Output.DumpDelimiter()); err != nil {
+			return err
+		}
+	}
+	w.first = false
+
+	_, err = w.w.Write(b)
+	return err
+}
+
+func (w *jsonWriter) Close() error {
+	_, err := w.w.Write(w.cfg.Output.DumpSuffix())
+	return err
+}
+
+// encodeItem marshals dynamodb item to a line of the output.
+func encodeItem(item map[string]*dynamodb.AttributeValue, cfg *config.DynamoDBDumpConfig) ([]byte, error) {
+	switch cfg.Output {
+	case config.OutputDynamoDBJSON:
+		return json.Marshal(map[string]interface{}{"Item": util.TypedDynamo(item)})
+	case config.OutputAttributeValue:
+		return json.Marshal(util.TypedDynamo(item))
+	}
+
+	b, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+
+	var jsonItem map[string]interface{}
+	if err := json.Unmarshal(b, &jsonItem); err != nil {
+		return nil, err
+	}
+
+	marshaled, err := util.MarshalDynamoWithOptions(jsonItem, cfg.MarshalOptions())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal dynamodb object")
+	}
+	return json.Marshal(marshaled)
+}
+
+// csvWriter writes items as rows of csv or tsv with a header of columns.
+type csvWriter struct {
+	w       *csv.Writer
+	cfg     *config.DynamoDBDumpConfig
+	columns []string
+	known   map[string]bool
+}
+
+// newCSVWriter writes the header of columns, or appends rows with resume.
+func newCSVWriter(w io.Writer, cfg *config.DynamoDBDumpConfig, columns []string, resume *outputState) (*csvWriter, error) {
+	cw := &csvWriter{
+		w:       csv.NewWriter(w),
+		cfg:     cfg,
+		columns: columns,
+		known:   make(map[string]bool, len(columns)),
+	}
+	for _, column := range columns {
+		cw.known[column] = true
+	}
+	if cfg.Output == config.OutputTSV {
+		cw.w.Comma = '\t'
+	}
+	if resume != nil {
+		return cw, nil
+	}
+
+	if err := cw.w.Write(columns); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (w *csvWriter) Write(item map[string]*dynamodb.AttributeValue) error {
+	return w.writeRow(item)
+}
+
+func (w *csvWriter) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+func (w *csvWriter) Close() error {
+	return w.Flush()
+}
+
+// writeRow writes a row of the item. An item which has an attribute out of the columns isn't written,
+// and the error makes it a failure of the dump instead of a row without the attribute.
+func (w *csvWriter) writeRow(item map[string]*dynamodb.AttributeValue) error {
+	for _, name := range attributeNames(item) {
+		if !w.known[name] && item[name].NULL == nil {
+			return errors.Errorf("attribute %s is not in the columns", name)
+		}
+	}
+
+	row := make([]string, 0, len(w.columns))
+	for _, column := range w.columns {
+		cell, err := w.cell(item[column])
+		if err != nil {
+			return errors.Wrapf(err, "invalid attribute %s", column)
+		}
+		row = append(row, cell)
+	}
+	return w.w.Write(row)
+}
+
+// cell returns a string of scalar attributes, and JSON of sets, lists and maps.
+func (w *csvWriter) cell(av *dynamodb.AttributeValue) (string, error) {
+	switch {
+	case av == nil, av.NULL != nil:
+		return w.cfg.CSV.Null, nil
+	case av.S != nil:
+		return *av.S, nil
+	case av.N != nil:
+		return *av.N, nil
+	case av.BOOL != nil:
+		return strconv.FormatBool(*av.BOOL), nil
+	}
+
+	v, err := flattenValue(av, w.cfg.MarshalOptions())
+	if err != nil {
+		return "", err
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// flattenValue makes flatten dynamodb.AttributeValue with util.MarshalDynamoValueWithOptions.
+func flattenValue(av *dynamodb.AttributeValue, opts *util.MarshalOptions) (interface{}, error) {
+	b, err := json.Marshal(av)
+	if err != nil {
+		return nil, err
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	return util.MarshalDynamoValueWithOptions(v, opts)
+}
+
+// attributeNames returns top-level attribute names of the item in sorted order.
+func attributeNames(item map[string]*dynamodb.AttributeValue) []string {
+	names := make([]string, 0, len(item))
+	for name := range item {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// orderColumns puts keys first, and the rest of names in the order.
+func orderColumns(keys, names []string, order string) []string {
+	columns := make([]string, 0, len(names))
+	isKey := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		isKey[key] = true
+		columns = append(columns, key)
+	}
+
+	rest := make([]string, 0, len(names))
+	for _, name := range names {
+		if !isKey[name] {
+			rest = append(rest, name)
+		}
+	}
+	if order != columnOrderFirstSeen {
+		sort.Strings(rest)
+	}
+	return append(columns, rest...)
+}
+
+// samplingWriter buffers items until size, and opens the next writer with them.
+// It is used to infer a schema from the first items.
+type samplingWriter struct {
+	size   int
+	sample []map[string]*dynamodb.AttributeValue
+	open   func(sample []map[string]*dynamodb.AttributeValue) (itemWriter, error)
+	w      itemWriter
+}
+
+func (w *samplingWriter) Write(item map[string]*dynamodb.AttributeValue) error {
+	if w.w != nil {
+		return w.w.Write(item)
+	}
+
+	w.sample = append(w.sample, item)
+	if len(w.sample) < w.size {
+		return nil
+	}
+	return w.flush()
+}
+
+// Sync syncs the next writer. Buffered items can't be synced before it is opened.
+func (w *samplingWriter) Sync(state *outputState) (bool, error) {
+	if next, ok := w.w.(resumableWriter); ok {
+		return next.Sync(state)
+	}
+	return false, nil
+}
+
+func (w *samplingWriter) Close() error {
+	if w.w == nil {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+	return w.w.Close()
+}
+
+func (w *samplingWriter) flush() error {
+	next, err := w.open(w.sample)
+	if err != nil {
+		return err
+	}
+	w.w = next
+
+	for _, item := range w.sample {
+		if err := next.Write(item); err != nil {
+			return err
+		}
+	}
+	w.sample = nil
+	return nil
+}
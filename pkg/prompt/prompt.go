@@ -0,0 +1,63 @@
+package prompt
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// AssumeYesEnv is the environment variable to answer yes to every confirmation
+const AssumeYesEnv = "DYNAMOUTIL_ASSUME_YES"
+
+// AssumeYes answers yes to Confirm without reading stdin.
+// It is set by --yes flag or DYNAMOUTIL_ASSUME_YES.
+var AssumeYes bool
+
+// ErrNotTerminal is returned when a question is asked but stdin is not a terminal.
+var ErrNotTerminal = errors.New("stdin is not a terminal")
+
+// Confirm prints the question and reads the answer from stdin.
+// Only a literal "Y" is yes. With AssumeYes, the question is answered without reading stdin.
+func Confirm(question string) (bool, error) {
+	if AssumeYes {
+		fmt.Println(question + "Y")
+		return true, nil
+	}
+
+	ok, err := Ask(question)
+	if errors.Is(err, ErrNotTerminal) {
+		return false, errors.Wrap(err, "can't confirm without --yes")
+	}
+	return ok, err
+}
+
+// Ask prints the question and reads the answer from stdin even with AssumeYes.
+// It fails instead of hanging when stdin is not a terminal.
+func Ask(question string) (bool, error) {
+	if !isTerminal(os.Stdin) {
+		return false, ErrNotTerminal
+	}
+
+	fmt.Print(question)
+	yn, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false, errors.Wrap(err, "failed to read the answer")
+	}
+	return strings.Trim(yn, "\n") == "Y", nil
+}
+
+// isTerminal reports whether f is a character device other than the null device.
+// Cron and CI usually give /dev/null or a pipe to stdin.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	if null, err := os.Stat(os.DevNull); err == nil && os.SameFile(fi, null) {
+		return false
+	}
+	return true
+}
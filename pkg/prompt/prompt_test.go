@@ -0,0 +1,84 @@
+package prompt
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// withStdin replaces stdin with f and AssumeYes with assumeYes until the test ends.
+func withStdin(t *testing.T, f *os.File, assumeYes bool) {
+	t.Helper()
+
+	stdin, yes := os.Stdin, AssumeYes
+	os.Stdin, AssumeYes = f, assumeYes
+	t.Cleanup(func() {
+		os.Stdin, AssumeYes = stdin, yes
+	})
+}
+
+// pipe returns the reader of a pipe with the input written, like stdin of CI.
+func pipe(t *testing.T, input string) *os.File {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString(input)
+	w.Close()
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func TestAskWithoutTerminal(t *testing.T) {
+	null, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer null.Close()
+	file, err := ioutil.TempFile("", "stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	for name, f := range map[string]*os.File{"pipe": pipe(t, "Y\n"), "null": null, "file": file} {
+		t.Run(name, func(t *testing.T) {
+			// Ask doesn't read the answer even with AssumeYes
+			for _, yes := range []bool{false, true} {
+				withStdin(t, f, yes)
+				ok, err := Ask("Continue? ")
+				if ok || !errors.Is(err, ErrNotTerminal) {
+					t.Errorf("Ask() with AssumeYes %v = %v, %v, want ErrNotTerminal", yes, ok, err)
+				}
+			}
+		})
+	}
+}
+
+func TestConfirmWithoutTerminal(t *testing.T) {
+	withStdin(t, pipe(t, "Y\n"), false)
+
+	ok, err := Confirm("Continue? ")
+	if ok || !errors.Is(err, ErrNotTerminal) {
+		t.Fatalf("Confirm() = %v, %v, want ErrNotTerminal", ok, err)
+	}
+	if !strings.Contains(err.Error(), "--yes") {
+		t.Errorf("Confirm() error = %v, want a hint of --yes", err)
+	}
+}
+
+func TestConfirmAssumeYes(t *testing.T) {
+	// The answer isn't read, so an empty stdin doesn't fail
+	withStdin(t, pipe(t, ""), true)
+
+	ok, err := Confirm("Continue? ")
+	if !ok || err != nil {
+		t.Errorf("Confirm() with AssumeYes = %v, %v, want true", ok, err)
+	}
+}
@@ -0,0 +1,297 @@
+package util
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/pkg/errors"
+)
+
+// DynamoDB attribute types which can be forced by UnmarshalOptions.Types
+const (
+	TypeS    = "S"
+	TypeN    = "N"
+	TypeB    = "B"
+	TypeBOOL = "BOOL"
+	TypeNULL = "NULL"
+	TypeSS   = "SS"
+	TypeNS   = "NS"
+	TypeBS   = "BS"
+	TypeL    = "L"
+	TypeM    = "M"
+)
+
+// numberRegexp matches strings which can be stored as N without losing information.
+// Leading zeros are not allowed since DynamoDB drops them.
+var numberRegexp = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// IsNumber returns true if s can be stored as N without losing information.
+// NaN, Inf and hex numbers are not DynamoDB numbers.
+func IsNumber(s string) bool {
+	return numberRegexp.MatchString(s)
+}
+
+// UnmarshalOptions represents rules to restore DynamoDB types from flattened values.
+// The zero value converts JSON numbers to N, strings to S, arrays to L and objects to M.
+type UnmarshalOptions struct {
+	// NumericStrings converts strings which look like numbers to N instead of S.
+	// MarshalDynamo writes every N as a string, so this restores them.
+	NumericStrings bool
+	// NumbersAsStrings converts JSON numbers to S instead of N.
+	NumbersAsStrings bool
+	// Sets converts non-empty arrays of unique strings or numbers to SS or NS instead of L.
+	// With BinaryWrapped, arrays of unique wrapper objects are converted to BS.
+	Sets bool
+	// Types forces the type of attributes by their path.
+	// A path is a dot separated attribute name of nested maps, and "[]" means elements of a list.
+	// e.g. {"tags": "SS", "profile.avatar": "B", "history[].at": "N"}
+	// B and BS are decoded with Binary.
+	Types map[string]string
+	// Binary is the encoding of binary attributes.
+	// With BinaryWrapped, wrapper objects are restored to B even if their types are not forced.
+	Binary BinaryEncoding
+}
+
+// UnmarshalDynamo makes dynamodb.Item from flattened item.
+// This is the inverse of MarshalDynamo.
+func UnmarshalDynamo(item interface{}, opts *UnmarshalOptions) (map[string]*dynamodb.AttributeValue, error) {
+	if opts == nil {
+		opts = &UnmarshalOptions{}
+	}
+
+	converted, ok := item.(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("invalid data to unmarshal. item=%v", item)
+	}
+
+	result := make(map[string]*dynamodb.AttributeValue, len(converted))
+	for k, v := range converted {
+		av, err := UnmarshalDynamoValue(v, k, opts)
+		if err != nil {
+			return nil, err
+		}
+		result[k] = av
+	}
+	return result, nil
+}
+
+// UnmarshalDynamoValue makes dynamodb.AttributeValue from flattened value at the path.
+func UnmarshalDynamoValue(v interface{}, path string, opts *UnmarshalOptions) (*dynamodb.AttributeValue, error) {
+	if opts == nil {
+		opts = &UnmarshalOptions{}
+	}
+
+	if t, ok := opts.Types[path]; ok {
+		av, err := unmarshalTyped(v, t, path, opts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal %s as %s", path, t)
+		}
+		return av, nil
+	}
+
+	switch v := v.(type) {
+	case nil:
+		return &dynamodb.AttributeValue{NULL: aws.Bool(true)}, nil
+	case bool:
+		return &dynamodb.AttributeValue{BOOL: aws.Bool(v)}, nil
+	case json.Number, float64:
+		n, _ := numberString(v)
+		if opts.NumbersAsStrings {
+			return &dynamodb.AttributeValue{S: aws.String(n)}, nil
+		}
+		return &dynamodb.AttributeValue{N: aws.String(n)}, nil
+	case string:
+		if opts.NumericStrings && numberRegexp.MatchString(v) {
+			return &dynamodb.AttributeValue{N: aws.String(v)}, nil
+		}
+		return &dynamodb.AttributeValue{S: aws.String(v)}, nil
+	case []interface{}:
+		if opts.Sets {
+			if av, ok := unmarshalSet(v, opts); ok {
+				return av, nil
+			}
+		}
+		return unmarshalList(v, path, opts)
+	case map[string]interface{}:
+		if opts.Binary == BinaryWrapped {
+			if _, ok := unwrapBinary(v); ok {
+				b, err := decodeBinary(v, opts.Binary)
+				if err != nil {
+					return nil, errors.Wrapf(err, "invalid binary at %s", path)
+				}
+				return &dynamodb.AttributeValue{B: b}, nil
+			}
+		}
+		return unmarshalMap(v, path, opts)
+	default:
+		return nil, errors.Errorf("unsupported value at %s: %v", path, v)
+	}
+}
+
+func unmarshalTyped(v interface{}, t, path string, opts *UnmarshalOptions) (*dynamodb.AttributeValue, error) {
+	switch t {
+	case TypeS:
+		if s, ok := v.(string); ok {
+			return &dynamodb.AttributeValue{S: aws.String(s)}, nil
+		}
+		if n, ok := numberString(v); ok {
+			return &dynamodb.AttributeValue{S: aws.String(n)}, nil
+		}
+	case TypeN:
+		if n, ok := numberString(v); ok {
+			return &dynamodb.AttributeValue{N: aws.String(n)}, nil
+		}
+	case TypeB:
+		b, err := decodeBinary(v, opts.Binary)
+		if err != nil {
+			return nil, err
+		}
+		return &dynamodb.AttributeValue{B: b}, nil
+	case TypeBOOL:
+		if b, ok := v.(bool); ok {
+			return &dynamodb.AttributeValue{BOOL: aws.Bool(b)}, nil
+		}
+	case TypeNULL:
+		return &dynamodb.AttributeValue{NULL: aws.Bool(true)}, nil
+	case TypeSS, TypeNS, TypeBS:
+		l, ok := v.([]interface{})
+		if !ok {
+			break
+		}
+
+		av := &dynamodb.AttributeValue{}
+		for _, e := range l {
+			switch t {
+			case TypeSS:
+				s, ok := e.(string)
+				if !ok {
+					return nil, errors.Errorf("%v is not a string", e)
+				}
+				av.SS = append(av.SS, aws.String(s))
+			case TypeNS:
+				n, ok := numberString(e)
+				if !ok {
+					return nil, errors.Errorf("%v is not a number", e)
+				}
+				av.NS = append(av.NS, aws.String(n))
+			case TypeBS:
+				b, err := decodeBinary(e, opts.Binary)
+				if err != nil {
+					return nil, err
+				}
+				av.BS = append(av.BS, b)
+			}
+		}
+		return av, nil
+	case TypeL:
+		if l, ok := v.([]interface{}); ok {
+			return unmarshalList(l, path, opts)
+		}
+	case TypeM:
+		if m, ok := v.(map[string]interface{}); ok {
+			return unmarshalMap(m, path, opts)
+		}
+	default:
+		return nil, errors.New("unsupported type")
+	}
+	return nil, errors.Errorf("unexpected value: %v", v)
+}
+
+func unmarshalList(l []interface{}, path string, opts *UnmarshalOptions) (*dynamodb.AttributeValue, error) {
+	r := make([]*dynamodb.AttributeValue, 0, len(l))
+	for _, e := range l {
+		av, err := UnmarshalDynamoValue(e, path+"[]", opts)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, av)
+	}
+	return &dynamodb.AttributeValue{L: r}, nil
+}
+
+func unmarshalMap(m map[string]interface{}, path string, opts *UnmarshalOptions) (*dynamodb.AttributeValue, error) {
+	r := make(map[string]*dynamodb.AttributeValue, len(m))
+	for k, e := range m {
+		av, err := UnmarshalDynamoValue(e, path+"."+k, opts)
+		if err != nil {
+			return nil, err
+		}
+		r[k] = av
+	}
+	return &dynamodb.AttributeValue{M: r}, nil
+}
+
+// unmarshalSet returns SS or NS if every element is a unique string, or a unique number.
+func unmarshalSet(l []interface{}, opts *UnmarshalOptions) (*dynamodb.AttributeValue, bool) {
+	if len(l) == 0 {
+		return nil, false
+	}
+
+	var ss, ns []*string
+	var bs [][]byte
+	seen := make(map[string]bool, len(l))
+	for _, e := range l {
+		var s string
+		switch e := e.(type) {
+		case map[string]interface{}:
+			if opts.Binary != BinaryWrapped {
+				return nil, false
+			}
+			b, err := decodeBinary(e, opts.Binary)
+			if err != nil {
+				return nil, false
+			}
+			s = string(b)
+			bs = append(bs, b)
+		case string:
+			s = e
+			if opts.NumericStrings && numberRegexp.MatchString(e) {
+				ns = append(ns, aws.String(e))
+			} else {
+				ss = append(ss, aws.String(e))
+			}
+		case json.Number, float64:
+			s, _ = numberString(e)
+			if opts.NumbersAsStrings {
+				ss = append(ss, aws.String(s))
+			} else {
+				ns = append(ns, aws.String(s))
+			}
+		default:
+			return nil, false
+		}
+
+		if seen[s] {
+			return nil, false
+		}
+		seen[s] = true
+	}
+
+	switch {
+	case len(ss) == len(l):
+		return &dynamodb.AttributeValue{SS: ss}, true
+	case len(ns) == len(l):
+		return &dynamodb.AttributeValue{NS: ns}, true
+	case len(bs) == len(l):
+		return &dynamodb.AttributeValue{BS: bs}, true
+	default:
+		return nil, false
+	}
+}
+
+// numberString returns a DynamoDB number from JSON number or numeric string.
+func numberString(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case json.Number:
+		return v.String(), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case string:
+		return v, numberRegexp.MatchString(v)
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,217 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// roundTrip flattens the item with MarshalDynamo like dump, and restores it with UnmarshalDynamo like load.
+func roundTrip(t *testing.T, item map[string]*dynamodb.AttributeValue, marshal *MarshalOptions, unmarshal *UnmarshalOptions) map[string]*dynamodb.AttributeValue {
+	t.Helper()
+
+	b, err := json.Marshal(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jsonItem map[string]interface{}
+	if err := json.Unmarshal(b, &jsonItem); err != nil {
+		t.Fatal(err)
+	}
+	flattened, err := MarshalDynamoWithOptions(jsonItem, marshal)
+	if err != nil {
+		t.Fatalf("MarshalDynamoWithOptions() error = %v", err)
+	}
+	if b, err = json.Marshal(flattened); err != nil {
+		t.Fatal(err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := UnmarshalDynamo(v, unmarshal)
+	if err != nil {
+		t.Fatalf("UnmarshalDynamo(%s) error = %v", b, err)
+	}
+	return restored
+}
+
+func TestUnmarshalDynamoRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     *dynamodb.AttributeValue
+		marshal   *MarshalOptions
+		unmarshal *UnmarshalOptions
+	}{
+		{
+			name:  "S",
+			value: &dynamodb.AttributeValue{S: aws.String("hello")},
+		},
+		{
+			name:      "N",
+			value:     &dynamodb.AttributeValue{N: aws.String("-12.5")},
+			unmarshal: &UnmarshalOptions{NumericStrings: true},
+		},
+		{
+			name:      "N with type",
+			value:     &dynamodb.AttributeValue{N: aws.String("1E+3")},
+			unmarshal: &UnmarshalOptions{Types: map[string]string{"v": TypeN}},
+		},
+		{
+			name:      "B base64",
+			value:     &dynamodb.AttributeValue{B: []byte{0, 1, 0xfe}},
+			unmarshal: &UnmarshalOptions{Types: map[string]string{"v": TypeB}},
+		},
+		{
+			name:      "B hex",
+			value:     &dynamodb.AttributeValue{B: []byte("hi")},
+			marshal:   &MarshalOptions{Binary: BinaryHex},
+			unmarshal: &UnmarshalOptions{Types: map[string]string{"v": TypeB}, Binary: BinaryHex},
+		},
+		{
+			name:      "B wrapped",
+			value:     &dynamodb.AttributeValue{B: []byte("hi")},
+			marshal:   &MarshalOptions{Binary: BinaryWrapped},
+			unmarshal: &UnmarshalOptions{Binary: BinaryWrapped},
+		},
+		{
+			name:  "BOOL",
+			value: &dynamodb.AttributeValue{BOOL: aws.Bool(false)},
+		},
+		{
+			name:    "NULL",
+			value:   &dynamodb.AttributeValue{NULL: aws.Bool(true)},
+			marshal: &MarshalOptions{KeepNull: true},
+		},
+		{
+			name:      "SS",
+			value:     &dynamodb.AttributeValue{SS: aws.StringSlice([]string{"a", "b"})},
+			unmarshal: &UnmarshalOptions{Sets: true},
+		},
+		{
+			name:      "NS",
+			value:     &dynamodb.AttributeValue{NS: aws.StringSlice([]string{"1", "2.5"})},
+			unmarshal: &UnmarshalOptions{Sets: true, NumericStrings: true},
+		},
+		{
+			name:      "BS",
+			value:     &dynamodb.AttributeValue{BS: [][]byte{[]byte("a"), []byte("b")}},
+			marshal:   &MarshalOptions{Binary: BinaryWrapped},
+			unmarshal: &UnmarshalOptions{Sets: true, Binary: BinaryWrapped},
+		},
+		{
+			name:      "BS with type",
+			value:     &dynamodb.AttributeValue{BS: [][]byte{[]byte("a"), []byte("b")}},
+			unmarshal: &UnmarshalOptions{Types: map[string]string{"v": TypeBS}},
+		},
+		{
+			name: "L",
+			value: &dynamodb.AttributeValue{L: []*dynamodb.AttributeValue{
+				{S: aws.String("a")},
+				{N: aws.String("1")},
+				{BOOL: aws.Bool(true)},
+				{NULL: aws.Bool(true)},
+				{L: []*dynamodb.AttributeValue{{S: aws.String("nested")}}},
+			}},
+			marshal:   &MarshalOptions{KeepNull: true},
+			unmarshal: &UnmarshalOptions{NumericStrings: true},
+		},
+		{
+			name: "M",
+			value: &dynamodb.AttributeValue{M: map[string]*dynamodb.AttributeValue{
+				"name":   {S: aws.String("a")},
+				"age":    {N: aws.String("3")},
+				"avatar": {B: []byte("png")},
+				"none":   {NULL: aws.Bool(true)},
+				"tags":   {SS: aws.StringSlice([]string{"x"})},
+				"history": {L: []*dynamodb.AttributeValue{
+					{M: map[string]*dynamodb.AttributeValue{"at": {N: aws.String("100")}}},
+				}},
+			}},
+			marshal: &MarshalOptions{KeepNull: true},
+			unmarshal: &UnmarshalOptions{Types: map[string]string{
+				"v.age":          TypeN,
+				"v.avatar":       TypeB,
+				"v.tags":         TypeSS,
+				"v.history[].at": TypeN,
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := map[string]*dynamodb.AttributeValue{"v": tt.value}
+			got := roundTrip(t, item, tt.marshal, tt.unmarshal)
+			if !reflect.DeepEqual(got, item) {
+				t.Errorf("round trip = %v, want %v", got, item)
+			}
+		})
+	}
+}
+
+func TestUnmarshalDynamoNumbers(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+		opts *UnmarshalOptions
+		want *dynamodb.AttributeValue
+	}{
+		{"number", json.Number("1.5"), nil, &dynamodb.AttributeValue{N: aws.String("1.5")}},
+		{"number as string", json.Number("1.5"), &UnmarshalOptions{NumbersAsStrings: true}, &dynamodb.AttributeValue{S: aws.String("1.5")}},
+		{"numeric string", "15", nil, &dynamodb.AttributeValue{S: aws.String("15")}},
+		{"leading zero", "007", &UnmarshalOptions{NumericStrings: true}, &dynamodb.AttributeValue{S: aws.String("007")}},
+		{"duplicated set", []interface{}{"a", "a"}, &UnmarshalOptions{Sets: true}, &dynamodb.AttributeValue{L: []*dynamodb.AttributeValue{
+			{S: aws.String("a")}, {S: aws.String("a")},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := UnmarshalDynamoValue(tt.v, "v", tt.opts)
+			if err != nil {
+				t.Fatalf("UnmarshalDynamoValue() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("UnmarshalDynamoValue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalDynamoInvalid(t *testing.T) {
+	if _, err := UnmarshalDynamo([]interface{}{}, nil); err == nil {
+		t.Error("UnmarshalDynamo() of an array succeeded")
+	}
+	if _, err := UnmarshalDynamoValue("x", "v", &UnmarshalOptions{Types: map[string]string{"v": TypeN}}); err == nil {
+		t.Error("UnmarshalDynamoValue() of a string as N succeeded")
+	}
+}
+
+func TestMarshalDynamoNull(t *testing.T) {
+	null := map[string]interface{}{"NULL": true}
+	tests := []struct {
+		name string
+		opts *MarshalOptions
+		want interface{}
+	}{
+		// true is kept by default, so that dumps of earlier versions don't change
+		{"default", nil, true},
+		{"keep null", &MarshalOptions{KeepNull: true}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MarshalDynamoValueWithOptions(null, tt.opts)
+			if err != nil {
+				t.Fatalf("MarshalDynamoValueWithOptions() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("MarshalDynamoValueWithOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
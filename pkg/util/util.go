@@ -1,23 +1,89 @@
 package util
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 )
 
+// BinaryEncoding represents how binary attributes are flattened
+type BinaryEncoding string
+
+// BinaryEncoding constants
+const (
+	BinaryBase64 BinaryEncoding = "base64"
+	BinaryHex    BinaryEncoding = "hex"
+	// BinaryWrapped writes a base64 string wrapped with an object. e.g. {"$binary": "aGk="}
+	BinaryWrapped BinaryEncoding = "wrapped"
+)
+
+// BinaryWrapperKey is the key of the object wrapping binary with BinaryWrapped
+const BinaryWrapperKey = "$binary"
+
+// DefaultBinaryEncoding represents the default binary encoding
+var DefaultBinaryEncoding = BinaryBase64
+
+// MarshalOptions represents rules to flatten dynamodb.AttributeValue
+type MarshalOptions struct {
+	Binary BinaryEncoding
+	// KeepNull flattens NULL to JSON null instead of true, so that UnmarshalDynamo restores it to NULL
+	KeepNull bool
+}
+
 // MarshalDynamoValue make flatten dynamodb.AttributeValue
 func MarshalDynamoValue(item interface{}) (interface{}, error) {
+	return MarshalDynamoValueWithOptions(item, nil)
+}
+
+// MarshalDynamoValueWithOptions make flatten dynamodb.AttributeValue with opts
+func MarshalDynamoValueWithOptions(item interface{}, opts *MarshalOptions) (interface{}, error) {
+	if opts == nil {
+		opts = &MarshalOptions{}
+	}
+
 	var result interface{}
 	for k, v := range item.(map[string]interface{}) {
 		if v == nil {
 			continue
 		}
 		switch k {
-		case "N", "BOOL", "S", "BS", "NULL", "NS", "SS":
+		case "N", "BOOL", "S", "NS", "SS":
 			result = v
 			break
+		case "NULL":
+			// true is loaded as BOOL, so JSON null is written to load it as NULL
+			result = v
+			if opts.KeepNull {
+				result = nil
+			}
+			break
+		case "B":
+			b, err := encodeBinary(v, opts.Binary)
+			if err != nil {
+				return nil, err
+			}
+			result = b
+			break
+		case "BS":
+			l, ok := v.([]interface{})
+			if !ok {
+				return nil, errors.Errorf("invalid binary set. item=%v", item)
+			}
+
+			r := make([]interface{}, 0, len(l))
+			for i := range l {
+				b, err := encodeBinary(l[i], opts.Binary)
+				if err != nil {
+					return nil, err
+				}
+				r = append(r, b)
+			}
+			result = r
+			break
 		case "L":
 			var l []map[string]interface{}
 			bytes, err := json.Marshal(v)
@@ -33,7 +99,7 @@ func MarshalDynamoValue(item interface{}) (interface{}, error) {
 
 			r := make([]interface{}, 0, len(l))
 			for i := range l {
-				v, err := MarshalDynamoValue(l[i])
+				v, err := MarshalDynamoValueWithOptions(l[i], opts)
 				if err != nil {
 					return nil, err
 				}
@@ -42,7 +108,7 @@ func MarshalDynamoValue(item interface{}) (interface{}, error) {
 			result = r
 			break
 		case "M":
-			r, err := MarshalDynamo(v)
+			r, err := MarshalDynamoWithOptions(v, opts)
 			if err != nil {
 				return nil, err
 			}
@@ -58,6 +124,11 @@ func MarshalDynamoValue(item interface{}) (interface{}, error) {
 
 // MarshalDynamo make flatten dynamodb.Item
 func MarshalDynamo(item interface{}) (interface{}, error) {
+	return MarshalDynamoWithOptions(item, nil)
+}
+
+// MarshalDynamoWithOptions make flatten dynamodb.Item with opts
+func MarshalDynamoWithOptions(item interface{}, opts *MarshalOptions) (interface{}, error) {
 	converted, ok := item.(map[string]interface{})
 	if !ok {
 		return nil, errors.Errorf("invalid data to marshal. item=%v", item)
@@ -65,7 +136,7 @@ func MarshalDynamo(item interface{}) (interface{}, error) {
 
 	result := make(map[string]interface{}, len(converted))
 	for k1, v1 := range converted {
-		v, err := MarshalDynamoValue(v1)
+		v, err := MarshalDynamoValueWithOptions(v1, opts)
 		if err != nil {
 			return nil, err
 		}
@@ -75,3 +146,104 @@ func MarshalDynamo(item interface{}) (interface{}, error) {
 
 	return result, nil
 }
+
+// encodeBinary converts a base64 string of binary attribute to the encoding
+func encodeBinary(v interface{}, encoding BinaryEncoding) (interface{}, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, errors.Errorf("invalid binary. value=%v", v)
+	}
+
+	switch encoding {
+	case BinaryBase64, "":
+		return s, nil
+	case BinaryHex:
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid base64 binary")
+		}
+		return hex.EncodeToString(b), nil
+	case BinaryWrapped:
+		return map[string]interface{}{BinaryWrapperKey: s}, nil
+	default:
+		return nil, errors.Errorf("unsupported binary encoding: %s", encoding)
+	}
+}
+
+// decodeBinary converts an encoded binary to bytes
+func decodeBinary(v interface{}, encoding BinaryEncoding) ([]byte, error) {
+	if encoding == BinaryWrapped {
+		if s, ok := unwrapBinary(v); ok {
+			return base64.StdEncoding.DecodeString(s)
+		}
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		return nil, errors.Errorf("%v is not a binary", v)
+	}
+
+	switch encoding {
+	case BinaryBase64, BinaryWrapped, "":
+		return base64.StdEncoding.DecodeString(s)
+	case BinaryHex:
+		return hex.DecodeString(s)
+	default:
+		return nil, errors.Errorf("unsupported binary encoding: %s", encoding)
+	}
+}
+
+// unwrapBinary returns the base64 string if v is an object written by BinaryWrapped
+func unwrapBinary(v interface{}) (string, bool) {
+	m, ok := v.(map[string]interface{})
+	if !ok || len(m) != 1 {
+		return "", false
+	}
+	s, ok := m[BinaryWrapperKey].(string)
+	return s, ok
+}
+
+// TypedDynamo makes DynamoDB JSON from dynamodb.Item, which keeps types of attributes.
+// e.g. {"pk": {"S": "value"}, "count": {"N": "1"}}
+// Unlike json.Marshal of dynamodb.AttributeValue, unset types are omitted.
+func TypedDynamo(item map[string]*dynamodb.AttributeValue) map[string]interface{} {
+	result := make(map[string]interface{}, len(item))
+	for k, v := range item {
+		result[k] = TypedDynamoValue(v)
+	}
+	return result
+}
+
+// TypedDynamoValue makes DynamoDB JSON from dynamodb.AttributeValue
+func TypedDynamoValue(av *dynamodb.AttributeValue) map[string]interface{} {
+	switch {
+	case av == nil:
+		return nil
+	case av.S != nil:
+		return map[string]interface{}{"S": *av.S}
+	case av.N != nil:
+		return map[string]interface{}{"N": *av.N}
+	case av.B != nil:
+		return map[string]interface{}{"B": av.B}
+	case av.BOOL != nil:
+		return map[string]interface{}{"BOOL": *av.BOOL}
+	case av.NULL != nil:
+		return map[string]interface{}{"NULL": *av.NULL}
+	case av.SS != nil:
+		return map[string]interface{}{"SS": av.SS}
+	case av.NS != nil:
+		return map[string]interface{}{"NS": av.NS}
+	case av.BS != nil:
+		return map[string]interface{}{"BS": av.BS}
+	case av.L != nil:
+		l := make([]interface{}, 0, len(av.L))
+		for _, e := range av.L {
+			l = append(l, TypedDynamoValue(e))
+		}
+		return map[string]interface{}{"L": l}
+	case av.M != nil:
+		return map[string]interface{}{"M": TypedDynamo(av.M)}
+	default:
+		return nil
+	}
+}